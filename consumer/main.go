@@ -2,7 +2,8 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"dlq"
+	"events"
 	"log"
 	"os"
 	"strings"
@@ -10,14 +11,6 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-// Простая структура лога (такая же как в продюсере)
-type LogMessage struct {
-	Timestamp string `json:"timestamp"`
-	Level     string `json:"level"`
-	Service   string `json:"service"`
-	Message   string `json:"message"`
-}
-
 func main() {
 	// Получаем настройки
 	servers := os.Getenv("KAFKA_BOOTSTRAP_SERVERS")
@@ -47,22 +40,31 @@ func main() {
 		GroupID: groupID,
 	})
 
+	// Нечитаемые сообщения уходят в <топик>.dlq
+	deadLetters := dlq.NewWriter(brokers, "consumer")
+	defer deadLetters.Close()
+
 	log.Printf("Консьюмер запущен, читаем из топика: %s", topic)
 
-	// Бесконечный цикл чтения сообщений
+	// Бесконечный цикл чтения сообщений. Offset коммитится после обработки:
+	// нечитаемое сообщение сначала должно попасть в DLQ
 	for {
 		// Читаем сообщение
-		message, err := reader.ReadMessage(context.Background())
+		message, err := reader.FetchMessage(context.Background())
 		if err != nil {
 			log.Printf("Ошибка чтения: %v", err)
 			continue
 		}
 
 		// Превращаем JSON обратно в структуру
-		var logMsg LogMessage
-		err = json.Unmarshal(message.Value, &logMsg)
+		var logMsg events.LogMessage
+		err = events.Decode(message.Value, &logMsg)
 		if err != nil {
 			log.Printf("Ошибка JSON: %v", err)
+			if err := deadLetters.Send(context.Background(), message, err); err != nil {
+				log.Fatalf("Сообщение не отправлено в DLQ: %v", err)
+			}
+			commit(reader, message)
 			continue
 		}
 
@@ -77,5 +79,12 @@ func main() {
 		default:
 			log.Printf("[%s] %s: %s", logMsg.Timestamp, logMsg.Service, logMsg.Message)
 		}
+		commit(reader, message)
+	}
+}
+
+func commit(reader *kafka.Reader, message kafka.Message) {
+	if err := reader.CommitMessages(context.Background(), message); err != nil {
+		log.Printf("Ошибка коммита offset: %v", err)
 	}
 }
@@ -0,0 +1,251 @@
+// dlq-tool показывает записи dead letter топика и возвращает их в исходный топик.
+//
+//	dlq-tool inspect -topic error-logs.dlq -stage aggregator -limit 20
+//	dlq-tool redrive -topic error-logs.dlq -partition 0 -from 120 -to 180
+package main
+
+import (
+	"context"
+	"dlq"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Отбор записей DLQ
+type filter struct {
+	stage     string
+	partition int   // партиция DLQ, -1 — все
+	from      int64 // offset в DLQ, с которого начинать
+	to        int64 // последний offset в DLQ включительно, -1 — до конца
+	limit     int
+}
+
+func (f *filter) matches(record *dlq.Record) bool {
+	return f.stage == "" || record.Stage == f.stage
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	servers := flags.String("brokers", getEnvOrDefault("KAFKA_BOOTSTRAP_SERVERS", "localhost:19092"), "брокеры Kafka через запятую")
+	topic := flags.String("topic", "", "dead letter топик (или исходный — суффикс .dlq добавится)")
+	var f filter
+	flags.StringVar(&f.stage, "stage", "", "только записи этой стадии")
+	flags.IntVar(&f.partition, "partition", -1, "только эта партиция DLQ")
+	flags.Int64Var(&f.from, "from", 0, "начальный offset в DLQ")
+	flags.Int64Var(&f.to, "to", -1, "последний offset в DLQ включительно")
+	flags.IntVar(&f.limit, "limit", 0, "не больше N записей (0 — без ограничения)")
+	dryRun := flags.Bool("dry-run", false, "redrive: только показать, что будет отправлено")
+	flags.Parse(os.Args[2:])
+
+	if *topic == "" {
+		usage()
+	}
+	if !strings.HasSuffix(*topic, dlq.Suffix) {
+		*topic = dlq.Topic(*topic)
+	}
+	brokers := strings.Split(*servers, ",")
+
+	var err error
+	switch command {
+	case "inspect":
+		err = inspect(brokers, *topic, &f)
+	case "redrive":
+		err = redrive(brokers, *topic, &f, *dryRun)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "использование: dlq-tool inspect|redrive -topic <топик>.dlq [-stage S] [-partition N] [-from OFFSET] [-to OFFSET] [-limit N] [-dry-run]")
+	os.Exit(2)
+}
+
+// Печатает записи DLQ с источником и причиной
+func inspect(brokers []string, topic string, f *filter) error {
+	shown := 0
+	err := scan(brokers, topic, f, func(message kafka.Message, record *dlq.Record) {
+		shown++
+		printRecord(message, record)
+	})
+	fmt.Printf("📋 Показано записей: %d\n", shown)
+	return err
+}
+
+// Отправляет записи DLQ в исходные топики. Записи из DLQ не удаляются,
+// поэтому повторный запуск стоит начинать с offset'а, напечатанного в итоге.
+func redrive(brokers []string, topic string, f *filter, dryRun bool) error {
+	// Hash по ключу: запись вернется в ту же партицию, что и при первой записи
+	writer := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      brokers,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: int(kafka.RequireAll),
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	defer writer.Close()
+
+	sent := 0
+	next := make(map[int]int64) // партиция DLQ → offset для следующего запуска
+	var writeErr error
+	err := scan(brokers, topic, f, func(message kafka.Message, record *dlq.Record) {
+		if writeErr != nil {
+			return
+		}
+		printRecord(message, record)
+		if dryRun {
+			return
+		}
+		if writeErr = writer.WriteMessages(context.Background(), record.Redrive()); writeErr != nil {
+			return
+		}
+		sent++
+		next[message.Partition] = message.Offset + 1
+	})
+	if writeErr != nil {
+		err = fmt.Errorf("ошибка записи в исходный топик: %w", writeErr)
+	}
+
+	if dryRun {
+		fmt.Printf("🔍 dry-run: записи не отправлены\n")
+	} else {
+		fmt.Printf("🔁 Повторно отправлено записей: %d\n", sent)
+	}
+	for _, partition := range sortedKeys(next) {
+		fmt.Printf("   партиция %d: следующий запуск -partition %d -from %d\n", partition, partition, next[partition])
+	}
+	return err
+}
+
+// Читает партиции DLQ от -from до конца (или -to) и передает подходящие записи в fn
+func scan(brokers []string, topic string, f *filter, fn func(kafka.Message, *dlq.Record)) error {
+	conn, err := kafka.Dial("tcp", brokers[0])
+	if err != nil {
+		return err
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	conn.Close()
+	if err != nil {
+		return fmt.Errorf("топик %s: %w", topic, err)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].ID < partitions[j].ID })
+
+	matched := 0
+	for _, partition := range partitions {
+		if f.partition >= 0 && partition.ID != f.partition {
+			continue
+		}
+		if f.limit > 0 && matched >= f.limit {
+			break
+		}
+
+		leader, err := kafka.DialLeader(context.Background(), "tcp", brokers[0], topic, partition.ID)
+		if err != nil {
+			return err
+		}
+		first, last, err := leader.ReadOffsets()
+		leader.Close()
+		if err != nil {
+			return err
+		}
+
+		start := max(first, f.from)
+		end := last
+		if f.to >= 0 && f.to+1 < end {
+			end = f.to + 1
+		}
+		if start >= end {
+			continue
+		}
+
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   brokers,
+			Topic:     topic,
+			Partition: partition.ID,
+			MaxWait:   500 * time.Millisecond,
+			// mapper пишет в DLQ в своих транзакциях
+			IsolationLevel: kafka.ReadCommitted,
+		})
+		if err := reader.SetOffset(start); err != nil {
+			reader.Close()
+			return err
+		}
+
+		for offset := start; offset < end; {
+			// Последние offset'ы могут занимать маркеры транзакций, которые
+			// reader не возвращает, — выходим, если записей больше нет
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			message, err := reader.ReadMessage(ctx)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				reader.Close()
+				return err
+			}
+			offset = message.Offset + 1
+			if message.Offset >= end {
+				break
+			}
+
+			record, err := dlq.Parse(message)
+			if err != nil {
+				log.Printf("⚠️  %s[%d]@%d: %v", topic, message.Partition, message.Offset, err)
+				continue
+			}
+			if !f.matches(record) {
+				continue
+			}
+			fn(message, record)
+			matched++
+			if f.limit > 0 && matched >= f.limit {
+				break
+			}
+		}
+		reader.Close()
+	}
+	return nil
+}
+
+func printRecord(message kafka.Message, record *dlq.Record) {
+	fmt.Printf("\n☠️  %s[%d]@%d ← %s[%d]@%d\n",
+		message.Topic, message.Partition, message.Offset, record.Topic, record.Partition, record.Offset)
+	fmt.Printf("   стадия: %s, %s\n", record.Stage, record.Time.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("   ошибка: %s\n", record.Error)
+	if len(record.Key) > 0 {
+		fmt.Printf("   ключ:   %s\n", record.Key)
+	}
+	fmt.Printf("   запись: %s\n", record.Value)
+}
+
+func sortedKeys(m map[int]int64) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,255 @@
+// Package dlq — общий dead letter topic для всех стадий обработки.
+//
+// Запись, которую стадия не смогла разобрать или обработать, без изменений
+// уходит в топик <исходный топик>.dlq. В заголовках сохраняются источник
+// (топик, партиция, offset), имя стадии, текст ошибки и время. Утилита
+// dlq-tool показывает такие записи и возвращает их в исходный топик.
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"topics"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Суффикс dead letter топика
+const Suffix = ".dlq"
+
+// Наибольшая пауза между повторами отправки в DLQ
+const maxBackoff = 5 * time.Second
+
+// Заголовки записи в dead letter топике
+const (
+	HeaderTopic     = "dlq-source-topic"
+	HeaderPartition = "dlq-source-partition"
+	HeaderOffset    = "dlq-source-offset"
+	HeaderStage     = "dlq-stage"
+	HeaderError     = "dlq-error"
+	HeaderTimestamp = "dlq-timestamp"
+)
+
+// Topic возвращает dead letter топик для исходного топика
+func Topic(source string) string {
+	return source + Suffix
+}
+
+// Запись, которую стадия не смогла обработать
+type Record struct {
+	Topic     string // исходный топик
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []kafka.Header // заголовки исходной записи
+	Stage     string
+	Error     string
+	Time      time.Time
+}
+
+// NewRecord описывает исходную запись и причину, по которой она не обработана
+func NewRecord(message kafka.Message, stage string, cause error) *Record {
+	return &Record{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   message.Headers,
+		Stage:     stage,
+		Error:     cause.Error(),
+		Time:      time.Now(),
+	}
+}
+
+// DLQHeaders возвращает заголовки исходной записи вместе с заголовками dlq-*.
+// Заголовки dlq-* от прошлых попаданий в DLQ заменяются.
+func (r *Record) DLQHeaders() []kafka.Header {
+	headers := originalHeaders(r.Headers)
+	return append(headers,
+		kafka.Header{Key: HeaderTopic, Value: []byte(r.Topic)},
+		kafka.Header{Key: HeaderPartition, Value: []byte(strconv.Itoa(r.Partition))},
+		kafka.Header{Key: HeaderOffset, Value: []byte(strconv.FormatInt(r.Offset, 10))},
+		kafka.Header{Key: HeaderStage, Value: []byte(r.Stage)},
+		kafka.Header{Key: HeaderError, Value: []byte(r.Error)},
+		kafka.Header{Key: HeaderTimestamp, Value: []byte(r.Time.Format(time.RFC3339Nano))},
+	)
+}
+
+// Message возвращает запись для dead letter топика: исходные ключ и значение
+func (r *Record) Message() kafka.Message {
+	return kafka.Message{
+		Topic:   Topic(r.Topic),
+		Key:     r.Key,
+		Value:   r.Value,
+		Headers: r.DLQHeaders(),
+	}
+}
+
+// Redrive возвращает запись для повторной отправки в исходный топик.
+// Заголовки dlq-* снимаются, чтобы стадия видела запись как новую.
+func (r *Record) Redrive() kafka.Message {
+	return kafka.Message{
+		Topic:   r.Topic,
+		Key:     r.Key,
+		Value:   r.Value,
+		Headers: originalHeaders(r.Headers),
+	}
+}
+
+// Parse восстанавливает описание записи из dead letter топика
+func Parse(message kafka.Message) (*Record, error) {
+	record := &Record{
+		Key:   message.Key,
+		Value: message.Value,
+	}
+	values := make(map[string]string)
+	for _, header := range message.Headers {
+		if strings.HasPrefix(header.Key, "dlq-") {
+			values[header.Key] = string(header.Value)
+		} else {
+			record.Headers = append(record.Headers, header)
+		}
+	}
+
+	record.Topic = values[HeaderTopic]
+	if record.Topic == "" {
+		return nil, fmt.Errorf("нет заголовка %s", HeaderTopic)
+	}
+	record.Stage = values[HeaderStage]
+	record.Error = values[HeaderError]
+
+	var err error
+	if record.Partition, err = strconv.Atoi(values[HeaderPartition]); err != nil {
+		return nil, fmt.Errorf("заголовок %s: %w", HeaderPartition, err)
+	}
+	if record.Offset, err = strconv.ParseInt(values[HeaderOffset], 10, 64); err != nil {
+		return nil, fmt.Errorf("заголовок %s: %w", HeaderOffset, err)
+	}
+	if record.Time, err = time.Parse(time.RFC3339Nano, values[HeaderTimestamp]); err != nil {
+		return nil, fmt.Errorf("заголовок %s: %w", HeaderTimestamp, err)
+	}
+	return record, nil
+}
+
+func originalHeaders(headers []kafka.Header) []kafka.Header {
+	var result []kafka.Header
+	for _, header := range headers {
+		if !strings.HasPrefix(header.Key, "dlq-") {
+			result = append(result, header)
+		}
+	}
+	return result
+}
+
+// Writer отправляет необработанные записи одной стадии в dead letter топики.
+// Топик берется из исходной записи, поэтому один Writer обслуживает все
+// топики, которые читает стадия.
+type Writer struct {
+	stage  string
+	writer messageWriter
+	ensure func(ctx context.Context, source string) error // создает Topic(source)
+
+	mu    sync.Mutex
+	ready map[string]bool // исходные топики, для которых DLQ уже есть
+}
+
+// Запись в Kafka; в тестах подменяется
+type messageWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+func NewWriter(brokers []string, stage string) *Writer {
+	client := topics.NewClient(brokers)
+	return &Writer{
+		stage: stage,
+		writer: kafka.NewWriter(kafka.WriterConfig{
+			Brokers:      brokers,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 10 * time.Millisecond, // запись синхронная и не должна надолго задерживать стадию
+		}),
+		ensure: func(ctx context.Context, source string) error {
+			return ensureTopic(ctx, client, source)
+		},
+		ready: make(map[string]bool),
+	}
+}
+
+// Send отправляет запись в DLQ и повторяет отправку с паузой, пока она не
+// удастся: offset исходной записи можно коммитить, только когда запись уже
+// в DLQ. Ошибка возвращается, только если ctx отменен, — тогда offset
+// коммитить нельзя, запись прочитается заново.
+func (w *Writer) Send(ctx context.Context, message kafka.Message, cause error) error {
+	record := NewRecord(message, w.stage, cause)
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := w.prepare(ctx, message.Topic)
+		if err == nil {
+			err = w.writer.WriteMessages(ctx, record.Message())
+		}
+		if err == nil {
+			break
+		}
+		log.Printf("❌ Ошибка записи в %s (попытка %d): %v", Topic(message.Topic), attempt, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("запись %s[%d]@%d не отправлена в DLQ: %w",
+				message.Topic, message.Partition, message.Offset, ctx.Err())
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+	log.Printf("☠️  %s[%d]@%d → %s: %v", message.Topic, message.Partition, message.Offset, Topic(message.Topic), cause)
+	return nil
+}
+
+// Проверяет DLQ исходного топика перед первой записью в него
+func (w *Writer) prepare(ctx context.Context, source string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ready[source] {
+		return nil
+	}
+	if err := w.ensure(ctx, source); err != nil {
+		return err
+	}
+	w.ready[source] = true
+	return nil
+}
+
+// Создает DLQ с тем же числом партиций, что и у исходного топика. Writer
+// kafka-go не просит брокер создать топик при записи, поэтому без этого
+// первая же необработанная запись повторялась бы бесконечно.
+func ensureTopic(ctx context.Context, client *kafka.Client, source string) error {
+	topic := Topic(source)
+	existing, err := topics.Partitions(ctx, client, topic)
+	if err != nil || len(existing) > 0 {
+		return err
+	}
+
+	partitions, err := topics.Partitions(ctx, client, source)
+	if err != nil {
+		return err
+	}
+	numPartitions := len(partitions)
+	if numPartitions == 0 {
+		numPartitions = -1 // num.partitions брокера
+	}
+	log.Printf("🆕 Создаем dead letter топик %s", topic)
+	return topics.Create(ctx, client, kafka.TopicConfig{
+		Topic:             topic,
+		NumPartitions:     numPartitions,
+		ReplicationFactor: -1,
+	})
+}
+
+func (w *Writer) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,145 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Брокер без автосоздания топиков: запись в несуществующий топик — ошибка
+type fakeBroker struct {
+	mu       sync.Mutex
+	topics   map[string][]kafka.Message
+	creates  int
+	failures int // сколько следующих созданий топика завершится ошибкой
+}
+
+func newFakeBroker(existing ...string) *fakeBroker {
+	broker := &fakeBroker{topics: make(map[string][]kafka.Message)}
+	for _, topic := range existing {
+		broker.topics[topic] = nil
+	}
+	return broker
+}
+
+func (b *fakeBroker) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, message := range messages {
+		if _, ok := b.topics[message.Topic]; !ok {
+			return kafka.UnknownTopicOrPartition
+		}
+		b.topics[message.Topic] = append(b.topics[message.Topic], message)
+	}
+	return nil
+}
+
+func (b *fakeBroker) Close() error { return nil }
+
+func (b *fakeBroker) ensure(ctx context.Context, source string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.creates++
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("брокер недоступен")
+	}
+	if _, ok := b.topics[Topic(source)]; !ok {
+		b.topics[Topic(source)] = nil
+	}
+	return nil
+}
+
+func newTestWriter(broker *fakeBroker) *Writer {
+	return &Writer{stage: "test", writer: broker, ensure: broker.ensure, ready: make(map[string]bool)}
+}
+
+func badRecord(offset int64) kafka.Message {
+	return kafka.Message{Topic: "application-logs", Partition: 1, Offset: offset, Key: []byte("api"), Value: []byte("{broken")}
+}
+
+func TestSendCreatesMissingTopic(t *testing.T) {
+	broker := newFakeBroker("application-logs")
+	writer := newTestWriter(broker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := writer.Send(ctx, badRecord(42), errors.New("неверный JSON")); err != nil {
+		t.Fatal(err)
+	}
+
+	written := broker.topics["application-logs.dlq"]
+	if len(written) != 1 {
+		t.Fatalf("в DLQ %d записей, ожидали 1", len(written))
+	}
+	record, err := Parse(written[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Topic != "application-logs" || record.Partition != 1 || record.Offset != 42 ||
+		record.Stage != "test" || record.Error != "неверный JSON" || string(record.Value) != "{broken" {
+		t.Errorf("запись %+v", record)
+	}
+
+	// Топик проверяется один раз
+	if err := writer.Send(ctx, badRecord(43), errors.New("неверный JSON")); err != nil {
+		t.Fatal(err)
+	}
+	if broker.creates != 1 || len(broker.topics["application-logs.dlq"]) != 2 {
+		t.Errorf("созданий топика %d, записей %d", broker.creates, len(broker.topics["application-logs.dlq"]))
+	}
+}
+
+func TestSendRetriesTopicCreation(t *testing.T) {
+	broker := newFakeBroker("application-logs")
+	broker.failures = 2
+	writer := newTestWriter(broker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := writer.Send(ctx, badRecord(7), errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if broker.creates != 3 || len(broker.topics["application-logs.dlq"]) != 1 {
+		t.Errorf("созданий топика %d, записей %d", broker.creates, len(broker.topics["application-logs.dlq"]))
+	}
+}
+
+func TestSendStopsOnCancel(t *testing.T) {
+	broker := newFakeBroker("application-logs")
+	broker.failures = 1 << 30
+	writer := newTestWriter(broker)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := writer.Send(ctx, badRecord(7), errors.New("boom")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ожидали ошибку отмены, получили %v", err)
+	}
+	if len(broker.topics["application-logs.dlq"]) != 0 {
+		t.Error("запись отправлена без топика")
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	message := badRecord(5)
+	message.Headers = []kafka.Header{{Key: "trace_id", Value: []byte("abc")}, {Key: HeaderStage, Value: []byte("old")}}
+
+	record := NewRecord(message, "mapper", errors.New("boom"))
+	parsed, err := Parse(record.Message())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Stage != "mapper" || len(parsed.Headers) != 1 || parsed.Headers[0].Key != "trace_id" {
+		t.Errorf("запись %+v", parsed)
+	}
+
+	// При повторной отправке заголовки dlq-* снимаются
+	redriven := parsed.Redrive()
+	if redriven.Topic != "application-logs" || len(redriven.Headers) != 1 {
+		t.Errorf("повтор %+v", redriven)
+	}
+}
@@ -0,0 +1,15 @@
+module dlq
+
+go 1.23.3
+
+require (
+	github.com/segmentio/kafka-go v0.4.47
+	topics v0.0.0
+)
+
+require (
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+)
+
+replace topics => ../topics
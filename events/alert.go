@@ -0,0 +1,83 @@
+package events
+
+import "fmt"
+
+// Версия схемы алертов
+const AlertVersion = 1
+
+// Статусы алерта: один инцидент — одна запись firing и одна resolved с тем же alert_id
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Метрика, по которой сработал алерт
+const MetricErrorRate = "error_rate_per_minute"
+
+// Алерт об аномальной частоте ошибок сервиса (пишет anomaly-detector в alerts)
+type Alert struct {
+	SchemaVersion int     `json:"schema_version,omitempty"`
+	AlertID       string  `json:"alert_id"` // <service>/<начало первого аномального окна>, ключ записи
+	Status        string  `json:"status"`
+	Service       string  `json:"service"`
+	Metric        string  `json:"metric"`
+	Value         float64 `json:"value"`     // значение в последнем окне
+	Baseline      float64 `json:"baseline"`  // ожидаемое значение (EWMA) до этого окна
+	StdDev        float64 `json:"stddev"`    // ожидаемый разброс до этого окна
+	ZScore        float64 `json:"z_score"`   // (value - baseline) / stddev
+	Threshold     float64 `json:"threshold"` // z-score, при котором алерт срабатывает
+	PeakValue     float64 `json:"peak_value"`
+	PeakZScore    float64 `json:"peak_z_score"`
+	Windows       int     `json:"windows"` // окон с начала инцидента
+	WindowStart   string  `json:"window_start"`
+	WindowEnd     string  `json:"window_end"`
+	FiredAt       string  `json:"fired_at"`              // начало первого аномального окна
+	ResolvedAt    string  `json:"resolved_at,omitempty"` // конец окна, после которого инцидент закрыт
+	GeneratedAt   string  `json:"generated_at"`
+}
+
+func (a *Alert) schema() (*int, int) { return &a.SchemaVersion, AlertVersion }
+
+// Validate проверяет статус, окно и значения
+func (a *Alert) Validate() error {
+	if err := requireField("alert_id", a.AlertID); err != nil {
+		return err
+	}
+	if err := requireField("service", a.Service); err != nil {
+		return err
+	}
+	if err := requireField("metric", a.Metric); err != nil {
+		return err
+	}
+	if err := requireTime("window_start", a.WindowStart); err != nil {
+		return err
+	}
+	if err := requireTime("window_end", a.WindowEnd); err != nil {
+		return err
+	}
+	if err := requireTime("fired_at", a.FiredAt); err != nil {
+		return err
+	}
+	switch a.Status {
+	case AlertFiring:
+		if a.ResolvedAt != "" {
+			return fmt.Errorf("у алерта firing заполнено поле resolved_at")
+		}
+	case AlertResolved:
+		if err := requireTime("resolved_at", a.ResolvedAt); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("неизвестный статус алерта %q", a.Status)
+	}
+	if a.Value < 0 || a.PeakValue < a.Value {
+		return fmt.Errorf("значения алерта некорректны: value=%.2f peak_value=%.2f", a.Value, a.PeakValue)
+	}
+	if a.StdDev <= 0 {
+		return fmt.Errorf("поле stddev должно быть положительным: %g", a.StdDev)
+	}
+	if a.Windows < 1 {
+		return fmt.Errorf("поле windows должно быть положительным: %d", a.Windows)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Package events — общие типы сообщений всех сервисов и их кодеки.
+//
+// Любое изменение схемы делается здесь, а сервисы подключают пакет через
+// replace-директиву в своем go.mod, поэтому расхождения ловятся при сборке.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Формат времени, который используют все сервисы в строковых полях
+const TimeLayout = "2006-01-02 15:04:05"
+
+// Ошибка для сообщений, записанных более новой версией схемы
+var ErrUnsupportedVersion = errors.New("неподдерживаемая версия схемы")
+
+// Event — сообщение, которое умеет проверять себя и знает версию своей схемы
+type Event interface {
+	Validate() error
+	schema() (version *int, current int)
+}
+
+// Encode проставляет текущую версию схемы, проверяет сообщение и сериализует его
+func Encode(e Event) ([]byte, error) {
+	version, current := e.schema()
+	*version = current
+
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+// Decode разбирает JSON в сообщение и проверяет версию и содержимое.
+// Сообщения без версии считаются записанными первой версией схемы.
+func Decode(data []byte, e Event) error {
+	if err := json.Unmarshal(data, e); err != nil {
+		return err
+	}
+
+	version, current := e.schema()
+	if *version > current {
+		return fmt.Errorf("%w: %d (поддерживается до %d)", ErrUnsupportedVersion, *version, current)
+	}
+	if *version == 0 {
+		*version = 1
+	}
+
+	return e.Validate()
+}
+
+// FormatTime форматирует время в общем формате сообщений
+func FormatTime(t time.Time) string {
+	return t.Format(TimeLayout)
+}
+
+// ParseTime разбирает время из строкового поля сообщения
+func ParseTime(value string) (time.Time, error) {
+	return time.ParseInLocation(TimeLayout, value, time.Local)
+}
+
+func requireField(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("поле %s не заполнено", name)
+	}
+	return nil
+}
+
+func requireTime(name, value string) error {
+	if err := requireField(name, value); err != nil {
+		return err
+	}
+	if _, err := ParseTime(value); err != nil {
+		return fmt.Errorf("поле %s: %w", name, err)
+	}
+	return nil
+}
+
+func requireDuration(name, value string) error {
+	if err := requireField(name, value); err != nil {
+		return err
+	}
+	if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+		return fmt.Errorf("поле %s: ожидается положительная длительность, получено %q", name, value)
+	}
+	return nil
+}
+
+func requirePercent(name string, value float64) error {
+	if value < 0 || value > 100 {
+		return fmt.Errorf("поле %s вне диапазона 0-100: %.1f", name, value)
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+module events
+
+go 1.23.3
+
+require github.com/google/uuid v1.6.0
@@ -0,0 +1,122 @@
+package events
+
+import "fmt"
+
+// Версии схем логов
+const (
+	LogMessageVersion    = 1
+	ErrorLogVersion      = 3 // v2: fingerprint, template; v3: attributes
+	EnrichedErrorVersion = 3 // v2: join_status, metrics_age считается от времени ошибки; v3: owner
+)
+
+// Результат join'а ошибки с метриками
+const (
+	JoinMatched   = "matched"              // найден образец метрик в окне
+	JoinNoMetrics = "no_metrics_in_window" // в окне нет ни одного образца метрик
+)
+
+// Исходный лог приложения (пишет producer в application-logs)
+type LogMessage struct {
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	Timestamp     string `json:"timestamp"`
+	Level         string `json:"level"`
+	Service       string `json:"service"`
+	Message       string `json:"message"`
+}
+
+func (m *LogMessage) schema() (*int, int) { return &m.SchemaVersion, LogMessageVersion }
+
+// Validate проверяет обязательные поля лога
+func (m *LogMessage) Validate() error {
+	if err := requireTime("timestamp", m.Timestamp); err != nil {
+		return err
+	}
+	if err := requireField("level", m.Level); err != nil {
+		return err
+	}
+	return requireField("service", m.Service)
+}
+
+// Упрощенный ERROR лог (пишет mapper в error-logs)
+type ErrorLog struct {
+	SchemaVersion int            `json:"schema_version,omitempty"`
+	Timestamp     string         `json:"timestamp"`
+	Service       string         `json:"service"`
+	Error         string         `json:"error"`
+	ProcessedAt   string         `json:"processed_at"`
+	Fingerprint   string         `json:"fingerprint,omitempty"` // хэш шаблона сообщения
+	Template      string         `json:"template,omitempty"`    // шаблон сообщения, переменные части заменены на <*>
+	Attributes    map[string]any `json:"attributes,omitempty"`  // произвольные поля: строки, числа, bool
+}
+
+func (e *ErrorLog) schema() (*int, int) { return &e.SchemaVersion, ErrorLogVersion }
+
+// Validate проверяет обязательные поля ERROR лога
+func (e *ErrorLog) Validate() error {
+	if err := requireTime("timestamp", e.Timestamp); err != nil {
+		return err
+	}
+	if err := requireField("service", e.Service); err != nil {
+		return err
+	}
+	if e.Fingerprint != "" {
+		if err := requireField("template", e.Template); err != nil {
+			return err
+		}
+	}
+	for name, value := range e.Attributes {
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return fmt.Errorf("атрибут %s: ожидается строка, число или bool, получено %T", name, value)
+		}
+	}
+	return requireField("error", e.Error)
+}
+
+// Обогащенная ошибка — ERROR лог + метрики (пишет join-processor в enriched-errors)
+type EnrichedError struct {
+	ErrorLog
+	SchemaVersion int             `json:"schema_version,omitempty"`
+	Metrics       *ServiceMetrics `json:"metrics,omitempty"`
+	JoinedAt      string          `json:"joined_at"`
+	JoinStatus    string          `json:"join_status,omitempty"` // JoinMatched / JoinNoMetrics
+	JoinWindow    string          `json:"join_window,omitempty"` // ±интервал поиска метрик
+	MetricsAge    string          `json:"metrics_age,omitempty"` // Сдвиг метрик относительно ошибки
+	Owner         *ServiceOwner   `json:"owner,omitempty"`       // Владелец сервиса из справочника
+}
+
+// Данные о владельце сервиса (справочник service_owners в Postgres)
+type ServiceOwner struct {
+	Team       string `json:"team"`
+	OnCall     string `json:"on_call,omitempty"`
+	Tier       string `json:"tier,omitempty"`
+	RunbookURL string `json:"runbook_url,omitempty"`
+	Stale      bool   `json:"stale,omitempty"` // справочник недоступен, данные из кэша могли устареть
+}
+
+func (e *EnrichedError) schema() (*int, int) { return &e.SchemaVersion, EnrichedErrorVersion }
+
+// Validate проверяет ошибку и приложенные к ней метрики
+func (e *EnrichedError) Validate() error {
+	if err := e.ErrorLog.Validate(); err != nil {
+		return err
+	}
+	if err := requireTime("joined_at", e.JoinedAt); err != nil {
+		return err
+	}
+	if e.JoinStatus == JoinMatched && e.Metrics == nil {
+		return fmt.Errorf("join_status=%s, но метрики не приложены", e.JoinStatus)
+	}
+	if e.Metrics != nil {
+		if err := e.Metrics.Validate(); err != nil {
+			return fmt.Errorf("metrics: %w", err)
+		}
+	}
+	if e.Owner != nil {
+		if err := requireField("owner.team", e.Owner.Team); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package events
+
+import "fmt"
+
+// Версия схемы метрик
+const ServiceMetricsVersion = 1
+
+// Метрики сервиса (пишет metrics-producer в service-metrics)
+type ServiceMetrics struct {
+	SchemaVersion int     `json:"schema_version,omitempty"`
+	Timestamp     string  `json:"timestamp"`
+	Service       string  `json:"service"`
+	CPUUsage      float64 `json:"cpu_usage"`     // процент
+	MemoryUsage   float64 `json:"memory_usage"`  // процент
+	LatencyMs     int     `json:"latency_ms"`    // миллисекунды
+	RequestCount  int     `json:"request_count"` // запросов в секунду
+	GeneratedAt   string  `json:"generated_at"`
+}
+
+func (m *ServiceMetrics) schema() (*int, int) { return &m.SchemaVersion, ServiceMetricsVersion }
+
+// Validate проверяет метрики на корректные значения
+func (m *ServiceMetrics) Validate() error {
+	if err := requireTime("timestamp", m.Timestamp); err != nil {
+		return err
+	}
+	if err := requireField("service", m.Service); err != nil {
+		return err
+	}
+	if err := requirePercent("cpu_usage", m.CPUUsage); err != nil {
+		return err
+	}
+	if err := requirePercent("memory_usage", m.MemoryUsage); err != nil {
+		return err
+	}
+	if m.LatencyMs < 0 {
+		return fmt.Errorf("поле latency_ms отрицательное: %d", m.LatencyMs)
+	}
+	if m.RequestCount < 0 {
+		return fmt.Errorf("поле request_count отрицательное: %d", m.RequestCount)
+	}
+	return nil
+}
+
+// Версия схемы сводки метрик
+const ServiceMetricsRollupVersion = 1
+
+// Сводка метрик сервиса за окно (пишет aggregator в режиме metrics в service-metrics-rollup)
+type ServiceMetricsRollup struct {
+	SchemaVersion int            `json:"schema_version,omitempty"`
+	WindowStart   string         `json:"window_start"`
+	WindowEnd     string         `json:"window_end"`
+	WindowType    string         `json:"window_type"`
+	WindowSize    string         `json:"window_size"`
+	WindowAdvance string         `json:"window_advance,omitempty"` // шаг hopping окон
+	Service       string         `json:"service"`
+	Samples       int            `json:"samples"`        // образцов метрик в окне
+	LatencyP50Ms  float64        `json:"latency_p50_ms"` // миллисекунды
+	LatencyP90Ms  float64        `json:"latency_p90_ms"` // миллисекунды
+	LatencyP99Ms  float64        `json:"latency_p99_ms"` // миллисекунды
+	LatencyMaxMs  float64        `json:"latency_max_ms"` // миллисекунды
+	CPUMean       float64        `json:"cpu_mean"`       // процент
+	MemoryMean    float64        `json:"memory_mean"`    // процент
+	RequestsTotal int            `json:"requests_total"` // сумма request_count по образцам
+	LatencySketch *LatencySketch `json:"latency_sketch"` // для объединения окон потребителем
+	GeneratedAt   string         `json:"generated_at"`
+}
+
+func (r *ServiceMetricsRollup) schema() (*int, int) {
+	return &r.SchemaVersion, ServiceMetricsRollupVersion
+}
+
+// Validate проверяет окно, порядок квантилей и скетч
+func (r *ServiceMetricsRollup) Validate() error {
+	if err := requireTime("window_start", r.WindowStart); err != nil {
+		return err
+	}
+	if err := requireTime("window_end", r.WindowEnd); err != nil {
+		return err
+	}
+	if err := requireField("service", r.Service); err != nil {
+		return err
+	}
+	if r.Samples <= 0 {
+		return fmt.Errorf("в сводке нет образцов: %d", r.Samples)
+	}
+	if err := requirePercent("cpu_mean", r.CPUMean); err != nil {
+		return err
+	}
+	if err := requirePercent("memory_mean", r.MemoryMean); err != nil {
+		return err
+	}
+	if r.RequestsTotal < 0 {
+		return fmt.Errorf("поле requests_total отрицательное: %d", r.RequestsTotal)
+	}
+	if r.LatencyP50Ms > r.LatencyP90Ms || r.LatencyP90Ms > r.LatencyP99Ms || r.LatencyP99Ms > r.LatencyMaxMs {
+		return fmt.Errorf("квантили задержки не по возрастанию: p50=%.1f p90=%.1f p99=%.1f max=%.1f",
+			r.LatencyP50Ms, r.LatencyP90Ms, r.LatencyP99Ms, r.LatencyMaxMs)
+	}
+	if r.LatencySketch == nil {
+		return fmt.Errorf("поле latency_sketch не заполнено")
+	}
+	if err := r.LatencySketch.Validate(); err != nil {
+		return fmt.Errorf("latency_sketch: %w", err)
+	}
+	if r.LatencySketch.Count != uint64(r.Samples) {
+		return fmt.Errorf("в скетче %d значений, а образцов %d", r.LatencySketch.Count, r.Samples)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Версия схемы событий репликации
+const ReplicationMessageVersion = 1
+
+// Событие репликации (outbox-publisher → NATS → inbox-processor)
+type ReplicationMessage struct {
+	SchemaVersion int             `json:"schema_version,omitempty"`
+	EventID       uuid.UUID       `json:"event_id"`
+	AggregateID   uuid.UUID       `json:"aggregate_id"`
+	AggregateType string          `json:"aggregate_type"`
+	EventType     string          `json:"event_type"`
+	EventData     json.RawMessage `json:"event_data"`
+	OriginalTime  time.Time       `json:"original_time"`
+	PublishedAt   time.Time       `json:"published_at"`
+}
+
+func (m *ReplicationMessage) schema() (*int, int) { return &m.SchemaVersion, ReplicationMessageVersion }
+
+// Validate проверяет идентификаторы и данные события
+func (m *ReplicationMessage) Validate() error {
+	if m.EventID == uuid.Nil {
+		return errors.New("поле event_id не заполнено")
+	}
+	if m.AggregateID == uuid.Nil {
+		return errors.New("поле aggregate_id не заполнено")
+	}
+	if err := requireField("aggregate_type", m.AggregateType); err != nil {
+		return err
+	}
+	if err := requireField("event_type", m.EventType); err != nil {
+		return err
+	}
+	if !json.Valid(m.EventData) {
+		return errors.New("поле event_data содержит некорректный JSON")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Скетч распределения задержек с относительной точностью.
+//
+// Как HDR histogram, но с логарифмическими корзинами без верхней границы:
+// значение v попадает в корзину ceil(log_γ v), γ = (1+α)/(1-α), и любой
+// квантиль возвращается с относительной ошибкой не больше α. Скетчи с
+// одинаковой α складываются корзина к корзине, поэтому окна разных партиций
+// и соседние окна объединяются без потери точности.
+type LatencySketch struct {
+	RelativeAccuracy float64        `json:"relative_accuracy"` // α
+	Count            uint64         `json:"count"`
+	Zero             uint64         `json:"zero,omitempty"` // значения ≤ 0
+	Min              float64        `json:"min"`
+	Max              float64        `json:"max"`
+	Buckets          map[int]uint64 `json:"buckets"` // номер корзины → количество
+}
+
+func NewLatencySketch(relativeAccuracy float64) *LatencySketch {
+	return &LatencySketch{RelativeAccuracy: relativeAccuracy, Buckets: make(map[int]uint64)}
+}
+
+func (s *LatencySketch) gamma() float64 {
+	return (1 + s.RelativeAccuracy) / (1 - s.RelativeAccuracy)
+}
+
+// Add учитывает одно значение
+func (s *LatencySketch) Add(value float64) {
+	if s.Count == 0 || value < s.Min {
+		s.Min = value
+	}
+	if s.Count == 0 || value > s.Max {
+		s.Max = value
+	}
+	s.Count++
+
+	if value <= 0 {
+		s.Zero++
+		return
+	}
+	s.Buckets[int(math.Ceil(math.Log(value)/math.Log(s.gamma())))]++
+}
+
+// Merge добавляет значения другого скетча с той же точностью
+func (s *LatencySketch) Merge(other *LatencySketch) error {
+	if other.Count == 0 {
+		return nil
+	}
+	if other.RelativeAccuracy != s.RelativeAccuracy {
+		return fmt.Errorf("скетчи с разной точностью: %g и %g", s.RelativeAccuracy, other.RelativeAccuracy)
+	}
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Count += other.Count
+	s.Zero += other.Zero
+	for bucket, count := range other.Buckets {
+		s.Buckets[bucket] += count
+	}
+	return nil
+}
+
+// Quantile возвращает значение квантиля q (0..1)
+func (s *LatencySketch) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.Count-1))
+
+	seen := s.Zero
+	if rank < seen {
+		return math.Max(s.Min, 0)
+	}
+
+	buckets := make([]int, 0, len(s.Buckets))
+	for bucket := range s.Buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+
+	gamma := s.gamma()
+	for _, bucket := range buckets {
+		seen += s.Buckets[bucket]
+		if rank < seen {
+			// Середина корзины (γ^(i-1), γ^i] с относительной ошибкой не больше α
+			value := 2 * math.Pow(gamma, float64(bucket)) / (gamma + 1)
+			return math.Min(math.Max(value, s.Min), s.Max)
+		}
+	}
+	return s.Max
+}
+
+// Validate проверяет точность и согласованность счетчиков
+func (s *LatencySketch) Validate() error {
+	if s.RelativeAccuracy <= 0 || s.RelativeAccuracy >= 1 {
+		return fmt.Errorf("relative_accuracy вне диапазона (0, 1): %g", s.RelativeAccuracy)
+	}
+	total := s.Zero
+	for _, count := range s.Buckets {
+		total += count
+	}
+	if total != s.Count {
+		return fmt.Errorf("count=%d не совпадает с суммой по корзинам %d", s.Count, total)
+	}
+	if s.Count > 0 && s.Min > s.Max {
+		return fmt.Errorf("min %g больше max %g", s.Min, s.Max)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package events
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+var quantiles = []float64{0, 0.1, 0.5, 0.9, 0.95, 0.99, 0.999, 1}
+
+// Задержки с длинным хвостом: от долей миллисекунды до десятков секунд
+func latencies(n int, seed int64) []float64 {
+	random := rand.New(rand.NewSource(seed))
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.Exp(random.NormFloat64()*2 + 3)
+	}
+	return values
+}
+
+// Точный квантиль с тем же рангом, что у LatencySketch.Quantile
+func exactQuantile(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func checkQuantiles(t *testing.T, sketch *LatencySketch, values []float64) {
+	t.Helper()
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	for _, q := range quantiles {
+		exact, estimate := exactQuantile(sorted, q), sketch.Quantile(q)
+		if relative := math.Abs(estimate-exact) / exact; relative > sketch.RelativeAccuracy+1e-9 {
+			t.Errorf("α=%g q=%g: оценка %g, точно %g, ошибка %.3f%%",
+				sketch.RelativeAccuracy, q, estimate, exact, 100*relative)
+		}
+	}
+}
+
+func TestLatencySketchQuantileAccuracy(t *testing.T) {
+	values := latencies(50000, 1)
+	for _, accuracy := range []float64{0.01, 0.02, 0.05} {
+		sketch := NewLatencySketch(accuracy)
+		for _, value := range values {
+			sketch.Add(value)
+		}
+		checkQuantiles(t, sketch, values)
+		if sketch.Min != minOf(values) || sketch.Max != maxOf(values) {
+			t.Errorf("min/max %g/%g", sketch.Min, sketch.Max)
+		}
+	}
+}
+
+func TestLatencySketchMerge(t *testing.T) {
+	first, second := latencies(20000, 2), latencies(5000, 3)
+	for i := range second {
+		// Второе окно медленнее, чтобы хвосты различались
+		second[i] *= 10
+	}
+
+	a, b, all := NewLatencySketch(0.01), NewLatencySketch(0.01), NewLatencySketch(0.01)
+	for _, value := range first {
+		a.Add(value)
+		all.Add(value)
+	}
+	for _, value := range second {
+		b.Add(value)
+		all.Add(value)
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// Слияние не теряет точности: результат как у скетча по всем значениям
+	for _, q := range quantiles {
+		if a.Quantile(q) != all.Quantile(q) {
+			t.Errorf("q=%g: после слияния %g, по всем значениям %g", q, a.Quantile(q), all.Quantile(q))
+		}
+	}
+	checkQuantiles(t, a, append(first, second...))
+
+	if err := a.Merge(NewLatencySketch(0.02)); err != nil {
+		t.Errorf("пустой скетч с другой точностью: %v", err)
+	}
+	other := NewLatencySketch(0.02)
+	other.Add(1)
+	if err := a.Merge(other); err == nil {
+		t.Error("скетчи с разной точностью слиты без ошибки")
+	}
+}
+
+func TestLatencySketchMergeIntoEmpty(t *testing.T) {
+	source := NewLatencySketch(0.01)
+	for _, value := range []float64{5, 7, 9} {
+		source.Add(value)
+	}
+	empty := NewLatencySketch(0.01)
+	if err := empty.Merge(source); err != nil {
+		t.Fatal(err)
+	}
+	if empty.Count != 3 || empty.Min != 5 || empty.Max != 9 {
+		t.Errorf("после слияния в пустой: count=%d min=%g max=%g", empty.Count, empty.Min, empty.Max)
+	}
+}
+
+func TestLatencySketchZeroValues(t *testing.T) {
+	sketch := NewLatencySketch(0.01)
+	for _, value := range []float64{0, 0, 0, 10, 20} {
+		sketch.Add(value)
+	}
+	if got := sketch.Quantile(0.5); got != 0 {
+		t.Errorf("медиана %g, ожидали 0", got)
+	}
+	if got := sketch.Quantile(1); math.Abs(got-20)/20 > sketch.RelativeAccuracy {
+		t.Errorf("максимум %g, ожидали 20 ± 1%%", got)
+	}
+	if got := NewLatencySketch(0.01).Quantile(0.5); got != 0 {
+		t.Errorf("квантиль пустого скетча %g", got)
+	}
+}
+
+func TestLatencySketchValidate(t *testing.T) {
+	sketch := NewLatencySketch(0.01)
+	for _, value := range latencies(100, 4) {
+		sketch.Add(value)
+	}
+
+	// Скетч переживает запись в состояние и обратно
+	data, err := json.Marshal(sketch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored LatencySketch
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Quantile(0.99) != sketch.Quantile(0.99) {
+		t.Errorf("p99 после восстановления %g, до %g", restored.Quantile(0.99), sketch.Quantile(0.99))
+	}
+
+	restored.Count++
+	if err := restored.Validate(); err == nil {
+		t.Error("несовпадение count с корзинами не обнаружено")
+	}
+	if err := NewLatencySketch(1).Validate(); err == nil {
+		t.Error("точность 1 принята")
+	}
+}
+
+func minOf(values []float64) float64 {
+	result := values[0]
+	for _, value := range values[1:] {
+		result = math.Min(result, value)
+	}
+	return result
+}
+
+func maxOf(values []float64) float64 {
+	result := values[0]
+	for _, value := range values[1:] {
+		result = math.Max(result, value)
+	}
+	return result
+}
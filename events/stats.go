@@ -0,0 +1,201 @@
+package events
+
+import "fmt"
+
+// Версия схемы статистики
+const ErrorStatsVersion = 5 // v2: top_kinds; v3: window_type, window_size, window_advance, session_gap; v4: top_messages; v5: dimensions, aggregates, groups
+
+// Типы окон агрегации
+const (
+	WindowTumbling = "tumbling" // окна одной длины встык
+	WindowHopping  = "hopping"  // окна одной длины, сдвинутые на шаг меньше длины
+	WindowSession  = "session"  // серия ошибок сервиса до паузы длиннее session_gap
+)
+
+// Статистика ошибок за окно (пишет aggregator в error-stats)
+type ErrorStats struct {
+	SchemaVersion        int                       `json:"schema_version,omitempty"`
+	WindowStart          string                    `json:"window_start"`
+	WindowEnd            string                    `json:"window_end"`
+	WindowType           string                    `json:"window_type,omitempty"`    // пусто в v1-v2 — tumbling
+	WindowSize           string                    `json:"window_size,omitempty"`    // длина окна (tumbling, hopping)
+	WindowAdvance        string                    `json:"window_advance,omitempty"` // шаг hopping окон
+	SessionGap           string                    `json:"session_gap,omitempty"`    // пауза, закрывающая сессию
+	Services             map[string]int            `json:"services"`
+	TotalErrors          int                       `json:"total_errors"`
+	TopKinds             []ErrorKind               `json:"top_kinds,omitempty"`               // самые частые виды ошибок по убыванию
+	TopMessages          []MessageCount            `json:"top_messages,omitempty"`            // самые частые сообщения по убыванию
+	TopMessagesByService map[string][]MessageCount `json:"top_messages_by_service,omitempty"` // то же по сервисам
+	Dimensions           []string                  `json:"dimensions,omitempty"`              // поля, по которым сгруппированы groups
+	Aggregates           []Aggregate               `json:"aggregates,omitempty"`              // что посчитано в каждой группе
+	Groups               []GroupStats              `json:"groups,omitempty"`                  // по убыванию count
+	GroupsLimited        bool                      `json:"groups_limited,omitempty"`          // часть групп свернута в группу OtherGroup
+	GeneratedAt          string                    `json:"generated_at"`
+}
+
+// Функции агрегации групп
+const (
+	AggregateCount    = "count"    // число ошибок
+	AggregateDistinct = "distinct" // число различных значений поля (HyperLogLog, оценка)
+	AggregateMin      = "min"      // минимум числового поля
+	AggregateMax      = "max"      // максимум числового поля
+	AggregateSum      = "sum"      // сумма числового поля
+)
+
+// Значение измерения в группе, куда свернуты группы сверх лимита
+const OtherGroup = "__other__"
+
+// Агрегат группы: имя — ключ в GroupStats.Values, например "max(attributes.latency_ms)"
+type Aggregate struct {
+	Name     string `json:"name"`
+	Function string `json:"function"`
+	Field    string `json:"field,omitempty"`
+}
+
+// Группа ошибок с одинаковыми значениями измерений
+type GroupStats struct {
+	Key    map[string]string  `json:"key"` // измерение → значение, "" — поля нет
+	Count  int                `json:"count"`
+	Values map[string]float64 `json:"values,omitempty"` // имя агрегата → значение (кроме count); min/max нет, если поле не встретилось
+}
+
+// Частое сообщение об ошибке. Количество — оценка сверху (count-min sketch).
+type MessageCount struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// Вид ошибки — все сообщения с одним шаблоном
+type ErrorKind struct {
+	Fingerprint string `json:"fingerprint"`
+	Template    string `json:"template"`
+	Count       int    `json:"count"`
+}
+
+func (s *ErrorStats) schema() (*int, int) { return &s.SchemaVersion, ErrorStatsVersion }
+
+// Validate проверяет границы окна и счетчики
+func (s *ErrorStats) Validate() error {
+	if err := requireTime("window_start", s.WindowStart); err != nil {
+		return err
+	}
+	if err := requireTime("window_end", s.WindowEnd); err != nil {
+		return err
+	}
+	if s.WindowEnd < s.WindowStart {
+		return fmt.Errorf("окно заканчивается раньше начала: %s → %s", s.WindowStart, s.WindowEnd)
+	}
+	switch s.WindowType {
+	case "", WindowTumbling:
+	case WindowHopping:
+		if err := requireDuration("window_advance", s.WindowAdvance); err != nil {
+			return err
+		}
+	case WindowSession:
+		if err := requireDuration("session_gap", s.SessionGap); err != nil {
+			return err
+		}
+		if len(s.Services) > 1 {
+			return fmt.Errorf("session окно относится к одному сервису, а не к %d", len(s.Services))
+		}
+	default:
+		return fmt.Errorf("неизвестный тип окна %q", s.WindowType)
+	}
+
+	sum := 0
+	for service, count := range s.Services {
+		if count < 0 {
+			return fmt.Errorf("отрицательный счетчик для %s: %d", service, count)
+		}
+		sum += count
+	}
+	if sum != s.TotalErrors {
+		return fmt.Errorf("total_errors=%d не совпадает с суммой по сервисам %d", s.TotalErrors, sum)
+	}
+
+	kinds := 0
+	for _, kind := range s.TopKinds {
+		if err := requireField("top_kinds.fingerprint", kind.Fingerprint); err != nil {
+			return err
+		}
+		if kind.Count < 0 {
+			return fmt.Errorf("отрицательный счетчик для вида %s: %d", kind.Fingerprint, kind.Count)
+		}
+		kinds += kind.Count
+	}
+	if kinds > s.TotalErrors {
+		return fmt.Errorf("сумма по видам ошибок %d больше total_errors=%d", kinds, s.TotalErrors)
+	}
+
+	if err := validateMessages("top_messages", s.TopMessages, s.TotalErrors); err != nil {
+		return err
+	}
+	for service, messages := range s.TopMessagesByService {
+		if err := validateMessages("top_messages_by_service."+service, messages, s.Services[service]); err != nil {
+			return err
+		}
+	}
+	return s.validateGroups()
+}
+
+// Группы описываются dimensions и aggregates и вместе покрывают все ошибки окна
+func (s *ErrorStats) validateGroups() error {
+	if len(s.Groups) == 0 {
+		return nil
+	}
+	if len(s.Dimensions) == 0 {
+		return fmt.Errorf("группы без dimensions")
+	}
+
+	functions := make(map[string]string)
+	for _, aggregate := range s.Aggregates {
+		switch aggregate.Function {
+		case AggregateCount:
+		case AggregateDistinct, AggregateMin, AggregateMax, AggregateSum:
+			if err := requireField("aggregates.field", aggregate.Field); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("неизвестная функция агрегации %q", aggregate.Function)
+		}
+		functions[aggregate.Name] = aggregate.Function
+	}
+
+	sum := 0
+	for _, group := range s.Groups {
+		if len(group.Key) != len(s.Dimensions) {
+			return fmt.Errorf("в ключе группы %d полей, а dimensions — %d", len(group.Key), len(s.Dimensions))
+		}
+		for _, dimension := range s.Dimensions {
+			if _, ok := group.Key[dimension]; !ok {
+				return fmt.Errorf("в ключе группы нет измерения %s", dimension)
+			}
+		}
+		if group.Count <= 0 {
+			return fmt.Errorf("пустая группа %v", group.Key)
+		}
+		for name := range group.Values {
+			if _, ok := functions[name]; !ok {
+				return fmt.Errorf("значение %s не описано в aggregates", name)
+			}
+		}
+		sum += group.Count
+	}
+	if sum != s.TotalErrors {
+		return fmt.Errorf("total_errors=%d не совпадает с суммой по группам %d", s.TotalErrors, sum)
+	}
+	return nil
+}
+
+// Оценка частоты не может превышать число ошибок, из которых она получена
+func validateMessages(name string, messages []MessageCount, total int) error {
+	for _, message := range messages {
+		if err := requireField(name+".message", message.Message); err != nil {
+			return err
+		}
+		if message.Count <= 0 || message.Count > total {
+			return fmt.Errorf("%s: количество %d вне диапазона 1..%d", name, message.Count, total)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Запись входного топика с номером поколения группы, в котором ее прочитали
+type GroupMessage struct {
+	kafka.Message
+	Generation int32
+}
+
+// Чтение входного топика в consumer group с явными поколениями.
+//
+// kafka.Reader прячет ребалансы, а aggregator'у о них нужно знать: окна
+// отозванной партиции досчитает и отправит новый владелец, поэтому старый
+// должен забыть их без отправки. Поколения проходят через основной цикл:
+//   - новое поколение приходит в Generations до первой его записи;
+//   - в конце поколения в Revoked приходит канал, и группа не вступит
+//     в следующее поколение (и партиции не достанутся другим), пока
+//     основной цикл не сохранит состояние и не закроет этот канал.
+type GroupConsumer struct {
+	group  *kafka.ConsumerGroup
+	reader kafka.ReaderConfig // настройки читателей отдельных партиций
+
+	messages    chan GroupMessage
+	generations chan *kafka.Generation
+	revoked     chan chan struct{}
+}
+
+func NewGroupConsumer(config kafka.ReaderConfig) (*GroupConsumer, error) {
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:      config.GroupID,
+		Brokers: config.Brokers,
+		Dialer:  config.Dialer,
+		Topics:  []string{config.Topic},
+	})
+	if err != nil {
+		return nil, err
+	}
+	config.GroupID = ""
+	return &GroupConsumer{
+		group:       group,
+		reader:      config,
+		messages:    make(chan GroupMessage, 100),
+		generations: make(chan *kafka.Generation),
+		revoked:     make(chan chan struct{}),
+	}, nil
+}
+
+func (c *GroupConsumer) Messages() <-chan GroupMessage {
+	return c.messages
+}
+
+func (c *GroupConsumer) Generations() <-chan *kafka.Generation {
+	return c.generations
+}
+
+func (c *GroupConsumer) Revoked() <-chan chan struct{} {
+	return c.revoked
+}
+
+// Run вступает в группу и читает назначенные партиции до закрытия группы
+func (c *GroupConsumer) Run(ctx context.Context) {
+	for {
+		generation, err := c.group.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, kafka.ErrGroupClosed) {
+				return
+			}
+			log.Printf("❌ Ошибка consumer group: %v", err)
+			continue
+		}
+
+		c.generations <- generation
+		for _, assignment := range generation.Assignments[c.reader.Topic] {
+			generation.Start(func(ctx context.Context) {
+				c.readPartition(ctx, generation.ID, assignment)
+			})
+		}
+		generation.Start(func(ctx context.Context) {
+			<-ctx.Done()
+			done := make(chan struct{})
+			c.revoked <- done
+			<-done
+		})
+	}
+}
+
+func (c *GroupConsumer) readPartition(ctx context.Context, generation int32, assignment kafka.PartitionAssignment) {
+	config := c.reader
+	config.Partition = assignment.ID
+	reader := kafka.NewReader(config)
+	defer reader.Close()
+
+	if err := reader.SetOffset(assignment.Offset); err != nil {
+		log.Printf("❌ Партиция %d: ошибка установки offset %d: %v", assignment.ID, assignment.Offset, err)
+		return
+	}
+	for {
+		message, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("❌ Ошибка чтения партиции %d: %v", assignment.ID, err)
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case c.messages <- GroupMessage{Message: message, Generation: generation}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *GroupConsumer) Close() error {
+	return c.group.Close()
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"events"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Префикс полей из ErrorLog.Attributes
+const attributesPrefix = "attributes."
+
+// Разделитель значений измерений в ключе группы
+const keySeparator = "\x1f"
+
+// Настройка группировки ошибок: по каким полям и что считать в группе
+type GroupBy struct {
+	Dimensions []string
+	Aggregates []events.Aggregate
+	MaxGroups  int   // групп на окно партиции, остальные сворачиваются в events.OtherGroup
+	Precision  uint8 // точность HyperLogLog для distinct
+}
+
+// ParseGroupBy разбирает GROUP_BY ("service,fingerprint") и AGGREGATES
+// ("count,distinct(error),max(attributes.latency_ms)")
+func ParseGroupBy(dimensions, aggregates string, maxGroups, precision int) (*GroupBy, error) {
+	if maxGroups <= 0 {
+		return nil, fmt.Errorf("MAX_GROUPS должен быть положительным: %d", maxGroups)
+	}
+	if precision < 4 || precision > 16 {
+		return nil, fmt.Errorf("HLL_PRECISION вне диапазона 4..16: %d", precision)
+	}
+	groupBy := &GroupBy{MaxGroups: maxGroups, Precision: uint8(precision)}
+
+	seen := make(map[string]bool)
+	for _, dimension := range strings.Split(dimensions, ",") {
+		dimension = strings.TrimSpace(dimension)
+		if err := validateField(dimension); err != nil {
+			return nil, fmt.Errorf("GROUP_BY: %w", err)
+		}
+		if seen[dimension] {
+			return nil, fmt.Errorf("GROUP_BY: поле %s указано дважды", dimension)
+		}
+		seen[dimension] = true
+		groupBy.Dimensions = append(groupBy.Dimensions, dimension)
+	}
+
+	seen = make(map[string]bool)
+	for _, name := range strings.Split(aggregates, ",") {
+		aggregate, err := parseAggregate(strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("AGGREGATES: %w", err)
+		}
+		if seen[aggregate.Name] {
+			return nil, fmt.Errorf("AGGREGATES: %s указан дважды", aggregate.Name)
+		}
+		seen[aggregate.Name] = true
+		groupBy.Aggregates = append(groupBy.Aggregates, aggregate)
+	}
+	return groupBy, nil
+}
+
+// "count" или "<функция>(<поле>)"
+func parseAggregate(name string) (events.Aggregate, error) {
+	if name == events.AggregateCount {
+		return events.Aggregate{Name: name, Function: name}, nil
+	}
+
+	function, rest, ok := strings.Cut(name, "(")
+	field, closed := strings.CutSuffix(rest, ")")
+	if !ok || !closed {
+		return events.Aggregate{}, fmt.Errorf("ожидается count или функция(поле), получено %q", name)
+	}
+	switch function {
+	case events.AggregateDistinct:
+		if err := validateField(field); err != nil {
+			return events.Aggregate{}, err
+		}
+	case events.AggregateMin, events.AggregateMax, events.AggregateSum:
+		// Числа есть только в атрибутах
+		if !strings.HasPrefix(field, attributesPrefix) {
+			return events.Aggregate{}, fmt.Errorf("%s: числовое поле должно быть атрибутом (attributes.<имя>)", name)
+		}
+		if err := validateField(field); err != nil {
+			return events.Aggregate{}, err
+		}
+	default:
+		return events.Aggregate{}, fmt.Errorf("неизвестная функция %q (count, distinct, min, max, sum)", function)
+	}
+	return events.Aggregate{Name: name, Function: function, Field: field}, nil
+}
+
+func validateField(field string) error {
+	switch field {
+	case "service", "error", "fingerprint", "template":
+		return nil
+	}
+	if name, ok := strings.CutPrefix(field, attributesPrefix); ok && name != "" {
+		return nil
+	}
+	return fmt.Errorf("неизвестное поле %q (service, error, fingerprint, template, attributes.<имя>)", field)
+}
+
+// Значение поля ERROR лога; false — поля нет
+func fieldValue(errorLog *events.ErrorLog, field string) (any, bool) {
+	var value string
+	switch field {
+	case "service":
+		value = errorLog.Service
+	case "error":
+		value = errorLog.Error
+	case "fingerprint":
+		value = errorLog.Fingerprint
+	case "template":
+		value = errorLog.Template
+	default:
+		attribute, ok := errorLog.Attributes[strings.TrimPrefix(field, attributesPrefix)]
+		return attribute, ok
+	}
+	return value, value != ""
+}
+
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+func numericValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil && !math.IsNaN(number) && !math.IsInf(number, 0)
+	}
+	return 0, false
+}
+
+// Группа окна: значения измерений, число ошибок и состояние агрегатов
+type Group struct {
+	Key    []string          `json:"key"`
+	Count  int               `json:"count"`
+	Values []*AggregateState `json:"values,omitempty"` // в порядке GroupBy.Aggregates
+}
+
+// Состояние одного агрегата группы. Функция хранится рядом, чтобы окна
+// разных партиций можно было объединить без настроек.
+type AggregateState struct {
+	Function string       `json:"function"`
+	Set      bool         `json:"set,omitempty"` // min/max/sum: встречалось ли число
+	Value    float64      `json:"value,omitempty"`
+	Distinct *HyperLogLog `json:"distinct,omitempty"`
+}
+
+func (s *AggregateState) merge(other *AggregateState) {
+	if other.Function != s.Function {
+		return
+	}
+	switch s.Function {
+	case events.AggregateDistinct:
+		if s.Distinct == nil || other.Distinct == nil {
+			return
+		}
+		if err := s.Distinct.Merge(other.Distinct); err != nil {
+			log.Printf("⚠️  distinct не объединен: %v", err)
+		}
+		return
+	case events.AggregateCount:
+		return
+	}
+	if !other.Set {
+		return
+	}
+	s.observe(other.Value)
+}
+
+func (s *AggregateState) observe(value float64) {
+	switch {
+	case !s.Set:
+		s.Value = value
+	case s.Function == events.AggregateMin:
+		s.Value = math.Min(s.Value, value)
+	case s.Function == events.AggregateMax:
+		s.Value = math.Max(s.Value, value)
+	case s.Function == events.AggregateSum:
+		s.Value += value
+	}
+	s.Set = true
+}
+
+func (s *AggregateState) clone() *AggregateState {
+	clone := *s
+	if s.Distinct != nil {
+		clone.Distinct = s.Distinct.Clone()
+	}
+	return &clone
+}
+
+// Add учитывает ошибку в группах окна
+func (g *GroupBy) Add(groups map[string]*Group, errorLog *events.ErrorLog) {
+	key := make([]string, len(g.Dimensions))
+	for i, dimension := range g.Dimensions {
+		if value, ok := fieldValue(errorLog, dimension); ok {
+			key[i] = formatValue(value)
+		}
+	}
+
+	id := strings.Join(key, keySeparator)
+	group, ok := groups[id]
+	if !ok && len(groups) >= g.MaxGroups {
+		// Слишком много групп — свертываем в общую
+		for i := range key {
+			key[i] = events.OtherGroup
+		}
+		id = strings.Join(key, keySeparator)
+		group, ok = groups[id]
+	}
+	if !ok {
+		group = &Group{Key: key}
+		for _, aggregate := range g.Aggregates {
+			state := &AggregateState{Function: aggregate.Function}
+			if aggregate.Function == events.AggregateDistinct {
+				state.Distinct = NewHyperLogLog(g.Precision)
+			}
+			group.Values = append(group.Values, state)
+		}
+		groups[id] = group
+	}
+
+	group.Count++
+	if !g.matches(group) {
+		return
+	}
+	for i, aggregate := range g.Aggregates {
+		if aggregate.Function == events.AggregateCount {
+			continue
+		}
+		value, ok := fieldValue(errorLog, aggregate.Field)
+		if !ok {
+			continue
+		}
+		if aggregate.Function == events.AggregateDistinct {
+			group.Values[i].Distinct.Add(formatValue(value))
+		} else if number, ok := numericValue(value); ok {
+			group.Values[i].observe(number)
+		}
+	}
+}
+
+// Добавляет группы другого окна; группы с одинаковым ключом объединяются
+func mergeGroups(into, other map[string]*Group) {
+	for id, group := range other {
+		existing, ok := into[id]
+		if !ok {
+			clone := &Group{Key: group.Key, Count: group.Count}
+			for _, state := range group.Values {
+				clone.Values = append(clone.Values, state.clone())
+			}
+			into[id] = clone
+			continue
+		}
+		existing.Count += group.Count
+		if len(existing.Values) != len(group.Values) {
+			// Состояние со старыми AGGREGATES: Stats свернет группу в OtherGroup
+			existing.Values = nil
+			continue
+		}
+		for i, state := range group.Values {
+			existing.Values[i].merge(state)
+		}
+	}
+}
+
+// Stats строит группы для error-stats по убыванию count. Группы, записанные
+// с другими GROUP_BY или AGGREGATES (состояние до перезапуска), попадают в
+// events.OtherGroup без агрегатов, чтобы сумма по группам сходилась.
+func (g *GroupBy) Stats(groups map[string]*Group) ([]events.GroupStats, bool) {
+	other := events.GroupStats{Key: make(map[string]string)}
+	for _, dimension := range g.Dimensions {
+		other.Key[dimension] = events.OtherGroup
+	}
+
+	var result []events.GroupStats
+	limited := false
+	for _, group := range groups {
+		stats := events.GroupStats{Key: make(map[string]string), Count: group.Count}
+		if !g.matches(group) {
+			other.Count += group.Count
+			limited = true
+			continue
+		}
+		for i, dimension := range g.Dimensions {
+			stats.Key[dimension] = group.Key[i]
+			if group.Key[i] == events.OtherGroup {
+				limited = true
+			}
+		}
+		for i, aggregate := range g.Aggregates {
+			state := group.Values[i]
+			switch aggregate.Function {
+			case events.AggregateCount:
+				// Это поле Count группы
+			case events.AggregateDistinct:
+				setValue(&stats, aggregate.Name, state.Distinct.Estimate())
+			case events.AggregateSum:
+				setValue(&stats, aggregate.Name, state.Value)
+			default:
+				if state.Set {
+					setValue(&stats, aggregate.Name, state.Value)
+				}
+			}
+		}
+		result = append(result, stats)
+	}
+
+	if other.Count > 0 {
+		// Группа могла уже быть среди результатов — добавляем к ней
+		merged := false
+		for i := range result {
+			if sameKey(result[i].Key, other.Key) {
+				result[i].Count += other.Count
+				merged = true
+			}
+		}
+		if !merged {
+			result = append(result, other)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return groupKey(g.Dimensions, result[i]) < groupKey(g.Dimensions, result[j])
+	})
+	return result, limited
+}
+
+// Группа записана с текущими GROUP_BY и AGGREGATES, а не восстановлена из
+// состояния, сохраненного с другими настройками
+func (g *GroupBy) matches(group *Group) bool {
+	if len(group.Key) != len(g.Dimensions) || len(group.Values) != len(g.Aggregates) {
+		return false
+	}
+	for i, aggregate := range g.Aggregates {
+		state := group.Values[i]
+		if state.Function != aggregate.Function || (state.Function == events.AggregateDistinct && state.Distinct == nil) {
+			return false
+		}
+	}
+	return true
+}
+
+func setValue(stats *events.GroupStats, name string, value float64) {
+	if stats.Values == nil {
+		stats.Values = make(map[string]float64)
+	}
+	stats.Values[name] = value
+}
+
+func sameKey(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for dimension, value := range a {
+		if b[dimension] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func groupKey(dimensions []string, stats events.GroupStats) string {
+	values := make([]string, len(dimensions))
+	for i, dimension := range dimensions {
+		values[i] = stats.Key[dimension]
+	}
+	return strings.Join(values, keySeparator)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"events"
+	"testing"
+)
+
+func TestParseGroupByNumericFieldsMustBeAttributes(t *testing.T) {
+	for _, aggregates := range []string{"min(service)", "max(error)", "sum(fingerprint)", "sum(template)"} {
+		if _, err := ParseGroupBy("service", aggregates, 100, 10); err == nil {
+			t.Errorf("AGGREGATES=%q принят", aggregates)
+		}
+	}
+
+	groupBy, err := ParseGroupBy("service", "count,distinct(error),min(attributes.latency_ms),max(attributes.latency_ms),sum(attributes.bytes)", 100, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groupBy.Aggregates) != 5 || groupBy.Aggregates[2].Field != "attributes.latency_ms" {
+		t.Errorf("агрегаты разобраны неверно: %+v", groupBy.Aggregates)
+	}
+}
+
+func TestParseGroupByRejectsInvalidSettings(t *testing.T) {
+	cases := []struct {
+		dimensions, aggregates string
+		maxGroups, precision   int
+	}{
+		{"service", "count", 0, 10},                   // MAX_GROUPS не положительный
+		{"service", "count", 100, 3},                  // точность HLL меньше 4
+		{"service", "count", 100, 17},                 // и больше 16
+		{"host", "count", 100, 10},                    // неизвестное измерение
+		{"service,service", "count", 100, 10},         // измерение дважды
+		{"service", "count,count", 100, 10},           // агрегат дважды
+		{"service", "avg(attributes.x)", 100, 10},     // неизвестная функция
+		{"service", "max(attributes.x", 100, 10},      // нет скобки
+		{"service", "distinct(attributes.)", 100, 10}, // пустое имя атрибута
+	}
+	for _, c := range cases {
+		if _, err := ParseGroupBy(c.dimensions, c.aggregates, c.maxGroups, c.precision); err == nil {
+			t.Errorf("GROUP_BY=%q AGGREGATES=%q MAX_GROUPS=%d HLL_PRECISION=%d приняты",
+				c.dimensions, c.aggregates, c.maxGroups, c.precision)
+		}
+	}
+}
+
+func TestGroupByAggregates(t *testing.T) {
+	groupBy, err := ParseGroupBy("service", "count,distinct(error),min(attributes.latency_ms),max(attributes.latency_ms),sum(attributes.latency_ms)", 100, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := make(map[string]*Group)
+	for _, errorLog := range []*events.ErrorLog{
+		{Service: "api", Error: "timeout", Attributes: map[string]any{"latency_ms": 120.0}},
+		{Service: "api", Error: "timeout", Attributes: map[string]any{"latency_ms": "30"}},
+		{Service: "api", Error: "refused", Attributes: map[string]any{"latency_ms": "n/a"}},
+		{Service: "db", Error: "deadlock"},
+	} {
+		groupBy.Add(groups, errorLog)
+	}
+
+	stats, limited := groupBy.Stats(groups)
+	if limited || len(stats) != 2 {
+		t.Fatalf("ожидали две группы без свертки: %+v (limited=%v)", stats, limited)
+	}
+
+	api := stats[0]
+	if api.Key["service"] != "api" || api.Count != 3 {
+		t.Fatalf("первая группа %+v, ожидали api с count=3", api)
+	}
+	want := map[string]float64{
+		"distinct(error)":            2,
+		"min(attributes.latency_ms)": 30,
+		"max(attributes.latency_ms)": 120,
+		"sum(attributes.latency_ms)": 150,
+	}
+	for name, value := range want {
+		if api.Values[name] != value {
+			t.Errorf("%s = %v, ожидали %v", name, api.Values[name], value)
+		}
+	}
+
+	// В группе db чисел не было: min и max не выводятся, sum равна нулю
+	db := stats[1]
+	if _, ok := db.Values["min(attributes.latency_ms)"]; ok {
+		t.Errorf("min без чисел: %+v", db.Values)
+	}
+	if value, ok := db.Values["sum(attributes.latency_ms)"]; !ok || value != 0 {
+		t.Errorf("sum без чисел: %+v", db.Values)
+	}
+}
+
+func TestGroupByFoldsExtraGroups(t *testing.T) {
+	groupBy, err := ParseGroupBy("service", "count", 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := make(map[string]*Group)
+	for _, service := range []string{"a", "a", "b", "c", "d", "d"} {
+		groupBy.Add(groups, &events.ErrorLog{Service: service})
+	}
+
+	stats, limited := groupBy.Stats(groups)
+	if !limited {
+		t.Error("свертка групп не отмечена")
+	}
+	total := 0
+	other := 0
+	for _, group := range stats {
+		total += group.Count
+		if group.Key["service"] == events.OtherGroup {
+			other = group.Count
+		}
+	}
+	if total != 6 || other != 3 {
+		t.Errorf("всего %d, в %s %d: %+v", total, events.OtherGroup, other, stats)
+	}
+}
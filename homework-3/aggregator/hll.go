@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog: оценка числа различных значений в фиксированной памяти.
+// 2^Precision регистров по байту, относительная ошибка ~1.04/√(2^Precision):
+// 3.3% при Precision=10. Скетчи одной точности объединяются поэлементным max.
+type HyperLogLog struct {
+	Precision uint8  `json:"precision"`
+	Registers []byte `json:"registers"`
+}
+
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	return &HyperLogLog{Precision: precision, Registers: make([]byte, 1<<precision)}
+}
+
+// Add учитывает значение
+func (h *HyperLogLog) Add(value string) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	x := mix64(hasher.Sum64())
+
+	index := x >> (64 - h.Precision)
+	// Единица в конце ограничивает ранг, если все оставшиеся биты нулевые
+	rank := byte(bits.LeadingZeros64(x<<h.Precision|1<<(h.Precision-1)) + 1)
+	if rank > h.Registers[index] {
+		h.Registers[index] = rank
+	}
+}
+
+// Merge добавляет значения другого скетча той же точности
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if other.Precision != h.Precision || len(other.Registers) != len(h.Registers) {
+		return fmt.Errorf("скетчи разной точности: %d и %d", h.Precision, other.Precision)
+	}
+	for i, rank := range other.Registers {
+		if rank > h.Registers[i] {
+			h.Registers[i] = rank
+		}
+	}
+	return nil
+}
+
+// Estimate возвращает оценку числа различных значений
+func (h *HyperLogLog) Estimate() float64 {
+	m := float64(len(h.Registers))
+	sum, zeros := 0.0, 0
+	for _, rank := range h.Registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.Registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	estimate := alpha * m * m / sum
+	// На малых количествах точнее линейный подсчет по пустым регистрам
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return math.Round(estimate)
+}
+
+func (h *HyperLogLog) Clone() *HyperLogLog {
+	return &HyperLogLog{Precision: h.Precision, Registers: append([]byte(nil), h.Registers...)}
+}
+
+// Перемешивание битов (финализатор splitmix64): у FNV плохо распределены
+// старшие биты коротких строк, а HyperLogLog берет из них номер регистра
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
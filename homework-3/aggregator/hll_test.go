@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLogErrorBounds(t *testing.T) {
+	for _, precision := range []uint8{4, 10, 16} {
+		// Допускаем три стандартные ошибки 1.04/√m
+		bound := 3 * 1.04 / math.Sqrt(float64(int(1)<<precision))
+		for _, n := range []int{10, 100, 1000, 10000, 100000} {
+			hll := NewHyperLogLog(precision)
+			for i := 0; i < n; i++ {
+				hll.Add(fmt.Sprintf("user-%d", i))
+			}
+			estimate := hll.Estimate()
+			if relative := math.Abs(estimate-float64(n)) / float64(n); relative > bound {
+				t.Errorf("precision=%d n=%d: оценка %.0f, ошибка %.1f%% больше %.1f%%",
+					precision, n, estimate, 100*relative, 100*bound)
+			}
+		}
+	}
+}
+
+func TestHyperLogLogIgnoresDuplicates(t *testing.T) {
+	hll := NewHyperLogLog(10)
+	for i := 0; i < 10000; i++ {
+		hll.Add(fmt.Sprintf("user-%d", i%50))
+	}
+	if estimate := hll.Estimate(); estimate < 48 || estimate > 52 {
+		t.Errorf("50 различных значений оценены как %.0f", estimate)
+	}
+	if estimate := NewHyperLogLog(10).Estimate(); estimate != 0 {
+		t.Errorf("пустой скетч оценен как %.0f", estimate)
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a, b, union := NewHyperLogLog(12), NewHyperLogLog(12), NewHyperLogLog(12)
+	for i := 0; i < 6000; i++ {
+		value := fmt.Sprintf("user-%d", i)
+		// Половина значений общая
+		if i < 4000 {
+			a.Add(value)
+		}
+		if i >= 2000 {
+			b.Add(value)
+		}
+		union.Add(value)
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	// Объединение совпадает со скетчем, построенным по всем значениям сразу
+	if a.Estimate() != union.Estimate() {
+		t.Errorf("после слияния %.0f, по всем значениям %.0f", a.Estimate(), union.Estimate())
+	}
+	if err := a.Merge(NewHyperLogLog(10)); err == nil {
+		t.Error("скетчи разной точности слиты без ошибки")
+	}
+}
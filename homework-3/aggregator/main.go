@@ -2,56 +2,126 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"dlq"
+	"events"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
-// Структура ERROR лога (из mapper)
-type ErrorLog struct {
-	Timestamp   string `json:"timestamp"`
-	Service     string `json:"service"`
-	Error       string `json:"error"`
-	ProcessedAt string `json:"processed_at"`
-}
-
-// Структура статистики ошибок
-type ErrorStats struct {
-	WindowStart string            `json:"window_start"`
-	WindowEnd   string            `json:"window_end"`
-	Services    map[string]int    `json:"services"`
-	TotalErrors int               `json:"total_errors"`
-	GeneratedAt string            `json:"generated_at"`
-}
-
 func main() {
 	// Получаем настройки
 	servers := getEnvOrDefault("KAFKA_BOOTSTRAP_SERVERS", "localhost:19092")
-	inputTopic := getEnvOrDefault("INPUT_TOPIC", "error-logs")
-	outputTopic := getEnvOrDefault("OUTPUT_TOPIC", "error-stats")
-	consumerGroup := getEnvOrDefault("CONSUMER_GROUP", "error-aggregator")
-	windowSeconds := 60 // 1-минутные окна
+	mode := getEnvOrDefault("MODE", "errors") // errors - статистика ошибок, metrics - сводка метрик сервисов
+
+	// Топики и группа по умолчанию зависят от режима
+	defaultInput, defaultOutput, defaultGroup := "error-logs", "error-stats", "error-aggregator"
+	switch mode {
+	case "errors":
+	case "metrics":
+		defaultInput, defaultOutput, defaultGroup = "service-metrics", "service-metrics-rollup", "metrics-aggregator"
+	default:
+		log.Fatalf("❌ Неизвестный режим %q (errors или metrics)", mode)
+	}
+
+	inputTopic := getEnvOrDefault("INPUT_TOPIC", defaultInput)
+	outputTopic := getEnvOrDefault("OUTPUT_TOPIC", defaultOutput)
+	lateTopic := getEnvOrDefault("LATE_TOPIC", inputTopic+"-late")
+	consumerGroup := getEnvOrDefault("CONSUMER_GROUP", defaultGroup)
+	changelogTopic := getEnvOrDefault("CHANGELOG_TOPIC", consumerGroup+"-changelog")
+	// {hostname} в пути — свой файл у каждого экземпляра на общем томе
+	hostname, _ := os.Hostname()
+	statePath := strings.ReplaceAll(getEnvOrDefault("STATE_PATH", "aggregator-state.db"), "{hostname}", hostname)
+	timeSource := getEnvOrDefault("TIME_SOURCE", "event") // event - timestamp записи, kafka - время записи в Kafka
+	windowType := getEnvOrDefault("WINDOW_TYPE", events.WindowTumbling)
+	windowSeconds := getEnvIntOrDefault("WINDOW_SECONDS", 60)
+	advanceSeconds := getEnvIntOrDefault("WINDOW_ADVANCE_SECONDS", windowSeconds) // шаг hopping окон
+	sessionGapSeconds := getEnvIntOrDefault("SESSION_GAP_SECONDS", 30)
+	latenessSeconds := getEnvIntOrDefault("ALLOWED_LATENESS_SECONDS", 10)
+	idleSeconds := getEnvIntOrDefault("IDLE_TIMEOUT_SECONDS", 30)
+	flushMs := getEnvIntOrDefault("FLUSH_INTERVAL_MS", 1000)
+	statsConfig := StatsConfig{
+		TopKinds: getEnvIntOrDefault("TOP_KINDS", 5),
+		TopMessages: TopKConfig{
+			K:     getEnvIntOrDefault("TOP_MESSAGES", 5),
+			Width: getEnvIntOrDefault("TOP_MESSAGES_SKETCH_WIDTH", 512),
+		},
+	}
+	if statsConfig.TopMessages.K > 0 && statsConfig.TopMessages.Width <= 0 {
+		log.Fatalf("❌ TOP_MESSAGES_SKETCH_WIDTH должен быть положительным")
+	}
+	groupBy, err := ParseGroupBy(
+		getEnvOrDefault("GROUP_BY", "service"),
+		getEnvOrDefault("AGGREGATES", "count"),
+		getEnvIntOrDefault("MAX_GROUPS", 1000),
+		getEnvIntOrDefault("HLL_PRECISION", 10),
+	)
+	if err != nil {
+		log.Fatalf("❌ Ошибка настройки группировки: %v", err)
+	}
+	statsConfig.GroupBy = groupBy
+	httpAddr := getEnvOrDefault("HTTP_ADDR", ":8080")
+	advertisedAddr := getEnvOrDefault("ADVERTISED_ADDR", defaultAdvertisedAddr(httpAddr)) // адрес для других экземпляров
+	historyWindows := getEnvIntOrDefault("HISTORY_WINDOWS", 60)
+
+	spec := WindowSpec{
+		Type:    windowType,
+		Size:    time.Duration(windowSeconds) * time.Second,
+		Advance: time.Duration(advanceSeconds) * time.Second,
+		Gap:     time.Duration(sessionGapSeconds) * time.Second,
+	}
+	if err := spec.Validate(); err != nil {
+		log.Fatalf("❌ Ошибка настройки окон: %v", err)
+	}
+	if mode == "metrics" && spec.Type == events.WindowSession {
+		// Метрики приходят непрерывно, пауз между сессиями не бывает
+		log.Fatalf("❌ Режим metrics поддерживает только tumbling и hopping окна")
+	}
 
-	log.Printf("📊 Aggregator запущен")
+	log.Printf("📊 Aggregator запущен (режим: %s)", mode)
 	log.Printf("📥 Читаем из: %s", inputTopic)
 	log.Printf("📤 Записываем в: %s", outputTopic)
-	log.Printf("⏰ Окно агрегации: %d секунд", windowSeconds)
+	log.Printf("🐢 Опоздавшие записи в: %s", lateTopic)
+	log.Printf("⏰ Окна агрегации: %s (время: %s, опоздание: %d сек)",
+		spec, timeSource, latenessSeconds)
+	log.Printf("💾 Состояние: %s, changelog: %s", statePath, changelogTopic)
+	log.Printf("🌐 HTTP API: %s (для других экземпляров: %s)", httpAddr, advertisedAddr)
 
 	brokers := strings.Split(servers, ",")
 
-	// Создаем reader для чтения ERROR логов
-	reader := kafka.NewReader(kafka.ReaderConfig{
+	// Changelog должен быть ко-партиционирован с входным топиком
+	if err := EnsureChangelogTopic(brokers, inputTopic, changelogTopic); err != nil {
+		log.Fatalf("❌ Ошибка подготовки changelog: %v", err)
+	}
+
+	store, err := OpenStateStore(statePath, brokers, changelogTopic)
+	if err != nil {
+		log.Fatalf("❌ Ошибка открытия состояния: %v", err)
+	}
+	defer store.Close()
+
+	// Читаем ERROR логи в consumer group; о ребалансах узнаем по поколениям
+	consumer, err := NewGroupConsumer(kafka.ReaderConfig{
 		Brokers: brokers,
 		Topic:   inputTopic,
 		GroupID: consumerGroup,
+		// По client.id другие экземпляры узнают адрес HTTP API владельца партиции
+		Dialer:   &kafka.Dialer{ClientID: ClientID(consumerGroup, advertisedAddr), Timeout: 10 * time.Second, DualStack: true},
 		MinBytes: 10e3, // 10KB
 		MaxBytes: 10e6, // 10MB
+		// mapper пишет в error-logs транзакциями — читаем только подтвержденные
+		IsolationLevel: kafka.ReadCommitted,
 	})
-	defer reader.Close()
+	if err != nil {
+		log.Fatalf("❌ Ошибка подключения к consumer group: %v", err)
+	}
+	defer consumer.Close()
 
 	// Создаем writer для записи статистики
 	writer := kafka.NewWriter(kafka.WriterConfig{
@@ -61,91 +131,401 @@ func main() {
 	})
 	defer writer.Close()
 
-	log.Printf("✅ Подключение к Kafka установлено")
+	// Создаем writer для опоздавших записей
+	lateWriter := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  brokers,
+		Topic:    lateTopic,
+		Balancer: &kafka.Hash{},
+	})
+	defer lateWriter.Close()
 
-	// Счетчики для агрегации
-	errorCounts := make(map[string]int)
-	windowStart := time.Now()
+	// Нечитаемые записи уходят в error-logs.dlq
+	deadLetters := dlq.NewWriter(brokers, "aggregator")
+	defer deadLetters.Close()
 
-	// Ticker для отправки статистики каждую минуту
-	ticker := time.NewTicker(time.Duration(windowSeconds) * time.Second)
-	defer ticker.Stop()
+	log.Printf("✅ Подключение к Kafka установлено")
 
-	// Канал для чтения сообщений
-	messageChan := make(chan kafka.Message, 100)
+	// Окна по времени события
+	windows := NewWindows(
+		spec,
+		time.Duration(latenessSeconds)*time.Second,
+		time.Duration(idleSeconds)*time.Second,
+	)
 
-	// Горутина для чтения сообщений
+	// HTTP API над открытыми окнами и историей закрытых
+	history := NewHistory(historyWindows)
+	queryServer := NewQueryServer(windows, history, NewRouter(brokers, consumerGroup, inputTopic, advertisedAddr), statsConfig)
 	go func() {
-		for {
-			message, err := reader.ReadMessage(context.Background())
-			if err != nil {
-				log.Printf("❌ Ошибка чтения: %v", err)
-				continue
-			}
-			messageChan <- message
+		if err := http.ListenAndServe(httpAddr, queryServer.Handler()); err != nil {
+			log.Fatalf("❌ Ошибка HTTP API: %v", err)
 		}
 	}()
 
+	// Состояние партиций загружается, когда партиция назначена экземпляру
+	states := make(map[int]*PartitionState)
+	// Последняя обработанная, но еще не закоммиченная запись по партициям
+	pending := make(map[int]kafka.Message)
+	// Закрытые окна, которые еще нужно удалить из состояния
+	closedWindows := make(map[int][]*Window)
+
+	// Ticker для закрытия окон, когда поток записей затих
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// Ticker для сохранения состояния и коммита offset'ов
+	flushTicker := time.NewTicker(time.Duration(flushMs) * time.Millisecond)
+	defer flushTicker.Stop()
+
+	// Текущее поколение группы; nil — идет ребаланс
+	var generation *kafka.Generation
+
+	// Записи, новые поколения и конец поколения приходят в основной цикл
+	go consumer.Run(context.Background())
+
 	// Основной цикл агрегации
 	for {
 		select {
-		case message := <-messageChan:
-			// Парсим ERROR лог
-			var errorLog ErrorLog
-			err := json.Unmarshal(message.Value, &errorLog)
-			if err != nil {
-				log.Printf("❌ Ошибка JSON: %v", err)
+		case next := <-consumer.Generations():
+			// Партиции, которые достались другим экземплярам, забываем без
+			// отправки окон: их восстановит из changelog и отправит новый владелец
+			assigned := make(map[int]bool)
+			var ids []int
+			for _, assignment := range next.Assignments[inputTopic] {
+				assigned[assignment.ID] = true
+				ids = append(ids, assignment.ID)
+			}
+			for partition := range states {
+				if !assigned[partition] {
+					log.Printf("🔀 Партиция %d отозвана, ее окна не отправляем", partition)
+					windows.Drop(partition)
+					delete(states, partition)
+					delete(pending, partition)
+					delete(closedWindows, partition)
+				}
+			}
+			// Состояние новых партиций загружаем сразу: их время событий
+			// сдерживает watermark еще до первой записи
+			for _, partition := range ids {
+				if _, ok := states[partition]; !ok {
+					states[partition] = loadPartition(store, windows, partition)
+				}
+			}
+			generation = next
+			log.Printf("👥 Поколение группы %d, партиции: %v", generation.ID, ids)
+
+		case done := <-consumer.Revoked():
+			// Поколение закончилось: сохраняем состояние и коммитим, пока
+			// партиции еще наши, и до нового поколения окна не отправляем
+			flushState(store, generation, windows, states, pending, closedWindows)
+			generation = nil
+			close(done)
+
+		case groupMessage := <-consumer.Messages():
+			// Записи, прочитанные в закончившемся поколении, перечитает владелец
+			if generation == nil || groupMessage.Generation != generation.ID {
 				continue
 			}
+			message := groupMessage.Message
+
+			// Разрыв в offset'ах значит, что партицию между делом обрабатывал
+			// другой экземпляр (ребаланс) — перечитываем ее состояние
+			state, ok := states[message.Partition]
+			if ok && message.Offset > state.NextOffset {
+				log.Printf("🔀 Партиция %d: разрыв offset'ов %d → %d, перечитываем состояние",
+					message.Partition, state.NextOffset, message.Offset)
+				ok = false
+			}
+			if !ok {
+				state = loadPartition(store, windows, message.Partition)
+				states[message.Partition] = state
+			}
 
-			// Увеличиваем счетчик для сервиса
-			errorCounts[errorLog.Service]++
-			log.Printf("📈 %s: %d ошибок", errorLog.Service, errorCounts[errorLog.Service])
+			// Запись уже учтена в сохраненном состоянии (offset не успели закоммитить)
+			if message.Offset < state.NextOffset {
+				pending[message.Partition] = message
+				continue
+			}
+			// Offset сдвигается только после того, как запись учтена в окне
+			// или дошла до топика опоздавших
+			processMessage(windows, lateWriter, deadLetters, message, mode, timeSource, statsConfig)
+			state.NextOffset = message.Offset + 1
+			pending[message.Partition] = message
+			state.MaxEventTime = windows.PartitionTime(message.Partition)
+			// Поглощенные session окна удаляются из состояния вместе с закрытыми
+			for _, window := range windows.Removed() {
+				closedWindows[window.Partition] = append(closedWindows[window.Partition], window)
+			}
 
 		case <-ticker.C:
-			// Время отправить статистику
-			if len(errorCounts) > 0 {
-				windowEnd := time.Now()
-				totalErrors := 0
-				for _, count := range errorCounts {
-					totalErrors += count
+			// Во время ребаланса часть окон может уже принадлежать другим
+			if generation == nil {
+				continue
+			}
+			// Отправляем статистику по окнам, которые прошел watermark. Закрытое
+			// окно удаляется из состояния только после успешной записи: до этого
+			// offset'ы не коммитятся, и после сбоя окно восстановится и уйдет снова
+			closed := windows.CloseExpired()
+			for _, window := range MergeWindows(closed) {
+				if mode == "metrics" {
+					retry("записи сводки метрик", func() error { return sendRollups(writer, window, windows.Spec()) })
+				} else {
+					retry("записи статистики", func() error { return sendStats(writer, window, windows.Spec(), statsConfig) })
 				}
+				history.Add(newWindowView(window, windows.Spec(), statsConfig))
+			}
+			for _, window := range closed {
+				closedWindows[window.Partition] = append(closedWindows[window.Partition], window)
+			}
 
-				stats := ErrorStats{
-					WindowStart: windowStart.Format("2006-01-02 15:04:05"),
-					WindowEnd:   windowEnd.Format("2006-01-02 15:04:05"),
-					Services:    copyMap(errorCounts),
-					TotalErrors: totalErrors,
-					GeneratedAt: time.Now().Format("2006-01-02 15:04:05"),
-				}
+		case <-flushTicker.C:
+			if generation != nil {
+				flushState(store, generation, windows, states, pending, closedWindows)
+			}
 
-				// Сериализуем статистику
-				statsBytes, err := json.Marshal(stats)
-				if err != nil {
-					log.Printf("❌ Ошибка сериализации: %v", err)
-					continue
-				}
+		case query := <-queryServer.Queries():
+			query()
+		}
+	}
+}
 
-				// Записываем статистику
-				err = writer.WriteMessages(context.Background(), kafka.Message{
-					Key:   []byte("error-stats"),
-					Value: statsBytes,
-				})
+func processMessage(windows *Windows, lateWriter *kafka.Writer, deadLetters *dlq.Writer,
+	message kafka.Message, mode, timeSource string, config StatsConfig) {
+	var (
+		service, timestamp, what string
+		update                   func(*Window)
+		err                      error
+	)
 
-				if err != nil {
-					log.Printf("❌ Ошибка записи: %v", err)
-				} else {
-					log.Printf("📊 Отправлена статистика: %d ошибок за окно", totalErrors)
-					for service, count := range errorCounts {
-						log.Printf("   %s: %d ошибок", service, count)
-					}
-				}
+	// Парсим ERROR лог или образец метрик
+	if mode == "metrics" {
+		var metrics events.ServiceMetrics
+		err = events.Decode(message.Value, &metrics)
+		service, timestamp, what = metrics.Service, metrics.Timestamp, "метрики"
+		update = func(window *Window) { window.AddMetrics(&metrics) }
+	} else {
+		var errorLog events.ErrorLog
+		err = events.Decode(message.Value, &errorLog)
+		service, timestamp, what = errorLog.Service, errorLog.Timestamp, "ошибка"
+		update = func(window *Window) { window.AddError(&errorLog, config.TopMessages, config.GroupBy) }
+	}
+	if err != nil {
+		log.Printf("❌ Ошибка JSON: %v", err)
+		// Offset этой записи закоммитится при сохранении состояния
+		if err := deadLetters.Send(context.Background(), message, err); err != nil {
+			log.Fatalf("❌ Запись не отправлена в DLQ: %v", err)
+		}
+		return
+	}
 
-				// Сбрасываем счетчики для нового окна
-				errorCounts = make(map[string]int)
-				windowStart = windowEnd
-			}
+	eventTime := getEventTime(timestamp, message, timeSource)
+
+	// Записи в уже закрытые окна уходят в отдельный топик
+	if !windows.Add(message.Partition, eventTime, service, update) {
+		watermark := windows.Watermark()
+		retry("записи опоздавшей записи", func() error { return sendLate(lateWriter, message, eventTime, watermark) })
+		return
+	}
+	log.Printf("📈 %s: %s в %s (открытых окон: %d)",
+		service, what, events.FormatTime(eventTime), windows.Open())
+}
+
+// Загружает состояние партиции и восстанавливает ее окна
+func loadPartition(store *StateStore, windows *Windows, partition int) *PartitionState {
+	state, restored, err := store.Load(partition)
+	if err != nil {
+		log.Fatalf("❌ Ошибка загрузки состояния партиции %d: %v", partition, err)
+	}
+	windows.Restore(partition, state.MaxEventTime, restored)
+	return state
+}
+
+// Сохраняет состояние измененных партиций и только затем коммитит их offset'ы
+func flushState(store *StateStore, generation *kafka.Generation, windows *Windows,
+	states map[int]*PartitionState, pending map[int]kafka.Message, closedWindows map[int][]*Window) {
+
+	partitions := make(map[int]bool)
+	for partition := range pending {
+		partitions[partition] = true
+	}
+	for partition := range closedWindows {
+		partitions[partition] = true
+	}
+
+	for partition := range partitions {
+		state := states[partition]
+		if err := store.Save(state, windows.ChangedWindows(partition), closedWindows[partition]); err != nil {
+			log.Printf("❌ Ошибка сохранения состояния партиции %d: %v", partition, err)
+			continue
+		}
+		windows.Saved(partition)
+		delete(closedWindows, partition)
+
+		message, ok := pending[partition]
+		if !ok {
+			continue
+		}
+		offsets := map[string]map[int]int64{message.Topic: {partition: message.Offset + 1}}
+		if err := generation.CommitOffsets(offsets); err != nil {
+			log.Printf("❌ Ошибка коммита offset партиции %d: %v", partition, err)
+			continue
+		}
+		delete(pending, partition)
+	}
+}
+
+// Время события: из timestamp записи или время записи в Kafka
+func getEventTime(timestamp string, message kafka.Message, timeSource string) time.Time {
+	if timeSource == "kafka" {
+		return message.Time
+	}
+
+	eventTime, err := events.ParseTime(timestamp)
+	if err != nil {
+		return message.Time
+	}
+	return eventTime
+}
+
+// Что кроме счетчиков по сервисам считается в окне ошибок
+type StatsConfig struct {
+	TopKinds    int        // видов ошибок в top_kinds
+	TopMessages TopKConfig // частые сообщения
+	GroupBy     *GroupBy   // группы
+}
+
+func sendStats(writer *kafka.Writer, window *Window, spec WindowSpec, config StatsConfig) error {
+	totalErrors := window.Total()
+
+	stats := events.ErrorStats{
+		WindowStart: events.FormatTime(window.Start),
+		WindowEnd:   events.FormatTime(window.End),
+		WindowType:  spec.Type,
+		Services:    copyMap(window.Counts),
+		TotalErrors: totalErrors,
+		TopKinds:    window.TopKinds(config.TopKinds),
+		Dimensions:  config.GroupBy.Dimensions,
+		Aggregates:  config.GroupBy.Aggregates,
+		GeneratedAt: events.FormatTime(time.Now()),
+	}
+	stats.Groups, stats.GroupsLimited = config.GroupBy.Stats(window.Groups)
+	if window.Messages != nil {
+		stats.TopMessages = window.Messages.Top()
+		stats.TopMessagesByService = make(map[string][]events.MessageCount)
+		for service, messages := range window.ServiceMessages {
+			stats.TopMessagesByService[service] = messages.Top()
+		}
+	}
+	switch spec.Type {
+	case events.WindowSession:
+		// Сессия заканчивается последней ошибкой, а не паузой после нее
+		stats.WindowEnd = events.FormatTime(window.End.Add(-spec.Gap))
+		stats.SessionGap = spec.Gap.String()
+	case events.WindowHopping:
+		stats.WindowSize = spec.Size.String()
+		stats.WindowAdvance = spec.Advance.String()
+	default:
+		stats.WindowSize = spec.Size.String()
+	}
+
+	// Сериализуем статистику
+	statsBytes, err := events.Encode(&stats)
+	if err != nil {
+		return fmt.Errorf("сериализация: %w", err)
+	}
+
+	// Записываем статистику
+	err = writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte("error-stats"),
+		Value: statsBytes,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("📊 Отправлена статистика: %d ошибок за %s окно %s → %s",
+		totalErrors, stats.WindowType, stats.WindowStart, stats.WindowEnd)
+	for service, count := range window.Counts {
+		log.Printf("   %s: %d ошибок", service, count)
+	}
+	for _, kind := range stats.TopKinds {
+		log.Printf("   🧬 %s: %d × %s", kind.Fingerprint, kind.Count, kind.Template)
+	}
+	for _, message := range stats.TopMessages {
+		log.Printf("   💬 ~%d × %s", message.Count, message.Message)
+	}
+	return nil
+}
+
+// Отправляет сводку метрик окна: по записи на сервис с ключом-сервисом
+func sendRollups(writer *kafka.Writer, window *Window, spec WindowSpec) error {
+	var messages []kafka.Message
+	var rollups []*events.ServiceMetricsRollup
+	for service, rollup := range window.Rollups {
+		event := rollup.Event(service)
+		event.WindowStart = events.FormatTime(window.Start)
+		event.WindowEnd = events.FormatTime(window.End)
+		event.WindowType = spec.Type
+		event.WindowSize = spec.Size.String()
+		if spec.Type == events.WindowHopping {
+			event.WindowAdvance = spec.Advance.String()
+		}
+		event.GeneratedAt = events.FormatTime(time.Now())
+
+		value, err := events.Encode(event)
+		if err != nil {
+			log.Printf("❌ Ошибка сериализации сводки %s: %v", service, err)
+			continue
 		}
+		messages = append(messages, kafka.Message{Key: []byte(service), Value: value})
+		rollups = append(rollups, event)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	if err := writer.WriteMessages(context.Background(), messages...); err != nil {
+		return err
+	}
+	log.Printf("📊 Отправлена сводка метрик за окно %s → %s",
+		events.FormatTime(window.Start), events.FormatTime(window.End))
+	for _, event := range rollups {
+		log.Printf("   %s: p50=%.0fms p90=%.0fms p99=%.0fms CPU=%.1f%% MEM=%.1f%% REQ=%d (%d образцов)",
+			event.Service, event.LatencyP50Ms, event.LatencyP90Ms, event.LatencyP99Ms,
+			event.CPUMean, event.MemoryMean, event.RequestsTotal, event.Samples)
+	}
+	return nil
+}
+
+func sendLate(writer *kafka.Writer, message kafka.Message, eventTime, watermark time.Time) error {
+	err := writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   message.Key,
+		Value: message.Value,
+		Headers: []kafka.Header{
+			{Key: "event-time", Value: []byte(events.FormatTime(eventTime))},
+			{Key: "watermark", Value: []byte(events.FormatTime(watermark))},
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+	log.Printf("🐢 Опоздавшая запись %s (watermark %s)",
+		events.FormatTime(eventTime), events.FormatTime(watermark))
+	return nil
+}
+
+// Повторяет запись в Kafka до успеха: пока окно не отправлено, его нельзя
+// удалять из состояния, а пока опоздавшая запись не записана — коммитить ее offset
+func retry(what string, operation func() error) {
+	backoff := time.Second
+	for {
+		err := operation()
+		if err == nil {
+			return
+		}
+		log.Printf("❌ Ошибка %s: %v, повтор через %s", what, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 30*time.Second)
 	}
 }
 
@@ -163,4 +543,12 @@ func getEnvOrDefault(key, defaultValue string) string {
 		return defaultValue
 	}
 	return value
-} 
\ No newline at end of file
+}
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
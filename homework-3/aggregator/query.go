@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"events"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Заголовок запроса, пересланного другим экземпляром: такой запрос
+// обслуживается локально, даже если ключ уже успел переехать
+const forwardedHeader = "X-Aggregator-Forwarded"
+
+// Окно в ответах HTTP API
+type WindowView struct {
+	WindowStart          string                                  `json:"window_start"`
+	WindowEnd            string                                  `json:"window_end"`
+	Service              string                                  `json:"service,omitempty"` // сервис session окна
+	Services             map[string]int                          `json:"services"`
+	Total                int                                     `json:"total"`
+	TopKinds             []events.ErrorKind                      `json:"top_kinds,omitempty"`
+	TopMessages          []events.MessageCount                   `json:"top_messages,omitempty"`
+	TopMessagesByService map[string][]events.MessageCount        `json:"top_messages_by_service,omitempty"`
+	Groups               []events.GroupStats                     `json:"groups,omitempty"`
+	Metrics              map[string]*events.ServiceMetricsRollup `json:"metrics,omitempty"` // режим metrics
+}
+
+func newWindowView(window *Window, spec WindowSpec, config StatsConfig) WindowView {
+	view := WindowView{
+		WindowStart: events.FormatTime(window.Start),
+		WindowEnd:   events.FormatTime(window.End),
+		Service:     window.Service,
+		Services:    copyMap(window.Counts),
+		Total:       window.Total(),
+		TopKinds:    window.TopKinds(config.TopKinds),
+	}
+	if window.Groups != nil {
+		view.Groups, _ = config.GroupBy.Stats(window.Groups)
+	}
+	if spec.Type == events.WindowSession {
+		view.WindowEnd = events.FormatTime(window.End.Add(-spec.Gap))
+	}
+	if window.Messages != nil {
+		view.TopMessages = window.Messages.Top()
+		view.TopMessagesByService = make(map[string][]events.MessageCount)
+		for service, messages := range window.ServiceMessages {
+			view.TopMessagesByService[service] = messages.Top()
+		}
+	}
+	for service, rollup := range window.Rollups {
+		if view.Metrics == nil {
+			view.Metrics = make(map[string]*events.ServiceMetricsRollup)
+		}
+		event := rollup.Event(service)
+		event.LatencySketch = nil
+		view.Metrics[service] = event
+	}
+	return view
+}
+
+// forService оставляет в окне только данные сервиса; false — сервиса в окне нет
+func (v WindowView) forService(service string) (WindowView, bool) {
+	count, ok := v.Services[service]
+	if !ok {
+		return WindowView{}, false
+	}
+	view := WindowView{
+		WindowStart: v.WindowStart,
+		WindowEnd:   v.WindowEnd,
+		Service:     v.Service,
+		Services:    map[string]int{service: count},
+		Total:       count,
+		TopMessages: v.TopMessagesByService[service],
+	}
+	for _, group := range v.Groups {
+		if value, ok := group.Key["service"]; ok && value == service {
+			view.Groups = append(view.Groups, group)
+		}
+	}
+	if metrics, ok := v.Metrics[service]; ok {
+		view.Metrics = map[string]*events.ServiceMetricsRollup{service: metrics}
+	}
+	return view, true
+}
+
+// Последние закрытые окна. Хранятся только в памяти: после перезапуска
+// история начинается заново.
+type History struct {
+	limit int
+	views []WindowView
+}
+
+func NewHistory(limit int) *History {
+	return &History{limit: limit}
+}
+
+func (h *History) Add(view WindowView) {
+	h.views = append(h.views, view)
+	if len(h.views) > h.limit {
+		h.views = h.views[len(h.views)-h.limit:]
+	}
+}
+
+// Last возвращает до n последних окон, новые первыми; service фильтрует по сервису
+func (h *History) Last(n int, service string) []WindowView {
+	result := make([]WindowView, 0, min(n, len(h.views)))
+	for i := len(h.views) - 1; i >= 0 && len(result) < n; i-- {
+		view := h.views[i]
+		if service != "" {
+			var ok bool
+			if view, ok = view.forService(service); !ok {
+				continue
+			}
+		}
+		result = append(result, view)
+	}
+	return result
+}
+
+// Владельцы партиций входного топика по данным consumer group.
+//
+// Каждый экземпляр вступает в группу с client.id "<группа>@<адрес HTTP API>",
+// поэтому по описанию группы видно, какой экземпляр читает какую партицию.
+// Партиция сервиса считается так же, как при записи: kafka.Hash от ключа.
+type Router struct {
+	client *kafka.Client
+	group  string
+	topic  string
+	self   string
+
+	mu         sync.Mutex
+	partitions int
+	owners     map[int]string // партиция → адрес HTTP API
+	refreshed  time.Time
+}
+
+// Как долго использовать описание группы без повторного запроса
+const ownersTTL = 5 * time.Second
+
+func NewRouter(brokers []string, group, topic, self string) *Router {
+	return &Router{
+		client: &kafka.Client{Addr: kafka.TCP(brokers...), Timeout: 10 * time.Second},
+		group:  group,
+		topic:  topic,
+		self:   self,
+	}
+}
+
+// ClientID строит client.id, по которому другие экземпляры найдут адрес
+func ClientID(group, advertised string) string {
+	return group + "@" + advertised
+}
+
+// Owner возвращает адрес экземпляра, читающего партицию сервиса
+func (r *Router) Owner(ctx context.Context, service string) (string, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.refreshed) >= ownersTTL {
+		if err := r.refresh(ctx); err != nil {
+			return "", 0, err
+		}
+	}
+	partition := servicePartition(service, r.partitions)
+	if owner, ok := r.owners[partition]; ok {
+		return owner, partition, nil
+	}
+
+	// Партиция без владельца — возможно, описание устарело после ребаланса
+	if err := r.refresh(ctx); err != nil {
+		return "", 0, err
+	}
+	partition = servicePartition(service, r.partitions)
+	owner, ok := r.owners[partition]
+	if !ok {
+		return "", partition, fmt.Errorf("у партиции %d нет владельца (идет ребаланс?)", partition)
+	}
+	return owner, partition, nil
+}
+
+func (r *Router) refresh(ctx context.Context) error {
+	metadata, err := r.client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{r.topic}})
+	if err != nil {
+		return err
+	}
+	if len(metadata.Topics) != 1 || metadata.Topics[0].Error != nil {
+		return fmt.Errorf("нет метаданных топика %s", r.topic)
+	}
+
+	groups, err := r.client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{r.group}})
+	if err != nil {
+		return err
+	}
+	if len(groups.Groups) != 1 || groups.Groups[0].Error != nil {
+		return fmt.Errorf("нет описания группы %s", r.group)
+	}
+
+	owners := make(map[int]string)
+	for _, member := range groups.Groups[0].Members {
+		_, address, ok := strings.Cut(member.ClientID, "@")
+		if !ok {
+			continue
+		}
+		for _, topic := range member.MemberAssignments.Topics {
+			if topic.Topic != r.topic {
+				continue
+			}
+			for _, partition := range topic.Partitions {
+				owners[partition] = address
+			}
+		}
+	}
+
+	r.partitions = len(metadata.Topics[0].Partitions)
+	r.owners = owners
+	r.refreshed = time.Now()
+	return nil
+}
+
+// Партиция, в которую kafka.Hash кладет записи сервиса
+func servicePartition(service string, partitions int) int {
+	if partitions == 0 {
+		return 0
+	}
+	ids := make([]int, partitions)
+	for i := range ids {
+		ids[i] = i
+	}
+	return (&kafka.Hash{}).Balance(kafka.Message{Key: []byte(service)}, ids...)
+}
+
+// HTTP API над состоянием aggregator'а.
+//
+// Окна и история принадлежат основному циклу, поэтому обработчики не читают
+// их сами, а отправляют функцию в канал queries и ждут, пока цикл ее выполнит.
+type QueryServer struct {
+	queries chan func()
+	windows *Windows
+	history *History
+	router  *Router
+	config  StatsConfig
+}
+
+func NewQueryServer(windows *Windows, history *History, router *Router, config StatsConfig) *QueryServer {
+	return &QueryServer{
+		queries: make(chan func()),
+		windows: windows,
+		history: history,
+		router:  router,
+		config:  config,
+	}
+}
+
+// Queries — канал, который основной цикл должен обслуживать
+func (s *QueryServer) Queries() <-chan func() {
+	return s.queries
+}
+
+func (s *QueryServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/windows/open", s.handleOpen)
+	mux.HandleFunc("GET /v1/windows/closed", s.handleClosed)
+	mux.HandleFunc("GET /v1/services/{service}/open", s.routed(s.handleServiceOpen))
+	mux.HandleFunc("GET /v1/services/{service}/history", s.routed(s.handleServiceHistory))
+	return mux
+}
+
+// GET /v1/windows/open — открытые окна партиций этого экземпляра
+func (s *QueryServer) handleOpen(w http.ResponseWriter, r *http.Request) {
+	s.respond(w, r, func() any { return s.openViews("") })
+}
+
+// GET /v1/windows/closed?limit=N — последние закрытые окна этого экземпляра
+func (s *QueryServer) handleClosed(w http.ResponseWriter, r *http.Request) {
+	limit := queryLimit(r)
+	s.respond(w, r, func() any { return s.history.Last(limit, "") })
+}
+
+// GET /v1/services/{service}/open — открытые окна сервиса
+func (s *QueryServer) handleServiceOpen(w http.ResponseWriter, r *http.Request) {
+	service := r.PathValue("service")
+	s.respond(w, r, func() any { return s.openViews(service) })
+}
+
+// GET /v1/services/{service}/history?limit=N — закрытые окна сервиса
+func (s *QueryServer) handleServiceHistory(w http.ResponseWriter, r *http.Request) {
+	service, limit := r.PathValue("service"), queryLimit(r)
+	s.respond(w, r, func() any { return s.history.Last(limit, service) })
+}
+
+// Открытые окна, объединенные по партициям; выполняется в основном цикле
+func (s *QueryServer) openViews(service string) []WindowView {
+	views := []WindowView{}
+	for _, window := range MergeWindows(s.windows.OpenWindows()) {
+		view := newWindowView(window, s.windows.Spec(), s.config)
+		if service != "" {
+			var ok bool
+			if view, ok = view.forService(service); !ok {
+				continue
+			}
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+// Пересылает запрос по сервису экземпляру, который читает его партицию
+func (s *QueryServer) routed(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(forwardedHeader) != "" {
+			handler(w, r)
+			return
+		}
+
+		owner, partition, err := s.router.Owner(r.Context(), r.PathValue("service"))
+		if err != nil {
+			log.Printf("❌ Ошибка поиска владельца %s: %v", r.PathValue("service"), err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if owner == s.router.self {
+			handler(w, r)
+			return
+		}
+
+		log.Printf("↪️  %s: партиция %d у %s", r.URL.Path, partition, owner)
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: owner})
+		r.Header.Set(forwardedHeader, s.router.self)
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// Выполняет запрос в основном цикле и отдает результат в JSON
+func (s *QueryServer) respond(w http.ResponseWriter, r *http.Request, query func() any) {
+	result := make(chan any, 1)
+	select {
+	case s.queries <- func() { result <- query() }:
+	case <-r.Context().Done():
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Aggregator-Instance", s.router.self)
+	if err := json.NewEncoder(w).Encode(<-result); err != nil {
+		log.Printf("❌ Ошибка ответа %s: %v", r.URL.Path, err)
+	}
+}
+
+func queryLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return 10
+	}
+	return limit
+}
+
+// Адрес HTTP API, по которому его найдут другие экземпляры: IP контейнера и порт
+func defaultAdvertisedAddr(listenAddr string) string {
+	_, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		port = "8080"
+	}
+
+	host, _ := os.Hostname()
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+				host = ipNet.IP.String()
+				break
+			}
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"events"
+	"log"
+)
+
+// Точность скетча задержек: квантили с относительной ошибкой не больше 1%
+const latencyAccuracy = 0.01
+
+// Накопленные метрики сервиса в окне (режим metrics)
+type MetricsRollup struct {
+	Samples   int                   `json:"samples"`
+	CPUSum    float64               `json:"cpu_sum"`
+	MemorySum float64               `json:"memory_sum"`
+	Requests  int                   `json:"requests"`
+	Latency   *events.LatencySketch `json:"latency"`
+}
+
+func newMetricsRollup() *MetricsRollup {
+	return &MetricsRollup{Latency: events.NewLatencySketch(latencyAccuracy)}
+}
+
+// Add учитывает один образец метрик
+func (r *MetricsRollup) Add(metrics *events.ServiceMetrics) {
+	r.Samples++
+	r.CPUSum += metrics.CPUUsage
+	r.MemorySum += metrics.MemoryUsage
+	r.Requests += metrics.RequestCount
+	r.Latency.Add(float64(metrics.LatencyMs))
+}
+
+// Merge добавляет метрики того же сервиса из другого окна
+func (r *MetricsRollup) Merge(other *MetricsRollup) {
+	r.Samples += other.Samples
+	r.CPUSum += other.CPUSum
+	r.MemorySum += other.MemorySum
+	r.Requests += other.Requests
+	if err := r.Latency.Merge(other.Latency); err != nil {
+		// Точность задана константой, поэтому расхождение возможно только
+		// в состоянии, сохраненном другой версией aggregator'а
+		log.Printf("⚠️  Скетч задержек не объединен: %v", err)
+	}
+}
+
+// Event строит сводку для service-metrics-rollup; окно заполняет вызывающий
+func (r *MetricsRollup) Event(service string) *events.ServiceMetricsRollup {
+	return &events.ServiceMetricsRollup{
+		Service:       service,
+		Samples:       r.Samples,
+		LatencyP50Ms:  r.Latency.Quantile(0.50),
+		LatencyP90Ms:  r.Latency.Quantile(0.90),
+		LatencyP99Ms:  r.Latency.Quantile(0.99),
+		LatencyMaxMs:  r.Latency.Max,
+		CPUMean:       r.CPUSum / float64(r.Samples),
+		MemoryMean:    r.MemorySum / float64(r.Samples),
+		RequestsTotal: r.Requests,
+		LatencySketch: r.Latency,
+	}
+}
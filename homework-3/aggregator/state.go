@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"topics"
+
+	"github.com/segmentio/kafka-go"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Бакеты локального файла состояния
+var (
+	partitionsBucket = []byte("partitions")
+	windowsBucket    = []byte("windows")
+)
+
+// Состояние одной входной партиции: до какого offset'а учтены записи
+type PartitionState struct {
+	Partition    int       `json:"partition"`
+	NextOffset   int64     `json:"next_offset"`
+	MaxEventTime time.Time `json:"max_event_time"`
+}
+
+// Хранилище состояния окон: локальный bbolt файл + compacted changelog топик.
+//
+// Changelog ко-партиционирован с входным топиком: состояние входной партиции N
+// пишется в партицию N changelog'а. Ключи:
+//
+//	<partition>/window/<start>           — окно (tombstone после закрытия)
+//	<partition>/window/<start>/<service> — session окно сервиса
+//	<partition>/state                    — PartitionState, пишется последним в каждом сохранении
+type StateStore struct {
+	db        *bolt.DB
+	changelog *kafka.Writer
+	brokers   []string
+	topic     string
+}
+
+func OpenStateStore(path string, brokers []string, changelogTopic string) (*StateStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(partitionsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(windowsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	changelog := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      brokers,
+		Topic:        changelogTopic,
+		Balancer:     changelogBalancer{},
+		RequiredAcks: int(kafka.RequireAll),
+		BatchTimeout: 10 * time.Millisecond,
+	})
+
+	return &StateStore{db: db, changelog: changelog, brokers: brokers, topic: changelogTopic}, nil
+}
+
+func (s *StateStore) Close() {
+	s.changelog.Close()
+	s.db.Close()
+}
+
+// Load возвращает состояние партиции. Локальный файл используется, если он
+// совпадает с последней записью changelog'а, иначе состояние восстанавливается
+// из changelog'а (например, партиция досталась нам после ребаланса).
+func (s *StateStore) Load(partition int) (*PartitionState, []*Window, error) {
+	local, localWindows, err := s.loadLocal(partition)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	remote, err := s.lastChangelogState(partition)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if remote == nil || (local != nil && local.NextOffset == remote.NextOffset) {
+		if local == nil {
+			local = &PartitionState{Partition: partition}
+		}
+		log.Printf("💾 Партиция %d: состояние из локального файла (offset %d, окон %d)",
+			partition, local.NextOffset, len(localWindows))
+		return local, localWindows, nil
+	}
+
+	state, windows, err := s.restoreFromChangelog(partition)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.replaceLocal(state, windows); err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("♻️  Партиция %d: состояние восстановлено из changelog (offset %d, окон %d)",
+		partition, state.NextOffset, len(windows))
+	return state, windows, nil
+}
+
+// Save атомарно сохраняет измененные окна и offset партиции в локальный файл,
+// затем синхронно пишет их в changelog. Только после этого можно коммитить offset.
+// Неизмененные окна уже лежат в файле и changelog'е и не переписываются.
+func (s *StateStore) Save(state *PartitionState, changed, closed []*Window) error {
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	var messages []kafka.Message
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		windowsB := tx.Bucket(windowsBucket)
+
+		for _, window := range closed {
+			key := windowStoreKey(window)
+			if err := windowsB.Delete(key); err != nil {
+				return err
+			}
+			messages = append(messages, kafka.Message{Key: key, Value: nil})
+		}
+
+		for _, window := range changed {
+			key := windowStoreKey(window)
+			value, err := json.Marshal(window)
+			if err != nil {
+				return err
+			}
+			if err := windowsB.Put(key, value); err != nil {
+				return err
+			}
+			messages = append(messages, kafka.Message{Key: key, Value: value})
+		}
+
+		key := stateStoreKey(state.Partition)
+		messages = append(messages, kafka.Message{Key: key, Value: stateBytes})
+		return tx.Bucket(partitionsBucket).Put(key, stateBytes)
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.changelog.WriteMessages(context.Background(), messages...)
+}
+
+func (s *StateStore) loadLocal(partition int) (*PartitionState, []*Window, error) {
+	var state *PartitionState
+	var windows []*Window
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(partitionsBucket).Get(stateStoreKey(partition)); value != nil {
+			state = &PartitionState{}
+			if err := json.Unmarshal(value, state); err != nil {
+				return err
+			}
+		}
+
+		prefix := []byte(fmt.Sprintf("%d/", partition))
+		cursor := tx.Bucket(windowsBucket).Cursor()
+		for key, value := cursor.Seek(prefix); key != nil && strings.HasPrefix(string(key), string(prefix)); key, value = cursor.Next() {
+			var window Window
+			if err := json.Unmarshal(value, &window); err != nil {
+				return err
+			}
+			windows = append(windows, &window)
+		}
+		return nil
+	})
+
+	return state, windows, err
+}
+
+func (s *StateStore) replaceLocal(state *PartitionState, windows []*Window) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		windowsB := tx.Bucket(windowsBucket)
+
+		prefix := []byte(fmt.Sprintf("%d/", state.Partition))
+		cursor := windowsB.Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && strings.HasPrefix(string(key), string(prefix)); key, _ = cursor.Seek(prefix) {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+
+		for _, window := range windows {
+			value, err := json.Marshal(window)
+			if err != nil {
+				return err
+			}
+			if err := windowsB.Put(windowStoreKey(window), value); err != nil {
+				return err
+			}
+		}
+
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(partitionsBucket).Put(stateStoreKey(state.Partition), value)
+	})
+}
+
+// Последняя запись партиции changelog'а — всегда PartitionState
+func (s *StateStore) lastChangelogState(partition int) (*PartitionState, error) {
+	conn, err := kafka.DialLeader(context.Background(), "tcp", s.brokers[0], s.topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil {
+		return nil, err
+	}
+	if last <= first {
+		return nil, nil
+	}
+
+	if _, err := conn.Seek(last-1, kafka.SeekAbsolute); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	message, err := conn.ReadMessage(10e6)
+	if err != nil {
+		return nil, err
+	}
+
+	var state PartitionState
+	if err := json.Unmarshal(message.Value, &state); err != nil {
+		return nil, fmt.Errorf("последняя запись changelog не является состоянием партиции: %w", err)
+	}
+	return &state, nil
+}
+
+// Полностью перечитывает партицию changelog'а
+func (s *StateStore) restoreFromChangelog(partition int) (*PartitionState, []*Window, error) {
+	conn, err := kafka.DialLeader(context.Background(), "tcp", s.brokers[0], s.topic, partition)
+	if err != nil {
+		return nil, nil, err
+	}
+	first, last, err := conn.ReadOffsets()
+	conn.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state := &PartitionState{Partition: partition}
+	windows := make(map[string]*Window)
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   s.brokers,
+		Topic:     s.topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  10e6,
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(first); err != nil {
+		return nil, nil, err
+	}
+
+	for offset := first; offset < last; {
+		message, err := reader.ReadMessage(context.Background())
+		if err != nil {
+			return nil, nil, err
+		}
+		offset = message.Offset + 1
+
+		key := string(message.Key)
+		switch {
+		case strings.HasSuffix(key, "/state"):
+			if err := json.Unmarshal(message.Value, state); err != nil {
+				return nil, nil, err
+			}
+		case message.Value == nil:
+			delete(windows, key)
+		default:
+			var window Window
+			if err := json.Unmarshal(message.Value, &window); err != nil {
+				return nil, nil, err
+			}
+			windows[key] = &window
+		}
+	}
+
+	var result []*Window
+	for _, window := range windows {
+		result = append(result, window)
+	}
+	return state, result, nil
+}
+
+// EnsureChangelogTopic создает compacted changelog с тем же числом партиций,
+// что и входной топик, а у существующего changelog проверяет партиции и
+// включает компакцию. Наличие топиков проверяется запросом метаданных без
+// автосоздания: иначе брокер с auto.create.topics.enable создал бы
+// changelog с настройками по умолчанию.
+func EnsureChangelogTopic(brokers []string, inputTopic, changelogTopic string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), topics.Timeout)
+	defer cancel()
+	client := topics.NewClient(brokers)
+
+	input, err := topics.Partitions(ctx, client, inputTopic)
+	if err != nil {
+		return err
+	}
+	if len(input) == 0 {
+		return fmt.Errorf("входной топик %s не найден", inputTopic)
+	}
+
+	changelog, err := topics.Partitions(ctx, client, changelogTopic)
+	if err != nil {
+		return err
+	}
+	if len(changelog) == 0 {
+		log.Printf("🆕 Создаем changelog %s (%d партиций)", changelogTopic, len(input))
+		err := topics.Create(ctx, client, kafka.TopicConfig{
+			Topic:             changelogTopic,
+			NumPartitions:     len(input),
+			ReplicationFactor: -1,
+			ConfigEntries:     topics.Compacted(),
+		})
+		if err != nil {
+			return err
+		}
+		// Топик мог создать другой экземпляр: проверяем его как существующий
+		if changelog, err = topics.Partitions(ctx, client, changelogTopic); err != nil {
+			return err
+		}
+	}
+
+	if len(changelog) != len(input) {
+		return fmt.Errorf("в changelog %s %d партиций, а во входном топике %s — %d",
+			changelogTopic, len(changelog), inputTopic, len(input))
+	}
+	altered, err := topics.EnsureCompacted(ctx, client, changelogTopic)
+	if altered {
+		log.Printf("🔧 Включена компакция для топика %s", changelogTopic)
+	}
+	return err
+}
+
+// Balancer, который пишет запись в партицию из префикса ключа
+type changelogBalancer struct{}
+
+func (changelogBalancer) Balance(message kafka.Message, partitions ...int) int {
+	prefix, _, _ := strings.Cut(string(message.Key), "/")
+	partition, err := strconv.Atoi(prefix)
+	if err != nil {
+		return partitions[0]
+	}
+	return partition
+}
+
+func windowStoreKey(window *Window) []byte {
+	if window.Service != "" {
+		return []byte(fmt.Sprintf("%d/window/%d/%s", window.Partition, window.Start.Unix(), window.Service))
+	}
+	return []byte(fmt.Sprintf("%d/window/%d", window.Partition, window.Start.Unix()))
+}
+
+func stateStoreKey(partition int) []byte {
+	return []byte(fmt.Sprintf("%d/state", partition))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"container/heap"
+	"events"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Глубина count-min sketch: число независимых строк счетчиков
+const sketchDepth = 4
+
+// Настройки поиска частых сообщений
+type TopKConfig struct {
+	K     int // сколько сообщений хранить, 0 — отключить
+	Width int // ширина count-min sketch
+}
+
+// Count-min sketch: оценка частоты строки сверху в фиксированной памяти.
+// Оценка не меньше точной и превышает ее не больше чем на ~e/Width от
+// общего числа записей. Скетчи одного размера складываются поячеечно.
+type CountMinSketch struct {
+	Width  int      `json:"width"`
+	Counts []uint32 `json:"counts"` // sketchDepth строк по Width счетчиков
+}
+
+func NewCountMinSketch(width int) *CountMinSketch {
+	return &CountMinSketch{Width: width, Counts: make([]uint32, width*sketchDepth)}
+}
+
+// Add учитывает строку и возвращает новую оценку ее частоты.
+// Консервативное обновление: растут только счетчики, равные минимуму, —
+// оценка остается сверху, но меньше завышается чужими строками.
+func (s *CountMinSketch) Add(item string) int {
+	cells := s.cells(item)
+	estimate := s.estimate(cells) + 1
+	for _, cell := range cells {
+		if s.Counts[cell] < estimate {
+			s.Counts[cell] = estimate
+		}
+	}
+	return int(estimate)
+}
+
+// Estimate возвращает оценку частоты строки
+func (s *CountMinSketch) Estimate(item string) int {
+	return int(s.estimate(s.cells(item)))
+}
+
+func (s *CountMinSketch) estimate(cells [sketchDepth]int) uint32 {
+	estimate := s.Counts[cells[0]]
+	for _, cell := range cells[1:] {
+		estimate = min(estimate, s.Counts[cell])
+	}
+	return estimate
+}
+
+// Merge складывает счетчики скетча того же размера
+func (s *CountMinSketch) Merge(other *CountMinSketch) error {
+	if other.Width != s.Width || len(other.Counts) != len(s.Counts) {
+		return fmt.Errorf("скетчи разного размера: %d и %d", s.Width, other.Width)
+	}
+	for i, count := range other.Counts {
+		s.Counts[i] += count
+	}
+	return nil
+}
+
+// Ячейки строки во всех строках скетча (двойное хэширование FNV-1a)
+func (s *CountMinSketch) cells(item string) [sketchDepth]int {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(item))
+	sum := hasher.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+
+	var cells [sketchDepth]int
+	for row := 0; row < sketchDepth; row++ {
+		cells[row] = row*s.Width + int((h1+uint32(row)*h2)%uint32(s.Width))
+	}
+	return cells
+}
+
+// Частое сообщение с оценкой количества
+type HeavyHitter struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// K самых частых сообщений окна в ограниченной памяти: count-min sketch
+// оценивает частоту любого сообщения, а min-heap на K элементов держит
+// текущих лидеров. Сообщение вытесняет самого редкого лидера, когда его
+// оценка становится больше.
+type TopK struct {
+	K      int             `json:"k"`
+	Sketch *CountMinSketch `json:"sketch"`
+	Items  []HeavyHitter   `json:"items"` // min-heap по Count
+
+	index map[string]int // сообщение → позиция в Items
+}
+
+func NewTopK(config TopKConfig) *TopK {
+	return &TopK{K: config.K, Sketch: NewCountMinSketch(config.Width)}
+}
+
+// Add учитывает одно сообщение
+func (t *TopK) Add(message string) {
+	t.offer(message, t.Sketch.Add(message))
+}
+
+// Merge добавляет сообщения другого окна: лидеры обоих окон заново
+// оцениваются по сложенному скетчу
+func (t *TopK) Merge(other *TopK) error {
+	if err := t.Sketch.Merge(other.Sketch); err != nil {
+		return err
+	}
+	candidates := make(map[string]bool)
+	for _, item := range t.Items {
+		candidates[item.Message] = true
+	}
+	for _, item := range other.Items {
+		candidates[item.Message] = true
+	}
+	for message := range candidates {
+		t.offer(message, t.Sketch.Estimate(message))
+	}
+	return nil
+}
+
+func (t *TopK) offer(message string, estimate int) {
+	t.rebuildIndex()
+	if i, ok := t.index[message]; ok {
+		t.Items[i].Count = estimate
+		heap.Fix(t, i)
+		return
+	}
+	if len(t.Items) < t.K {
+		heap.Push(t, HeavyHitter{Message: message, Count: estimate})
+		return
+	}
+	if len(t.Items) > 0 && estimate > t.Items[0].Count {
+		delete(t.index, t.Items[0].Message)
+		t.Items[0] = HeavyHitter{Message: message, Count: estimate}
+		t.index[message] = 0
+		heap.Fix(t, 0)
+	}
+}
+
+// Индекс не сохраняется в состоянии — восстанавливаем после загрузки
+func (t *TopK) rebuildIndex() {
+	if t.index != nil {
+		return
+	}
+	t.index = make(map[string]int, len(t.Items))
+	for i, item := range t.Items {
+		t.index[item.Message] = i
+	}
+}
+
+// Top возвращает лидеров по убыванию количества
+func (t *TopK) Top() []events.MessageCount {
+	top := make([]events.MessageCount, 0, len(t.Items))
+	for _, item := range t.Items {
+		top = append(top, events.MessageCount{Message: item.Message, Count: item.Count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count == top[j].Count {
+			return top[i].Message < top[j].Message
+		}
+		return top[i].Count > top[j].Count
+	})
+	return top
+}
+
+// Clone возвращает независимую копию
+func (t *TopK) Clone() *TopK {
+	return &TopK{
+		K:      t.K,
+		Sketch: &CountMinSketch{Width: t.Sketch.Width, Counts: append([]uint32(nil), t.Sketch.Counts...)},
+		Items:  append([]HeavyHitter(nil), t.Items...),
+	}
+}
+
+// heap.Interface
+func (t *TopK) Len() int           { return len(t.Items) }
+func (t *TopK) Less(i, j int) bool { return t.Items[i].Count < t.Items[j].Count }
+
+func (t *TopK) Swap(i, j int) {
+	t.Items[i], t.Items[j] = t.Items[j], t.Items[i]
+	t.index[t.Items[i].Message] = i
+	t.index[t.Items[j].Message] = j
+}
+
+func (t *TopK) Push(x any) {
+	item := x.(HeavyHitter)
+	t.index[item.Message] = len(t.Items)
+	t.Items = append(t.Items, item)
+}
+
+func (t *TopK) Pop() any {
+	last := t.Items[len(t.Items)-1]
+	t.Items = t.Items[:len(t.Items)-1]
+	delete(t.index, last.Message)
+	return last
+}
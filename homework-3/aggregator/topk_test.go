@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestCountMinSketchNeverUnderestimates(t *testing.T) {
+	sketch := NewCountMinSketch(16)
+	exact := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		item := fmt.Sprintf("message-%d", i%(1+i%50))
+		exact[item]++
+		sketch.Add(item)
+	}
+	for item, count := range exact {
+		if estimate := sketch.Estimate(item); estimate < count {
+			t.Errorf("%s: оценка %d меньше точной %d", item, estimate, count)
+		}
+	}
+}
+
+func TestCountMinSketchConservativeUpdate(t *testing.T) {
+	// Обычный count-min sketch увеличивает все ячейки строки; консервативное
+	// обновление не должно давать оценку больше обычной
+	const width = 8
+	sketch := NewCountMinSketch(width)
+	plain := NewCountMinSketch(width)
+	exact := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		item := fmt.Sprintf("message-%d", (i*7)%40)
+		exact[item]++
+		sketch.Add(item)
+		for _, cell := range plain.cells(item) {
+			plain.Counts[cell]++
+		}
+	}
+
+	overestimated := false
+	for item, count := range exact {
+		estimate, upper := sketch.Estimate(item), plain.Estimate(item)
+		if estimate < count || estimate > upper {
+			t.Errorf("%s: оценка %d вне [%d, %d]", item, estimate, count, upper)
+		}
+		if estimate < upper {
+			overestimated = true
+		}
+	}
+	if !overestimated {
+		t.Error("консервативное обновление ни разу не уменьшило оценку")
+	}
+}
+
+func TestCountMinSketchAddReturnsEstimate(t *testing.T) {
+	sketch := NewCountMinSketch(1024)
+	for i := 1; i <= 5; i++ {
+		if got := sketch.Add("boom"); got != i {
+			t.Fatalf("Add вернул %d, ожидали %d", got, i)
+		}
+	}
+	if got := sketch.Estimate("boom"); got != 5 {
+		t.Errorf("оценка %d, ожидали 5", got)
+	}
+}
+
+func TestCountMinSketchMerge(t *testing.T) {
+	a, b := NewCountMinSketch(1024), NewCountMinSketch(1024)
+	for i := 0; i < 3; i++ {
+		a.Add("x")
+	}
+	for i := 0; i < 4; i++ {
+		b.Add("x")
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Estimate("x"); got != 7 {
+		t.Errorf("оценка после слияния %d, ожидали 7", got)
+	}
+	if err := a.Merge(NewCountMinSketch(512)); err == nil {
+		t.Error("скетчи разного размера слиты без ошибки")
+	}
+}
+
+func addTimes(topK *TopK, message string, n int) {
+	for i := 0; i < n; i++ {
+		topK.Add(message)
+	}
+}
+
+func TestTopKKeepsMostFrequent(t *testing.T) {
+	topK := NewTopK(TopKConfig{K: 3, Width: 2048})
+	counts := map[string]int{"a": 50, "b": 40, "c": 30, "d": 20, "e": 10}
+	// Редкие сообщения приходят первыми и занимают кучу
+	for _, message := range []string{"e", "d", "c", "b", "a"} {
+		addTimes(topK, message, counts[message])
+	}
+
+	top := topK.Top()
+	want := []string{"a", "b", "c"}
+	if len(top) != len(want) {
+		t.Fatalf("лидеров %d, ожидали %d: %v", len(top), len(want), top)
+	}
+	for i, message := range want {
+		if top[i].Message != message || top[i].Count != counts[message] {
+			t.Errorf("место %d: %v, ожидали %s=%d", i, top[i], message, counts[message])
+		}
+	}
+}
+
+func TestTopKEvictionNearKth(t *testing.T) {
+	topK := NewTopK(TopKConfig{K: 2, Width: 2048})
+	addTimes(topK, "a", 10)
+	addTimes(topK, "b", 5)
+
+	// Равная оценка не вытесняет лидера
+	addTimes(topK, "c", 5)
+	if top := topK.Top(); top[1].Message != "b" {
+		t.Fatalf("сообщение с равной частотой вытеснило лидера: %v", top)
+	}
+
+	// Оценка на единицу больше K-го вытесняет его
+	topK.Add("c")
+	top := topK.Top()
+	if top[0].Message != "a" || top[1].Message != "c" || top[1].Count != 6 {
+		t.Fatalf("ожидали a=10, c=6: %v", top)
+	}
+
+	// Вытесненное сообщение возвращается, когда снова обгоняет K-го
+	addTimes(topK, "b", 2)
+	if top := topK.Top(); top[1].Message != "b" || top[1].Count != 7 {
+		t.Fatalf("ожидали b=7 на втором месте: %v", top)
+	}
+}
+
+func TestTopKTiesOrderedByMessage(t *testing.T) {
+	topK := NewTopK(TopKConfig{K: 3, Width: 2048})
+	for _, message := range []string{"c", "a", "b"} {
+		addTimes(topK, message, 4)
+	}
+	top := topK.Top()
+	for i, message := range []string{"a", "b", "c"} {
+		if top[i].Message != message {
+			t.Fatalf("порядок при равенстве: %v", top)
+		}
+	}
+}
+
+func TestTopKMerge(t *testing.T) {
+	a := NewTopK(TopKConfig{K: 2, Width: 2048})
+	b := NewTopK(TopKConfig{K: 2, Width: 2048})
+	addTimes(a, "x", 5)
+	addTimes(a, "y", 4)
+	addTimes(b, "z", 6)
+	addTimes(b, "y", 3)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	top := a.Top()
+	if len(top) != 2 || top[0].Message != "y" || top[0].Count != 7 || top[1].Message != "z" || top[1].Count != 6 {
+		t.Fatalf("ожидали y=7, z=6: %v", top)
+	}
+}
+
+func TestTopKRestoredFromState(t *testing.T) {
+	topK := NewTopK(TopKConfig{K: 2, Width: 2048})
+	addTimes(topK, "a", 3)
+	addTimes(topK, "b", 2)
+
+	data, err := json.Marshal(topK.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored TopK
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+
+	// Индекс восстанавливается, и счет продолжается с сохраненных значений
+	addTimes(&restored, "b", 2)
+	top := restored.Top()
+	if top[0].Message != "b" || top[0].Count != 4 || top[1].Message != "a" || top[1].Count != 3 {
+		t.Fatalf("ожидали b=4, a=3: %v", top)
+	}
+	// Исходный TopK не изменился
+	if top := topK.Top(); top[0].Message != "a" || top[1].Count != 2 {
+		t.Fatalf("копия изменила исходный TopK: %v", top)
+	}
+}
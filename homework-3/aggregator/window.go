@@ -0,0 +1,520 @@
+package main
+
+import (
+	"events"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Окно агрегации с счетчиками записей по сервисам.
+// Окна ведутся отдельно для каждой входной партиции, чтобы состояние
+// партиции можно было сохранить и восстановить независимо.
+type Window struct {
+	Partition       int                       `json:"partition"`
+	Start           time.Time                 `json:"start"`
+	End             time.Time                 `json:"end"`
+	Service         string                    `json:"service,omitempty"` // сервис session окна
+	Counts          map[string]int            `json:"counts"`
+	Kinds           map[string]int            `json:"kinds,omitempty"`            // счетчики по отпечаткам ошибок
+	Templates       map[string]string         `json:"templates,omitempty"`        // отпечаток → шаблон
+	Rollups         map[string]*MetricsRollup `json:"rollups,omitempty"`          // метрики по сервисам (режим metrics)
+	Messages        *TopK                     `json:"messages,omitempty"`         // частые сообщения окна
+	ServiceMessages map[string]*TopK          `json:"service_messages,omitempty"` // частые сообщения по сервисам
+	Groups          map[string]*Group         `json:"groups,omitempty"`           // группы GROUP_BY
+}
+
+func newWindow(partition int, start, end time.Time, service string) *Window {
+	return &Window{Partition: partition, Start: start, End: end, Service: service, Counts: make(map[string]int)}
+}
+
+// Total возвращает общее количество ошибок в окне
+func (w *Window) Total() int {
+	total := 0
+	for _, count := range w.Counts {
+		total += count
+	}
+	return total
+}
+
+// AddError учитывает одну ошибку в счетчиках, группах и, при topMessages.K > 0,
+// в частых сообщениях
+func (w *Window) AddError(errorLog *events.ErrorLog, topMessages TopKConfig, groupBy *GroupBy) {
+	w.Counts[errorLog.Service]++
+	if errorLog.Fingerprint != "" {
+		w.AddKind(errorLog.Fingerprint, errorLog.Template, 1)
+	}
+	if w.Groups == nil {
+		w.Groups = make(map[string]*Group)
+	}
+	groupBy.Add(w.Groups, errorLog)
+	if topMessages.K <= 0 {
+		return
+	}
+
+	if w.Messages == nil {
+		w.Messages = NewTopK(topMessages)
+		w.ServiceMessages = make(map[string]*TopK)
+	}
+	w.Messages.Add(errorLog.Error)
+	serviceMessages, ok := w.ServiceMessages[errorLog.Service]
+	if !ok {
+		serviceMessages = NewTopK(topMessages)
+		w.ServiceMessages[errorLog.Service] = serviceMessages
+	}
+	serviceMessages.Add(errorLog.Error)
+}
+
+// AddMetrics учитывает один образец метрик
+func (w *Window) AddMetrics(metrics *events.ServiceMetrics) {
+	w.Counts[metrics.Service]++
+	if w.Rollups == nil {
+		w.Rollups = make(map[string]*MetricsRollup)
+	}
+	rollup, ok := w.Rollups[metrics.Service]
+	if !ok {
+		rollup = newMetricsRollup()
+		w.Rollups[metrics.Service] = rollup
+	}
+	rollup.Add(metrics)
+}
+
+// Merge добавляет счетчики другого окна
+func (w *Window) Merge(other *Window) {
+	for service, count := range other.Counts {
+		w.Counts[service] += count
+	}
+	for fingerprint, count := range other.Kinds {
+		w.AddKind(fingerprint, other.Templates[fingerprint], count)
+	}
+	if other.Messages != nil {
+		w.Messages = mergeTopK(w.Messages, other.Messages)
+		if w.ServiceMessages == nil {
+			w.ServiceMessages = make(map[string]*TopK)
+		}
+		for service, messages := range other.ServiceMessages {
+			w.ServiceMessages[service] = mergeTopK(w.ServiceMessages[service], messages)
+		}
+	}
+	if other.Groups != nil {
+		if w.Groups == nil {
+			w.Groups = make(map[string]*Group)
+		}
+		mergeGroups(w.Groups, other.Groups)
+	}
+	for service, rollup := range other.Rollups {
+		if w.Rollups == nil {
+			w.Rollups = make(map[string]*MetricsRollup)
+		}
+		if _, ok := w.Rollups[service]; !ok {
+			w.Rollups[service] = newMetricsRollup()
+		}
+		w.Rollups[service].Merge(rollup)
+	}
+}
+
+// Объединяет частые сообщения; окно без них получает копию чужих
+func mergeTopK(into, other *TopK) *TopK {
+	if into == nil {
+		return other.Clone()
+	}
+	if err := into.Merge(other); err != nil {
+		// Размер скетча мог поменяться между перезапусками — оставляем свои
+		log.Printf("⚠️  Частые сообщения не объединены: %v", err)
+	}
+	return into
+}
+
+// AddKind учитывает вид ошибки; последний увиденный шаблон отпечатка побеждает
+func (w *Window) AddKind(fingerprint, template string, count int) {
+	if w.Kinds == nil {
+		w.Kinds = make(map[string]int)
+		w.Templates = make(map[string]string)
+	}
+	w.Kinds[fingerprint] += count
+	w.Templates[fingerprint] = template
+}
+
+// TopKinds возвращает limit самых частых видов ошибок по убыванию
+func (w *Window) TopKinds(limit int) []events.ErrorKind {
+	kinds := make([]events.ErrorKind, 0, len(w.Kinds))
+	for fingerprint, count := range w.Kinds {
+		kinds = append(kinds, events.ErrorKind{
+			Fingerprint: fingerprint,
+			Template:    w.Templates[fingerprint],
+			Count:       count,
+		})
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if kinds[i].Count == kinds[j].Count {
+			return kinds[i].Fingerprint < kinds[j].Fingerprint
+		}
+		return kinds[i].Count > kinds[j].Count
+	})
+	if len(kinds) > limit {
+		kinds = kinds[:limit]
+	}
+	return kinds
+}
+
+// Ключ окна: партиция + начало окна (+ сервис для session окон)
+type windowKey struct {
+	partition int
+	start     int64
+	service   string
+}
+
+func keyOf(window *Window) windowKey {
+	return windowKey{partition: window.Partition, start: window.Start.UnixNano(), service: window.Service}
+}
+
+// Параметры окон: тип и длительности
+type WindowSpec struct {
+	Type    string        // events.WindowTumbling, WindowHopping или WindowSession
+	Size    time.Duration // длина окна (tumbling, hopping)
+	Advance time.Duration // шаг hopping окон; у tumbling равен Size
+	Gap     time.Duration // пауза, после которой сессия закрывается
+}
+
+// Validate проверяет, что длительности подходят типу окна
+func (s WindowSpec) Validate() error {
+	switch s.Type {
+	case events.WindowTumbling, events.WindowHopping:
+		if s.Size <= 0 || s.Advance <= 0 {
+			return fmt.Errorf("длина и шаг окна должны быть положительными")
+		}
+		if s.Advance > s.Size {
+			return fmt.Errorf("шаг окна %s больше длины %s: часть ошибок не попадет ни в одно окно", s.Advance, s.Size)
+		}
+		if s.Type == events.WindowTumbling && s.Advance != s.Size {
+			return fmt.Errorf("у tumbling окна шаг равен длине")
+		}
+	case events.WindowSession:
+		if s.Gap <= 0 {
+			return fmt.Errorf("пауза сессии должна быть положительной")
+		}
+	default:
+		return fmt.Errorf("неизвестный тип окна %q", s.Type)
+	}
+	return nil
+}
+
+func (s WindowSpec) String() string {
+	switch s.Type {
+	case events.WindowHopping:
+		return fmt.Sprintf("hopping %s с шагом %s", s.Size, s.Advance)
+	case events.WindowSession:
+		return fmt.Sprintf("session по сервисам, пауза %s", s.Gap)
+	}
+	return fmt.Sprintf("tumbling %s", s.Size)
+}
+
+// Окна по времени события с watermark.
+//
+// Watermark — минимальное по активным партициям максимальное время события
+// минус допустимое опоздание. Окно закрывается, когда watermark проходит его
+// конец, а записи в уже закрытые окна считаются опоздавшими. Партиции без
+// записей дольше idleTimeout не сдерживают watermark. Назначенная партиция,
+// из которой еще не было записей, сдерживает его своим сохраненным временем, а
+// без сохраненного времени держит watermark на месте до первой записи или idleTimeout.
+//
+// Tumbling и hopping окна выровнены по шагу: ошибка попадает во все открытые
+// окна, которые ее накрывают. Session окно ведется для каждого сервиса от
+// первой ошибки до последней; его End — последняя ошибка плюс пауза, поэтому
+// окно закрывается, когда watermark уходит на паузу дальше последней ошибки.
+type Windows struct {
+	spec        WindowSpec
+	lateness    time.Duration
+	idleTimeout time.Duration
+
+	windows        map[windowKey]*Window
+	removed        []*Window          // session окна, поглощенные при слиянии
+	changed        map[windowKey]bool // окна, измененные после последнего сохранения
+	partitionTimes map[int]time.Time  // максимальное время события по партициям
+	partitionSeen  map[int]time.Time  // когда партицию назначили или она последний раз присылала запись
+	watermark      time.Time          // watermark никогда не двигается назад
+}
+
+func NewWindows(spec WindowSpec, lateness, idleTimeout time.Duration) *Windows {
+	return &Windows{
+		spec:           spec,
+		lateness:       lateness,
+		idleTimeout:    idleTimeout,
+		windows:        make(map[windowKey]*Window),
+		changed:        make(map[windowKey]bool),
+		partitionTimes: make(map[int]time.Time),
+		partitionSeen:  make(map[int]time.Time),
+	}
+}
+
+// Spec возвращает параметры окон
+func (tw *Windows) Spec() WindowSpec {
+	return tw.spec
+}
+
+// Add учитывает запись сервиса во всех ее окнах по времени события: update
+// вызывается для каждого окна. Возвращает false, если запись опоздала и все
+// ее окна уже закрыты.
+func (tw *Windows) Add(partition int, eventTime time.Time, service string, update func(*Window)) bool {
+	var added bool
+	if tw.spec.Type == events.WindowSession {
+		added = tw.addSession(partition, eventTime, service, update)
+	} else {
+		added = tw.addHopping(partition, eventTime, update)
+	}
+	if !added {
+		return false
+	}
+
+	if eventTime.After(tw.partitionTimes[partition]) {
+		tw.partitionTimes[partition] = eventTime
+	}
+	tw.partitionSeen[partition] = time.Now()
+	return true
+}
+
+// Tumbling окно — частный случай hopping с шагом, равным длине
+func (tw *Windows) addHopping(partition int, eventTime time.Time, update func(*Window)) bool {
+	watermark := tw.Watermark()
+
+	// Самое позднее окно, накрывающее событие; более ранние — с шагом назад
+	latest := eventTime.Truncate(tw.spec.Advance)
+	if !latest.Add(tw.spec.Size).After(watermark) {
+		return false
+	}
+
+	for start := latest; start.Add(tw.spec.Size).After(eventTime); start = start.Add(-tw.spec.Advance) {
+		end := start.Add(tw.spec.Size)
+		// Окна, которые прошел watermark, уже отправлены — не открываем их заново
+		if !end.After(watermark) {
+			break
+		}
+
+		key := windowKey{partition: partition, start: start.UnixNano()}
+		window, ok := tw.windows[key]
+		if !ok {
+			window = newWindow(partition, start, end, "")
+			tw.windows[key] = window
+		}
+		update(window)
+		tw.changed[key] = true
+	}
+	return true
+}
+
+// Ошибка продлевает сессию сервиса, если попадает в паузу от ее начала или
+// конца. Ошибка между двумя сессиями объединяет их в одну.
+func (tw *Windows) addSession(partition int, eventTime time.Time, service string, update func(*Window)) bool {
+	gap := tw.spec.Gap
+	start, end := eventTime, eventTime.Add(gap)
+
+	var touched []*Window
+	for key, window := range tw.windows {
+		if key.partition != partition || key.service != service {
+			continue
+		}
+		if eventTime.Before(window.End) && eventTime.After(window.Start.Add(-gap)) {
+			touched = append(touched, window)
+			if window.Start.Before(start) {
+				start = window.Start
+			}
+			if window.End.After(end) {
+				end = window.End
+			}
+		}
+	}
+
+	// Новая сессия, которую watermark уже прошел, — опоздавшая запись
+	if len(touched) == 0 && !end.After(tw.Watermark()) {
+		return false
+	}
+
+	session := newWindow(partition, start, end, service)
+	for _, window := range touched {
+		delete(tw.windows, keyOf(window))
+		delete(tw.changed, keyOf(window))
+		tw.removed = append(tw.removed, window)
+		session.Merge(window)
+	}
+	update(session)
+	tw.windows[keyOf(session)] = session
+	tw.changed[keyOf(session)] = true
+	return true
+}
+
+// Removed возвращает и забывает session окна, поглощенные при слиянии или
+// продлении: их нужно удалить из сохраненного состояния
+func (tw *Windows) Removed() []*Window {
+	removed := tw.removed
+	tw.removed = nil
+	return removed
+}
+
+// Restore заменяет окна партиции сохраненным состоянием. С этого момента
+// партиция участвует в watermark, даже если из нее еще не пришло записей.
+func (tw *Windows) Restore(partition int, maxEventTime time.Time, windows []*Window) {
+	tw.forget(partition)
+	if maxEventTime.IsZero() {
+		delete(tw.partitionTimes, partition)
+	} else {
+		tw.partitionTimes[partition] = maxEventTime
+	}
+	tw.partitionSeen[partition] = time.Now()
+	for _, window := range windows {
+		tw.windows[keyOf(window)] = window
+	}
+}
+
+// Drop забывает окна и время событий партиции без закрытия окон:
+// партицию отозвали при ребалансе, и ее окна отправит новый владелец
+func (tw *Windows) Drop(partition int) {
+	tw.forget(partition)
+	delete(tw.partitionTimes, partition)
+	delete(tw.partitionSeen, partition)
+}
+
+func (tw *Windows) forget(partition int) {
+	for key := range tw.windows {
+		if key.partition == partition {
+			delete(tw.windows, key)
+		}
+	}
+	for key := range tw.changed {
+		if key.partition == partition {
+			delete(tw.changed, key)
+		}
+	}
+}
+
+// PartitionTime возвращает максимальное время события в партиции
+func (tw *Windows) PartitionTime(partition int) time.Time {
+	return tw.partitionTimes[partition]
+}
+
+// ChangedWindows возвращает открытые окна партиции, измененные после
+// последнего сохранения: остальные уже лежат в состоянии как есть
+func (tw *Windows) ChangedWindows(partition int) []*Window {
+	var result []*Window
+	for key := range tw.changed {
+		if key.partition == partition {
+			result = append(result, tw.windows[key])
+		}
+	}
+	return result
+}
+
+// Saved отмечает окна партиции сохраненными
+func (tw *Windows) Saved(partition int) {
+	for key := range tw.changed {
+		if key.partition == partition {
+			delete(tw.changed, key)
+		}
+	}
+}
+
+// Watermark возвращает текущую отметку времени, до которой окна считаются полными.
+// Если все партиции простаивают, watermark двигается вперед на время простоя,
+// чтобы последние окна закрылись без новых записей.
+func (tw *Windows) Watermark() time.Time {
+	if current := tw.computeWatermark(); current.After(tw.watermark) {
+		tw.watermark = current
+	}
+	return tw.watermark
+}
+
+func (tw *Windows) computeWatermark() time.Time {
+	var minActive, maxTime, lastSeen time.Time
+	for partition, seen := range tw.partitionSeen {
+		t := tw.partitionTimes[partition]
+		if t.After(maxTime) {
+			maxTime = t
+		}
+		if seen.After(lastSeen) {
+			lastSeen = seen
+		}
+		if tw.idleTimeout > 0 && time.Since(seen) >= tw.idleTimeout {
+			continue
+		}
+		// Активная партиция без времени событий: неизвестно, насколько она
+		// отстает, поэтому watermark стоит на месте
+		if t.IsZero() {
+			return time.Time{}
+		}
+		if minActive.IsZero() || t.Before(minActive) {
+			minActive = t
+		}
+	}
+
+	switch {
+	case maxTime.IsZero():
+		return maxTime
+	case !minActive.IsZero():
+		return minActive.Add(-tw.lateness)
+	default:
+		return maxTime.Add(time.Since(lastSeen)).Add(-tw.lateness)
+	}
+}
+
+// CloseExpired удаляет и возвращает окна, которые прошел watermark, по порядку начала
+func (tw *Windows) CloseExpired() []*Window {
+	watermark := tw.Watermark()
+
+	var closed []*Window
+	for key, window := range tw.windows {
+		if !window.End.After(watermark) {
+			closed = append(closed, window)
+			delete(tw.windows, key)
+			delete(tw.changed, key)
+		}
+	}
+
+	sortWindows(closed)
+	return closed
+}
+
+// OpenWindows возвращает открытые окна всех партиций по порядку начала
+func (tw *Windows) OpenWindows() []*Window {
+	open := make([]*Window, 0, len(tw.windows))
+	for _, window := range tw.windows {
+		open = append(open, window)
+	}
+	sortWindows(open)
+	return open
+}
+
+// Порядок, который ожидает MergeWindows: начало, сервис, партиция
+func sortWindows(windows []*Window) {
+	sort.Slice(windows, func(i, j int) bool {
+		switch {
+		case !windows[i].Start.Equal(windows[j].Start):
+			return windows[i].Start.Before(windows[j].Start)
+		case windows[i].Service != windows[j].Service:
+			return windows[i].Service < windows[j].Service
+		}
+		return windows[i].Partition < windows[j].Partition
+	})
+}
+
+// Open возвращает количество открытых окон
+func (tw *Windows) Open() int {
+	return len(tw.windows)
+}
+
+// MergeWindows объединяет окна разных партиций с одинаковыми границами.
+// Окна должны быть отсортированы по началу, как их возвращает CloseExpired.
+// Session окна одного сервиса живут в одной партиции и не объединяются.
+func MergeWindows(windows []*Window) []*Window {
+	var merged []*Window
+	for _, window := range windows {
+		if len(merged) == 0 || !sameBounds(merged[len(merged)-1], window) {
+			merged = append(merged, newWindow(window.Partition, window.Start, window.End, window.Service))
+		}
+		merged[len(merged)-1].Merge(window)
+	}
+	return merged
+}
+
+func sameBounds(a, b *Window) bool {
+	return a.Start.Equal(b.Start) && a.End.Equal(b.End) && a.Service == b.Service
+}
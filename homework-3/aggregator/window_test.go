@@ -0,0 +1,322 @@
+package main
+
+import (
+	"events"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var windowBase = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return windowBase.Add(time.Duration(seconds) * time.Second)
+}
+
+// Запись из партиции: время события в секундах от windowBase
+type windowRecord struct {
+	partition int
+	seconds   int
+	service   string
+}
+
+// Учитывает запись и возвращает false, если она опоздала
+func addRecord(windows *Windows, record windowRecord) bool {
+	return windows.Add(record.partition, at(record.seconds), record.service, func(window *Window) {
+		window.Counts[record.service]++
+	})
+}
+
+// Границы окна в секундах от windowBase и счетчики: "p0 [0,60) map[api:2]"
+func describeWindows(windows []*Window) []string {
+	var result []string
+	for _, window := range windows {
+		result = append(result, fmt.Sprintf("p%d [%d,%d) %v", window.Partition,
+			int(window.Start.Sub(windowBase)/time.Second), int(window.End.Sub(windowBase)/time.Second), window.Counts))
+	}
+	return result
+}
+
+func TestWindowsCloseAndLate(t *testing.T) {
+	tumbling := WindowSpec{Type: events.WindowTumbling, Size: time.Minute, Advance: time.Minute}
+	hopping := WindowSpec{Type: events.WindowHopping, Size: time.Minute, Advance: 30 * time.Second}
+	session := WindowSpec{Type: events.WindowSession, Gap: 30 * time.Second}
+
+	cases := []struct {
+		name     string
+		spec     WindowSpec
+		lateness time.Duration
+		records  []windowRecord
+		late     []int    // номера опоздавших записей
+		closed   []string // окна, закрытые после всех записей
+	}{
+		{
+			name:    "tumbling: окно закрывается, запись в него опаздывает",
+			spec:    tumbling,
+			records: []windowRecord{{0, 0, "api"}, {0, 10, "api"}, {0, 70, "db"}, {0, 30, "api"}},
+			late:    []int{3},
+			closed:  []string{"p0 [0,60) map[api:2]"},
+		},
+		{
+			name:     "tumbling: допустимое опоздание сдвигает watermark",
+			spec:     tumbling,
+			lateness: 15 * time.Second,
+			records:  []windowRecord{{0, 0, "api"}, {0, 70, "api"}, {0, 50, "db"}, {0, 80, "api"}, {0, 55, "db"}},
+			late:     []int{4},
+			closed:   []string{"p0 [0,60) map[api:1 db:1]"},
+		},
+		{
+			name:    "отстающая партиция сдерживает watermark",
+			spec:    tumbling,
+			records: []windowRecord{{0, 0, "api"}, {1, 10, "db"}, {0, 70, "api"}, {0, 130, "api"}, {1, 5, "db"}},
+		},
+		{
+			name:    "окна партиций с одинаковыми границами закрываются вместе",
+			spec:    tumbling,
+			records: []windowRecord{{0, 0, "api"}, {1, 20, "db"}, {0, 90, "api"}, {1, 80, "db"}, {1, 40, "db"}},
+			late:    []int{4},
+			closed:  []string{"p0 [0,60) map[api:1]", "p1 [0,60) map[db:1]"},
+		},
+		{
+			name:    "hopping: запись попадает во все накрывающие окна",
+			spec:    hopping,
+			records: []windowRecord{{0, 10, "api"}, {0, 40, "api"}, {0, 100, "db"}},
+			closed:  []string{"p0 [-30,30) map[api:1]", "p0 [0,60) map[api:2]", "p0 [30,90) map[api:1]"},
+		},
+		{
+			// Запись 75 попадает только в еще открытое окно [60,120)
+			name:    "hopping: опаздывает запись, все окна которой закрыты",
+			spec:    hopping,
+			records: []windowRecord{{0, 10, "api"}, {0, 100, "api"}, {0, 25, "api"}, {0, 75, "api"}},
+			late:    []int{2},
+			closed:  []string{"p0 [-30,30) map[api:1]", "p0 [0,60) map[api:1]"},
+		},
+		{
+			name:    "session: сессии ведутся по сервисам",
+			spec:    session,
+			records: []windowRecord{{0, 0, "api"}, {0, 10, "db"}, {0, 20, "api"}, {0, 100, "api"}},
+			closed:  []string{"p0 [0,50) map[api:2]", "p0 [10,40) map[db:1]"},
+		},
+		{
+			name:    "session: запись между сессиями объединяет их",
+			spec:    session,
+			records: []windowRecord{{0, 0, "api"}, {0, 50, "api"}, {0, 25, "api"}, {0, 200, "db"}},
+			closed:  []string{"p0 [0,80) map[api:3]"},
+		},
+		{
+			name:    "session: новая сессия за watermark опаздывает",
+			spec:    session,
+			records: []windowRecord{{0, 100, "api"}, {0, 10, "api"}, {0, 90, "api"}},
+			late:    []int{1},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			windows := NewWindows(c.spec, c.lateness, 0)
+
+			var late []int
+			for i, record := range c.records {
+				if !addRecord(windows, record) {
+					late = append(late, i)
+				}
+			}
+			if !reflect.DeepEqual(late, c.late) {
+				t.Errorf("опоздавшие записи %v, ожидали %v", late, c.late)
+			}
+			if closed := describeWindows(windows.CloseExpired()); !reflect.DeepEqual(closed, c.closed) {
+				t.Errorf("закрыты окна %q, ожидали %q", closed, c.closed)
+			}
+		})
+	}
+}
+
+func TestWindowsWatermarkNeverMovesBack(t *testing.T) {
+	windows := NewWindows(WindowSpec{Type: events.WindowTumbling, Size: time.Minute, Advance: time.Minute}, 0, 0)
+	addRecord(windows, windowRecord{0, 100, "api"})
+	if !windows.Watermark().Equal(at(100)) {
+		t.Fatalf("watermark %s", windows.Watermark())
+	}
+
+	// Новая партиция с ранним временем не возвращает watermark назад
+	addRecord(windows, windowRecord{1, 10, "api"})
+	if !windows.Watermark().Equal(at(100)) {
+		t.Errorf("watermark сдвинулся назад: %s", windows.Watermark())
+	}
+}
+
+func TestWindowsIdleTimeout(t *testing.T) {
+	idle := 50 * time.Millisecond
+	windows := NewWindows(WindowSpec{Type: events.WindowTumbling, Size: time.Minute, Advance: time.Minute}, 0, idle)
+	addRecord(windows, windowRecord{0, 0, "api"})
+	addRecord(windows, windowRecord{1, 100, "db"})
+	if closed := windows.CloseExpired(); len(closed) != 0 {
+		t.Fatalf("закрыты окна %q при активной партиции 0", describeWindows(closed))
+	}
+
+	// Партиция 0 простаивает и больше не сдерживает watermark
+	time.Sleep(idle + 10*time.Millisecond)
+	addRecord(windows, windowRecord{1, 110, "db"})
+	want := []string{"p0 [0,60) map[api:1]"}
+	if closed := describeWindows(windows.CloseExpired()); !reflect.DeepEqual(closed, want) {
+		t.Errorf("закрыты окна %q, ожидали %q", closed, want)
+	}
+
+	// Простаивают все партиции — watermark идет вперед со временем простоя
+	before := windows.Watermark()
+	time.Sleep(idle + 10*time.Millisecond)
+	if !windows.Watermark().After(before) {
+		t.Errorf("watermark стоит на месте: %s", windows.Watermark())
+	}
+}
+
+func TestWindowsRestoredPartitionHoldsWatermark(t *testing.T) {
+	spec := WindowSpec{Type: events.WindowTumbling, Size: time.Minute, Advance: time.Minute}
+
+	// Сохраненное время партиции сдерживает watermark до первой записи из нее
+	windows := NewWindows(spec, 0, 0)
+	windows.Restore(1, at(10), nil)
+	addRecord(windows, windowRecord{0, 0, "api"})
+	addRecord(windows, windowRecord{0, 100, "api"})
+	if !windows.Watermark().Equal(at(10)) {
+		t.Errorf("watermark %s, ожидали время партиции 1", windows.Watermark())
+	}
+	if !addRecord(windows, windowRecord{1, 20, "db"}) {
+		t.Error("запись партиции 1 опоздала")
+	}
+
+	// Партиция без сохраненного времени держит watermark на месте
+	windows = NewWindows(spec, 0, 0)
+	windows.Restore(1, time.Time{}, nil)
+	addRecord(windows, windowRecord{0, 0, "api"})
+	addRecord(windows, windowRecord{0, 100, "api"})
+	if closed := windows.CloseExpired(); len(closed) != 0 || !windows.Watermark().IsZero() {
+		t.Fatalf("watermark %s, закрыты окна %q", windows.Watermark(), describeWindows(closed))
+	}
+	if !addRecord(windows, windowRecord{1, 5, "db"}) {
+		t.Error("запись партиции 1 опоздала")
+	}
+	want := []string{"p0 [0,60) map[api:1]", "p1 [0,60) map[db:1]"}
+	addRecord(windows, windowRecord{1, 90, "db"})
+	if closed := describeWindows(windows.CloseExpired()); !reflect.DeepEqual(closed, want) {
+		t.Errorf("закрыты окна %q, ожидали %q", closed, want)
+	}
+}
+
+func TestWindowsUnreportedPartitionIdles(t *testing.T) {
+	idle := 50 * time.Millisecond
+	windows := NewWindows(WindowSpec{Type: events.WindowTumbling, Size: time.Minute, Advance: time.Minute}, 0, idle)
+	windows.Restore(1, time.Time{}, nil)
+	addRecord(windows, windowRecord{0, 0, "api"})
+
+	// Назначенная партиция без записей перестает держать watermark после простоя
+	time.Sleep(idle + 10*time.Millisecond)
+	addRecord(windows, windowRecord{0, 100, "api"})
+	want := []string{"p0 [0,60) map[api:1]"}
+	if closed := describeWindows(windows.CloseExpired()); !reflect.DeepEqual(closed, want) {
+		t.Errorf("закрыты окна %q, ожидали %q", closed, want)
+	}
+}
+
+func TestWindowsRestoreAndDrop(t *testing.T) {
+	windows := NewWindows(WindowSpec{Type: events.WindowTumbling, Size: time.Minute, Advance: time.Minute}, 0, 0)
+	addRecord(windows, windowRecord{0, 30, "stale"})
+
+	// Restore заменяет окна партиции сохраненными
+	saved := newWindow(0, at(0), at(60), "")
+	saved.Counts["api"] = 5
+	windows.Restore(0, at(40), []*Window{saved})
+	if !windows.PartitionTime(0).Equal(at(40)) {
+		t.Errorf("время партиции %s", windows.PartitionTime(0))
+	}
+	if len(windows.ChangedWindows(0)) != 0 {
+		t.Error("восстановленные окна помечены измененными")
+	}
+	addRecord(windows, windowRecord{0, 50, "api"})
+	addRecord(windows, windowRecord{1, 20, "db"})
+
+	// Drop забывает окна и время партиции: она больше не сдерживает watermark
+	windows.Drop(1)
+	addRecord(windows, windowRecord{0, 70, "api"})
+	want := []string{"p0 [0,60) map[api:6]"}
+	if closed := describeWindows(windows.CloseExpired()); !reflect.DeepEqual(closed, want) {
+		t.Errorf("закрыты окна %q, ожидали %q", closed, want)
+	}
+	if windows.Open() != 1 {
+		t.Errorf("открытых окон %d", windows.Open())
+	}
+}
+
+func TestWindowsChangedSinceSave(t *testing.T) {
+	windows := NewWindows(WindowSpec{Type: events.WindowTumbling, Size: time.Minute, Advance: time.Minute}, 0, 0)
+	addRecord(windows, windowRecord{0, 0, "api"})
+	addRecord(windows, windowRecord{0, 70, "api"})
+	addRecord(windows, windowRecord{1, 75, "db"})
+
+	if changed := windows.ChangedWindows(0); len(changed) != 2 {
+		t.Fatalf("изменено окон партиции 0: %d", len(changed))
+	}
+	windows.Saved(0)
+	if changed := windows.ChangedWindows(0); len(changed) != 0 {
+		t.Fatalf("после сохранения изменено окон: %d", len(changed))
+	}
+	// Сохранение одной партиции не трогает другие
+	if changed := windows.ChangedWindows(1); len(changed) != 1 {
+		t.Errorf("изменено окон партиции 1: %d", len(changed))
+	}
+
+	addRecord(windows, windowRecord{0, 80, "api"})
+	want := []string{"p0 [60,120) map[api:2]"}
+	if changed := describeWindows(windows.ChangedWindows(0)); !reflect.DeepEqual(changed, want) {
+		t.Errorf("изменены окна %q, ожидали %q", changed, want)
+	}
+}
+
+func TestWindowsSessionMergeRemoves(t *testing.T) {
+	windows := NewWindows(WindowSpec{Type: events.WindowSession, Gap: 30 * time.Second}, 0, 0)
+	addRecord(windows, windowRecord{0, 0, "api"})
+	addRecord(windows, windowRecord{0, 50, "api"})
+	if removed := windows.Removed(); len(removed) != 0 {
+		t.Fatalf("поглощено окон: %d", len(removed))
+	}
+
+	// Продление и слияние поглощают прежние окна: их нужно удалить из состояния
+	addRecord(windows, windowRecord{0, 25, "api"})
+	want := []string{"p0 [0,30) map[api:1]", "p0 [50,80) map[api:1]"}
+	removed := windows.Removed()
+	sortWindows(removed)
+	if got := describeWindows(removed); !reflect.DeepEqual(got, want) {
+		t.Errorf("поглощены окна %q, ожидали %q", got, want)
+	}
+	if len(windows.Removed()) != 0 {
+		t.Error("Removed вернул окна повторно")
+	}
+	if windows.Open() != 1 {
+		t.Errorf("открытых окон %d", windows.Open())
+	}
+}
+
+func TestMergeWindows(t *testing.T) {
+	first := newWindow(0, at(0), at(60), "")
+	first.Counts["api"] = 2
+	second := newWindow(1, at(0), at(60), "")
+	second.Counts["api"] = 1
+	second.Counts["db"] = 3
+	next := newWindow(1, at(60), at(120), "")
+	next.Counts["db"] = 1
+
+	want := []string{"p0 [0,60) map[api:3 db:3]", "p1 [60,120) map[db:1]"}
+	if got := describeWindows(MergeWindows([]*Window{first, second, next})); !reflect.DeepEqual(got, want) {
+		t.Errorf("объединены окна %q, ожидали %q", got, want)
+	}
+
+	// Session окна разных сервисов с одинаковыми границами не объединяются
+	api := newWindow(0, at(0), at(30), "api")
+	api.Counts["api"] = 1
+	db := newWindow(0, at(0), at(30), "db")
+	db.Counts["db"] = 1
+	if got := MergeWindows([]*Window{api, db}); len(got) != 2 {
+		t.Errorf("session окна объединены: %q", describeWindows(got))
+	}
+}
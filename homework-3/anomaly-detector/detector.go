@@ -0,0 +1,206 @@
+package main
+
+import (
+	"events"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Настройки детектора
+type DetectorConfig struct {
+	Alpha            float64 // вес нового окна в EWMA
+	Threshold        float64 // z-score, при котором открывается алерт
+	ResolveThreshold float64 // z-score, ниже которого окно считается нормальным
+	ResolveWindows   int     // нормальных окон подряд, чтобы закрыть алерт
+	MinSamples       int     // окон до начала проверок (прогрев базовой линии)
+	MinStdDev        float64 // нижняя граница разброса, ошибок в минуту
+	MaxGapWindows    int     // сколько пропущенных окон заполнять нулями
+}
+
+func (c DetectorConfig) Validate() error {
+	if c.Alpha <= 0 || c.Alpha > 1 {
+		return fmt.Errorf("EWMA_ALPHA вне диапазона (0, 1]: %g", c.Alpha)
+	}
+	if c.ResolveThreshold > c.Threshold {
+		return fmt.Errorf("RESOLVE_Z_THRESHOLD (%g) больше Z_THRESHOLD (%g)", c.ResolveThreshold, c.Threshold)
+	}
+	if c.ResolveWindows < 1 {
+		return fmt.Errorf("RESOLVE_WINDOWS должен быть положительным: %d", c.ResolveWindows)
+	}
+	if c.MinStdDev <= 0 {
+		return fmt.Errorf("MIN_STDDEV должен быть положительным: %g", c.MinStdDev)
+	}
+	return nil
+}
+
+// Базовая линия частоты ошибок сервиса и его открытый алерт
+type Baseline struct {
+	Service       string        `json:"service"`
+	Mean          float64       `json:"mean"`     // EWMA ошибок в минуту
+	Variance      float64       `json:"variance"` // EWMA квадрата отклонения
+	Samples       int           `json:"samples"`
+	LastWindowEnd time.Time     `json:"last_window_end"`
+	Alert         *events.Alert `json:"alert,omitempty"` // открытый инцидент
+	Calm          int           `json:"calm,omitempty"`  // нормальных окон подряд во время инцидента
+}
+
+func (b *Baseline) StdDev(floor float64) float64 {
+	return math.Max(math.Sqrt(b.Variance), floor)
+}
+
+// Детектор аномалий частоты ошибок по tumbling окнам error-stats.
+//
+// Для каждого сервиса хранится экспоненциально взвешенное среднее и дисперсия
+// частоты ошибок. Окно с z-score ≥ Threshold открывает алерт; пока он открыт,
+// новые аномальные окна не порождают новых алертов. Алерт закрывается после
+// ResolveWindows окон подряд с z-score < ResolveThreshold.
+type Detector struct {
+	config    DetectorConfig
+	baselines map[string]*Baseline
+}
+
+func NewDetector(config DetectorConfig, restored []*Baseline) *Detector {
+	d := &Detector{config: config, baselines: make(map[string]*Baseline)}
+	for _, baseline := range restored {
+		d.baselines[baseline.Service] = baseline
+	}
+	return d
+}
+
+// Observe учитывает окно статистики целиком (части от экземпляров aggregator'а
+// уже сложены WindowMerger'ом) и возвращает новые записи алертов и
+// изменившиеся базовые линии. Окна, которые уже учтены, пропускаются,
+// поэтому повторное чтение топика ничего не меняет.
+func (d *Detector) Observe(stats *events.ErrorStats) ([]*events.Alert, []*Baseline, error) {
+	start, err := events.ParseTime(stats.WindowStart)
+	if err != nil {
+		return nil, nil, err
+	}
+	end, err := events.ParseTime(stats.WindowEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := end.Sub(start)
+	if size <= 0 {
+		return nil, nil, fmt.Errorf("пустое окно %s → %s", stats.WindowStart, stats.WindowEnd)
+	}
+
+	// Сервисы без ошибок в этом окне тоже получают наблюдение — ноль
+	services := make([]string, 0, len(d.baselines)+len(stats.Services))
+	for service := range d.baselines {
+		services = append(services, service)
+	}
+	for service := range stats.Services {
+		if d.baselines[service] == nil {
+			services = append(services, service)
+		}
+	}
+	sort.Strings(services)
+
+	var alerts []*events.Alert
+	var changed []*Baseline
+	for _, service := range services {
+		baseline := d.baselines[service]
+		if baseline == nil {
+			baseline = &Baseline{Service: service}
+			d.baselines[service] = baseline
+		}
+		if start.Before(baseline.LastWindowEnd) {
+			continue
+		}
+
+		// Aggregator не пишет окна без ошибок — восполняем их нулями
+		if !baseline.LastWindowEnd.IsZero() {
+			missed := int(start.Sub(baseline.LastWindowEnd) / size)
+			gapStart := start.Add(-time.Duration(min(missed, d.config.MaxGapWindows)) * size)
+			for ; gapStart.Before(start); gapStart = gapStart.Add(size) {
+				if alert := d.observe(baseline, 0, gapStart, gapStart.Add(size)); alert != nil {
+					alerts = append(alerts, alert)
+				}
+			}
+		}
+
+		rate := float64(stats.Services[service]) / size.Minutes()
+		if alert := d.observe(baseline, rate, start, end); alert != nil {
+			alerts = append(alerts, alert)
+		}
+		baseline.LastWindowEnd = end
+		changed = append(changed, baseline)
+	}
+	return alerts, changed, nil
+}
+
+// Одно наблюдение: проверка по базовой линии до окна, затем ее обновление
+func (d *Detector) observe(baseline *Baseline, value float64, start, end time.Time) *events.Alert {
+	stddev := baseline.StdDev(d.config.MinStdDev)
+	z := (value - baseline.Mean) / stddev
+
+	var record *events.Alert
+	alert := baseline.Alert
+	switch {
+	case alert == nil && baseline.Samples >= d.config.MinSamples && z >= d.config.Threshold:
+		alert = &events.Alert{
+			AlertID:   fmt.Sprintf("%s/%s", baseline.Service, start.Format(time.RFC3339)),
+			Status:    events.AlertFiring,
+			Service:   baseline.Service,
+			Metric:    events.MetricErrorRate,
+			Threshold: d.config.Threshold,
+			FiredAt:   events.FormatTime(start),
+		}
+		baseline.Alert = alert
+		baseline.Calm = 0
+		record = alert
+
+	case alert != nil:
+		if z < d.config.ResolveThreshold {
+			baseline.Calm++
+		} else {
+			baseline.Calm = 0
+		}
+		if baseline.Calm >= d.config.ResolveWindows {
+			alert.Status = events.AlertResolved
+			alert.ResolvedAt = events.FormatTime(end)
+			baseline.Alert = nil
+			baseline.Calm = 0
+			record = alert
+		}
+	}
+
+	if alert != nil {
+		alert.Value = value
+		alert.Baseline = baseline.Mean
+		alert.StdDev = stddev
+		alert.ZScore = z
+		alert.Windows++
+		alert.WindowStart = events.FormatTime(start)
+		alert.WindowEnd = events.FormatTime(end)
+		if value >= alert.PeakValue {
+			alert.PeakValue = value
+			alert.PeakZScore = z
+		}
+	}
+
+	// Аномальные окна входят в базовую линию, но обрезанными до порога:
+	// короткий всплеск почти не раздувает разброс, а затяжной сдвиг уровня
+	// постепенно становится новой нормой и алерт закрывается
+	if baseline.Samples >= d.config.MinSamples {
+		value = math.Min(value, baseline.Mean+d.config.Threshold*stddev)
+	}
+	if baseline.Samples == 0 {
+		baseline.Mean = value
+	} else {
+		diff := value - baseline.Mean
+		increment := d.config.Alpha * diff
+		baseline.Mean += increment
+		baseline.Variance = (1 - d.config.Alpha) * (baseline.Variance + diff*increment)
+	}
+	baseline.Samples++
+
+	if record == nil {
+		return nil
+	}
+	copy := *record
+	return &copy
+}
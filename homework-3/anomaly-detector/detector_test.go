@@ -0,0 +1,161 @@
+package main
+
+import (
+	"events"
+	"testing"
+	"time"
+)
+
+var detectorBase = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+var testDetectorConfig = DetectorConfig{
+	Alpha:            0.3,
+	Threshold:        3,
+	ResolveThreshold: 1,
+	ResolveWindows:   2,
+	MinSamples:       3,
+	MinStdDev:        1,
+	MaxGapWindows:    5,
+}
+
+// Минутное окно error-stats, начинающееся через minute минут после detectorBase
+func minuteStats(minute int, services map[string]int) *events.ErrorStats {
+	start := detectorBase.Add(time.Duration(minute) * time.Minute)
+	stats := &events.ErrorStats{
+		WindowStart: events.FormatTime(start),
+		WindowEnd:   events.FormatTime(start.Add(time.Minute)),
+		WindowType:  events.WindowTumbling,
+		Services:    services,
+	}
+	for _, count := range services {
+		stats.TotalErrors += count
+	}
+	return stats
+}
+
+func observe(t *testing.T, detector *Detector, minute int, services map[string]int) ([]*events.Alert, []*Baseline) {
+	t.Helper()
+	alerts, changed, err := detector.Observe(minuteStats(minute, services))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return alerts, changed
+}
+
+func TestDetectorAlertLifecycle(t *testing.T) {
+	detector := NewDetector(testDetectorConfig, nil)
+
+	// Прогрев: ровный фон, алертов нет
+	for minute := 0; minute < 5; minute++ {
+		if alerts, _ := observe(t, detector, minute, map[string]int{"api": 2}); len(alerts) != 0 {
+			t.Fatalf("алерт на прогреве: %+v", alerts[0])
+		}
+	}
+
+	// Всплеск открывает алерт
+	alerts, _ := observe(t, detector, 5, map[string]int{"api": 20})
+	if len(alerts) != 1 {
+		t.Fatalf("алертов %d, ожидали 1", len(alerts))
+	}
+	fired := alerts[0]
+	if fired.Status != events.AlertFiring || fired.Service != "api" || fired.Metric != events.MetricErrorRate ||
+		fired.AlertID != "api/"+detectorBase.Add(5*time.Minute).Format(time.RFC3339) ||
+		fired.FiredAt != events.FormatTime(detectorBase.Add(5*time.Minute)) || fired.Value != 20 || fired.Windows != 1 {
+		t.Errorf("открытый алерт %+v", fired)
+	}
+
+	// Пока алерт открыт, аномальные окна новых записей не дают
+	if alerts, _ := observe(t, detector, 6, map[string]int{"api": 30}); len(alerts) != 0 {
+		t.Fatalf("повторный алерт: %+v", alerts[0])
+	}
+
+	// Нормальные окна подряд закрывают инцидент одной записью
+	var resolved []*events.Alert
+	for minute := 7; minute < 15; minute++ {
+		alerts, _ := observe(t, detector, minute, map[string]int{"api": 2})
+		resolved = append(resolved, alerts...)
+	}
+	if len(resolved) != 1 {
+		t.Fatalf("записей о закрытии %d, ожидали 1", len(resolved))
+	}
+	alert := resolved[0]
+	if alert.Status != events.AlertResolved || alert.AlertID != fired.AlertID || alert.ResolvedAt == "" ||
+		alert.PeakValue != 30 || alert.Windows < 2+testDetectorConfig.ResolveWindows {
+		t.Errorf("закрытый алерт %+v", alert)
+	}
+	if baseline := detector.baselines["api"]; baseline.Alert != nil || baseline.Calm != 0 {
+		t.Errorf("базовая линия после закрытия %+v", baseline)
+	}
+
+	// Закрытая запись — копия: следующее окно ее не меняет
+	windows := alert.Windows
+	observe(t, detector, 15, map[string]int{"api": 2})
+	if alert.Windows != windows {
+		t.Error("запись алерта изменилась после отправки")
+	}
+}
+
+func TestDetectorCalmResetsOnAnomaly(t *testing.T) {
+	config := testDetectorConfig
+	config.ResolveWindows = 3
+	detector := NewDetector(config, nil)
+	for minute := 0; minute < 5; minute++ {
+		observe(t, detector, minute, map[string]int{"api": 2})
+	}
+	observe(t, detector, 5, map[string]int{"api": 20})
+
+	// Аномальное окно между нормальными сбрасывает счет спокойных окон
+	observe(t, detector, 6, map[string]int{"api": 2})
+	observe(t, detector, 7, map[string]int{"api": 2})
+	observe(t, detector, 8, map[string]int{"api": 40})
+	if baseline := detector.baselines["api"]; baseline.Alert == nil || baseline.Calm != 0 {
+		t.Fatalf("базовая линия %+v", baseline)
+	}
+}
+
+func TestDetectorSkipsObservedWindows(t *testing.T) {
+	detector := NewDetector(testDetectorConfig, nil)
+	observe(t, detector, 0, map[string]int{"api": 2})
+	observe(t, detector, 1, map[string]int{"api": 2})
+
+	// Повторное чтение топика ничего не меняет
+	alerts, changed := observe(t, detector, 0, map[string]int{"api": 50})
+	if len(alerts) != 0 || len(changed) != 0 || detector.baselines["api"].Samples != 2 {
+		t.Errorf("повтор окна учтен: алертов %d, изменено %d, %+v", len(alerts), len(changed), detector.baselines["api"])
+	}
+}
+
+func TestDetectorFillsGaps(t *testing.T) {
+	detector := NewDetector(testDetectorConfig, nil)
+	observe(t, detector, 0, map[string]int{"api": 4})
+
+	// Три пропущенных окна без ошибок учитываются нулями
+	_, changed := observe(t, detector, 4, map[string]int{"api": 4})
+	if len(changed) != 1 || changed[0].Samples != 5 {
+		t.Fatalf("изменено %d, наблюдений %d, ожидали 5", len(changed), changed[0].Samples)
+	}
+	if mean := changed[0].Mean; mean >= 4 {
+		t.Errorf("нули не снизили среднее: %g", mean)
+	}
+
+	// Длинный пропуск заполняется не больше чем MaxGapWindows окнами
+	_, changed = observe(t, detector, 100, map[string]int{"api": 4})
+	if samples := changed[0].Samples; samples != 5+testDetectorConfig.MaxGapWindows+1 {
+		t.Errorf("наблюдений %d", samples)
+	}
+
+	// Сервис без ошибок в окне тоже получает наблюдение
+	_, changed = observe(t, detector, 101, map[string]int{"db": 1})
+	if len(changed) != 2 || detector.baselines["api"].Samples != 5+testDetectorConfig.MaxGapWindows+2 {
+		t.Errorf("изменено %d, наблюдений api %d", len(changed), detector.baselines["api"].Samples)
+	}
+}
+
+func TestDetectorRejectsEmptyWindow(t *testing.T) {
+	detector := NewDetector(testDetectorConfig, nil)
+	stats := minuteStats(0, map[string]int{"api": 1})
+	stats.WindowEnd = stats.WindowStart
+	if _, _, err := detector.Observe(stats); err == nil {
+		t.Error("пустое окно принято")
+	}
+}
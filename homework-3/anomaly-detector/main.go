@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"dlq"
+	"events"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func main() {
+	// Получаем настройки
+	servers := getEnvOrDefault("KAFKA_BOOTSTRAP_SERVERS", "localhost:19092")
+	inputTopic := getEnvOrDefault("INPUT_TOPIC", "error-stats")
+	outputTopic := getEnvOrDefault("OUTPUT_TOPIC", "alerts")
+	consumerGroup := getEnvOrDefault("CONSUMER_GROUP", "anomaly-detector")
+	stateTopic := getEnvOrDefault("STATE_TOPIC", consumerGroup+"-state")
+
+	config := DetectorConfig{
+		Alpha:            getEnvFloatOrDefault("EWMA_ALPHA", 0.1),
+		Threshold:        getEnvFloatOrDefault("Z_THRESHOLD", 3),
+		ResolveThreshold: getEnvFloatOrDefault("RESOLVE_Z_THRESHOLD", 1),
+		ResolveWindows:   getEnvIntOrDefault("RESOLVE_WINDOWS", 3),
+		MinSamples:       getEnvIntOrDefault("MIN_SAMPLES", 10),
+		MinStdDev:        getEnvFloatOrDefault("MIN_STDDEV", 1),
+		MaxGapWindows:    getEnvIntOrDefault("MAX_GAP_WINDOWS", 60),
+	}
+	// Сколько ждать частей окна от других экземпляров aggregator'а
+	mergeSeconds := getEnvIntOrDefault("WINDOW_MERGE_SECONDS", 30)
+	if err := config.Validate(); err != nil {
+		log.Fatalf("❌ Ошибка настройки детектора: %v", err)
+	}
+
+	log.Printf("🚨 Anomaly Detector запущен")
+	log.Printf("📥 Читаем из: %s", inputTopic)
+	log.Printf("📤 Записываем алерты в: %s", outputTopic)
+	log.Printf("📐 EWMA α=%.2f, z ≥ %.1f открывает алерт, %d окон с z < %.1f закрывают, прогрев %d окон",
+		config.Alpha, config.Threshold, config.ResolveWindows, config.ResolveThreshold, config.MinSamples)
+	log.Printf("💾 Состояние: %s", stateTopic)
+	log.Printf("🧩 Части окна ждем %d сек", mergeSeconds)
+
+	brokers := strings.Split(servers, ",")
+
+	if err := EnsureStateTopic(brokers, stateTopic); err != nil {
+		log.Fatalf("❌ Ошибка подготовки топика состояния: %v", err)
+	}
+	store := NewStateStore(brokers, stateTopic)
+	defer store.Close()
+
+	restored, err := store.Load()
+	if err != nil {
+		log.Fatalf("❌ Ошибка загрузки состояния: %v", err)
+	}
+	detector := NewDetector(config, restored)
+	merger := NewWindowMerger(time.Duration(mergeSeconds) * time.Second)
+	log.Printf("♻️  Восстановлено базовых линий: %d", len(restored))
+
+	// Создаем reader для чтения статистики
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  brokers,
+		Topic:    inputTopic,
+		GroupID:  consumerGroup,
+		MinBytes: 10e3,
+		MaxBytes: 10e6,
+	})
+	defer reader.Close()
+
+	// Ключ алерта — alert_id, поэтому firing и resolved одного инцидента
+	// попадают в одну партицию по порядку
+	writer := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      brokers,
+		Topic:        outputTopic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: int(kafka.RequireAll),
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	defer writer.Close()
+
+	// Нечитаемые записи уходят в error-stats.dlq
+	deadLetters := dlq.NewWriter(brokers, "anomaly-detector")
+	defer deadLetters.Close()
+
+	log.Printf("✅ Подключение к Kafka установлено")
+
+	// Записи читаются в отдельной горутине, основной цикл сливает части окон
+	messages := make(chan kafka.Message, 100)
+	go func() {
+		for {
+			message, err := reader.FetchMessage(context.Background())
+			if err != nil {
+				log.Printf("❌ Ошибка чтения: %v", err)
+				continue
+			}
+			messages <- message
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// Offset коммитится только после записи алертов и состояния по окну
+	for {
+		select {
+		case message := <-messages:
+			if stats := decodeStats(deadLetters, message); stats != nil {
+				merger.Add(message, stats, time.Now())
+			} else {
+				merger.Skip(message)
+			}
+
+		case now := <-ticker.C:
+			for _, window := range merger.Due(now) {
+				processWindow(detector, writer, store, deadLetters, window)
+				merger.Processed(window)
+			}
+		}
+
+		for _, message := range merger.Commits() {
+			retry("коммита offset", func() error {
+				return reader.CommitMessages(context.Background(), message)
+			})
+		}
+	}
+}
+
+// Разбирает часть окна; nil — запись ушла в DLQ или окно не tumbling
+func decodeStats(deadLetters *dlq.Writer, message kafka.Message) *events.ErrorStats {
+	var stats events.ErrorStats
+	if err := events.Decode(message.Value, &stats); err != nil {
+		log.Printf("❌ Ошибка JSON: %v", err)
+		sendDeadLetter(deadLetters, message, err)
+		return nil
+	}
+
+	// Hopping окна перекрываются, session окна разной длины — базовая линия
+	// строится только по tumbling окнам
+	if stats.WindowType != "" && stats.WindowType != events.WindowTumbling {
+		return nil
+	}
+
+	for _, value := range []string{stats.WindowStart, stats.WindowEnd} {
+		if _, err := events.ParseTime(value); err != nil {
+			log.Printf("❌ Ошибка окна %s: %v", stats.WindowStart, err)
+			sendDeadLetter(deadLetters, message, err)
+			return nil
+		}
+	}
+	return &stats
+}
+
+func processWindow(detector *Detector, writer *kafka.Writer, store *StateStore,
+	deadLetters *dlq.Writer, window *MergedWindow) {
+	stats := window.Stats
+	alerts, changed, err := detector.Observe(stats)
+	if err != nil {
+		log.Printf("❌ Ошибка окна %s: %v", stats.WindowStart, err)
+		for _, message := range window.messages {
+			sendDeadLetter(deadLetters, message, err)
+		}
+		return
+	}
+	if len(changed) == 0 {
+		log.Printf("⏭️  Окно %s уже учтено, частей: %d", stats.WindowStart, window.Parts)
+		return
+	}
+
+	// Сначала алерты, затем состояние: после падения между ними алерт будет
+	// записан повторно с тем же alert_id
+	for _, alert := range alerts {
+		retry("записи алерта", func() error { return sendAlert(writer, alert) })
+	}
+	retry("сохранения состояния", func() error { return store.Save(changed) })
+
+	for _, baseline := range changed {
+		log.Printf("📈 %s: окно %s (частей: %d), норма %.2f ± %.2f ошибок/мин (окон: %d)",
+			baseline.Service, stats.WindowStart, window.Parts, baseline.Mean, math.Sqrt(baseline.Variance), baseline.Samples)
+	}
+}
+
+// Offset коммитится после возврата, поэтому запись должна дойти до DLQ
+func sendDeadLetter(deadLetters *dlq.Writer, message kafka.Message, cause error) {
+	if err := deadLetters.Send(context.Background(), message, cause); err != nil {
+		log.Fatalf("❌ Запись не отправлена в DLQ: %v", err)
+	}
+}
+
+func sendAlert(writer *kafka.Writer, alert *events.Alert) error {
+	alert.GeneratedAt = events.FormatTime(time.Now())
+	alertJSON, err := events.Encode(alert)
+	if err != nil {
+		return err
+	}
+
+	err = writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(alert.AlertID),
+		Value: alertJSON,
+	})
+	if err != nil {
+		return err
+	}
+
+	if alert.Status == events.AlertFiring {
+		log.Printf("🔥 АЛЕРТ %s: %.1f ошибок/мин при норме %.1f ± %.1f (z=%.1f)",
+			alert.AlertID, alert.Value, alert.Baseline, alert.StdDev, alert.ZScore)
+	} else {
+		log.Printf("✅ ЗАКРЫТ %s: окон %d, пик %.1f ошибок/мин (z=%.1f)",
+			alert.AlertID, alert.Windows, alert.PeakValue, alert.PeakZScore)
+	}
+	return nil
+}
+
+// Повторяет операцию до успеха: пропускать алерты и состояние нельзя
+func retry(what string, operation func() error) {
+	backoff := time.Second
+	for {
+		err := operation()
+		if err == nil {
+			return
+		}
+		log.Printf("❌ Ошибка %s: %v, повтор через %s", what, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvFloatOrDefault(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"events"
+	"sort"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Окно error-stats, собранное из частичных записей
+type MergedWindow struct {
+	Stats    *events.ErrorStats
+	Parts    int
+	first    time.Time       // когда пришла первая часть
+	messages []kafka.Message // записи частей, offset'ы которых коммитятся после окна
+}
+
+// Слияние частичных записей error-stats.
+//
+// Каждый экземпляр aggregator'а считает окно только по своим партициям
+// error-logs и пишет свою запись, поэтому одно окно приходит несколькими
+// частями с одинаковыми границами. Части копятся wait с прихода первой, затем
+// их счетчики складываются, и детектор видит окно один раз целиком.
+//
+// Offset записи можно коммитить, только когда ее окно учтено, а более ранние
+// записи той же партиции тоже обработаны; Commits возвращает такие offset'ы.
+type WindowMerger struct {
+	wait    time.Duration
+	windows map[string]*MergedWindow
+
+	buffered map[int]map[int64]bool // партиция → offset'ы записей в незакрытых окнах
+	done     map[int]kafka.Message  // партиция → обработанная запись с наибольшим offset'ом
+}
+
+func NewWindowMerger(wait time.Duration) *WindowMerger {
+	return &WindowMerger{
+		wait:     wait,
+		windows:  make(map[string]*MergedWindow),
+		buffered: make(map[int]map[int64]bool),
+		done:     make(map[int]kafka.Message),
+	}
+}
+
+// Add добавляет часть окна
+func (m *WindowMerger) Add(message kafka.Message, stats *events.ErrorStats, now time.Time) {
+	key := stats.WindowStart + "/" + stats.WindowEnd
+	window := m.windows[key]
+	if window == nil {
+		merged := *stats
+		merged.Services = make(map[string]int, len(stats.Services))
+		merged.TotalErrors = 0
+		window = &MergedWindow{Stats: &merged, first: now}
+		m.windows[key] = window
+	}
+	for service, count := range stats.Services {
+		window.Stats.Services[service] += count
+	}
+	window.Stats.TotalErrors += stats.TotalErrors
+	window.Parts++
+	window.messages = append(window.messages, message)
+
+	if m.buffered[message.Partition] == nil {
+		m.buffered[message.Partition] = make(map[int64]bool)
+	}
+	m.buffered[message.Partition][message.Offset] = true
+}
+
+// Due забирает окна, которые ждали частей не меньше wait, по порядку начала
+func (m *WindowMerger) Due(now time.Time) []*MergedWindow {
+	var due []*MergedWindow
+	for key, window := range m.windows {
+		if now.Sub(window.first) >= m.wait {
+			due = append(due, window)
+			delete(m.windows, key)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Stats.WindowStart < due[j].Stats.WindowStart })
+	return due
+}
+
+// Processed отмечает окно учтенным
+func (m *WindowMerger) Processed(window *MergedWindow) {
+	for _, message := range window.messages {
+		delete(m.buffered[message.Partition], message.Offset)
+		m.Skip(message)
+	}
+}
+
+// Skip отмечает обработанной запись, которая в окна не попала
+// (нечитаемая или не tumbling)
+func (m *WindowMerger) Skip(message kafka.Message) {
+	if last, ok := m.done[message.Partition]; !ok || message.Offset > last.Offset {
+		m.done[message.Partition] = message
+	}
+}
+
+// Commits возвращает по партициям последнюю запись, offset которой можно
+// закоммитить: она обработана, и раньше нее нет записей в незакрытых окнах
+func (m *WindowMerger) Commits() []kafka.Message {
+	var commits []kafka.Message
+	for partition, last := range m.done {
+		held := false
+		for offset := range m.buffered[partition] {
+			if offset < last.Offset {
+				held = true
+				break
+			}
+		}
+		if held {
+			continue
+		}
+		commits = append(commits, last)
+		delete(m.done, partition)
+	}
+	return commits
+}
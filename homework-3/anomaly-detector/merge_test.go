@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func statsMessage(partition int, offset int64) kafka.Message {
+	return kafka.Message{Topic: "error-stats", Partition: partition, Offset: offset}
+}
+
+// Партиция → offset записей, которые можно закоммитить
+func commitOffsets(merger *WindowMerger) map[int]int64 {
+	offsets := make(map[int]int64)
+	for _, message := range merger.Commits() {
+		offsets[message.Partition] = message.Offset
+	}
+	return offsets
+}
+
+func TestWindowMergerMergesParts(t *testing.T) {
+	merger := NewWindowMerger(time.Second)
+	now := detectorBase
+
+	merger.Add(statsMessage(0, 1), minuteStats(1, map[string]int{"api": 2, "db": 1}), now)
+	merger.Add(statsMessage(1, 1), minuteStats(1, map[string]int{"api": 3}), now.Add(500*time.Millisecond))
+	merger.Add(statsMessage(0, 2), minuteStats(0, map[string]int{"db": 4}), now.Add(100*time.Millisecond))
+
+	// Части копятся wait с прихода первой
+	if due := merger.Due(now.Add(900 * time.Millisecond)); len(due) != 0 {
+		t.Fatalf("окна отданы до ожидания: %d", len(due))
+	}
+	due := merger.Due(now.Add(1100 * time.Millisecond))
+	if len(due) != 2 {
+		t.Fatalf("окон %d, ожидали 2", len(due))
+	}
+
+	// Окна по порядку начала, счетчики частей сложены
+	first, second := due[0], due[1]
+	if first.Stats.WindowStart != minuteStats(0, nil).WindowStart || first.Parts != 1 {
+		t.Errorf("первое окно %+v", first)
+	}
+	if second.Parts != 2 || second.Stats.TotalErrors != 6 ||
+		!reflect.DeepEqual(second.Stats.Services, map[string]int{"api": 5, "db": 1}) {
+		t.Errorf("второе окно: частей %d, ошибок %d, %v", second.Parts, second.Stats.TotalErrors, second.Stats.Services)
+	}
+	if due := merger.Due(now.Add(time.Hour)); len(due) != 0 {
+		t.Errorf("окна отданы повторно: %d", len(due))
+	}
+}
+
+func TestWindowMergerDoesNotChangeParts(t *testing.T) {
+	merger := NewWindowMerger(0)
+	part := minuteStats(0, map[string]int{"api": 2})
+	merger.Add(statsMessage(0, 1), part, detectorBase)
+	merger.Add(statsMessage(1, 1), minuteStats(0, map[string]int{"api": 3}), detectorBase)
+	merger.Due(detectorBase)
+
+	if part.Services["api"] != 2 || part.TotalErrors != 2 {
+		t.Errorf("часть изменена при слиянии: %+v", part)
+	}
+}
+
+func TestWindowMergerHoldsCommits(t *testing.T) {
+	merger := NewWindowMerger(time.Second)
+	now := detectorBase
+
+	// Партиция 0: offset 1 в окне A, offset 2 в окне B, offset 3 не tumbling
+	merger.Add(statsMessage(0, 1), minuteStats(0, map[string]int{"api": 1}), now)
+	merger.Add(statsMessage(0, 2), minuteStats(1, map[string]int{"api": 1}), now.Add(2*time.Second))
+	merger.Skip(statsMessage(0, 3))
+	// Партиция 1 от окон партиции 0 не зависит
+	merger.Skip(statsMessage(1, 7))
+
+	if got, want := commitOffsets(merger), map[int]int64{1: 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("коммиты %v, ожидали %v", got, want)
+	}
+
+	// Окно A учтено, но offset 2 еще в незакрытом окне B
+	due := merger.Due(now.Add(time.Second))
+	if len(due) != 1 {
+		t.Fatalf("окон %d, ожидали 1", len(due))
+	}
+	merger.Processed(due[0])
+	if got := commitOffsets(merger); len(got) != 0 {
+		t.Fatalf("offset закоммичен раньше окна B: %v", got)
+	}
+
+	// Окно B учтено — коммитится последняя обработанная запись партиции
+	due = merger.Due(now.Add(3 * time.Second))
+	merger.Processed(due[0])
+	if got, want := commitOffsets(merger), map[int]int64{0: 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("коммиты %v, ожидали %v", got, want)
+	}
+	if got := commitOffsets(merger); len(got) != 0 {
+		t.Errorf("offset'ы отданы повторно: %v", got)
+	}
+}
+
+func TestWindowMergerCommitsLaterWindowFirst(t *testing.T) {
+	merger := NewWindowMerger(time.Second)
+	now := detectorBase
+
+	// Окно с большим offset'ом закрывается раньше: запись с меньшим
+	// offset'ом в незакрытом окне держит коммит
+	merger.Add(statsMessage(0, 5), minuteStats(3, map[string]int{"api": 1}), now.Add(time.Second))
+	merger.Add(statsMessage(0, 8), minuteStats(2, map[string]int{"api": 1}), now)
+	due := merger.Due(now.Add(time.Second))
+	if len(due) != 1 {
+		t.Fatalf("окон %d, ожидали 1", len(due))
+	}
+	merger.Processed(due[0])
+	if got := commitOffsets(merger); len(got) != 0 {
+		t.Fatalf("offset 8 закоммичен при незакрытом offset 5: %v", got)
+	}
+
+	for _, window := range merger.Due(now.Add(2 * time.Second)) {
+		merger.Processed(window)
+	}
+	if got, want := commitOffsets(merger), map[int]int64{0: 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("коммиты %v, ожидали %v", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"topics"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Хранилище базовых линий: compacted топик, ключ — сервис, значение — Baseline.
+// Детектор пропускает уже учтенные окна, поэтому offset входного топика в
+// состоянии не нужен: после падения окна просто перечитываются.
+type StateStore struct {
+	writer  *kafka.Writer
+	brokers []string
+	topic   string
+}
+
+func NewStateStore(brokers []string, topic string) *StateStore {
+	writer := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      brokers,
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: int(kafka.RequireAll),
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	return &StateStore{writer: writer, brokers: brokers, topic: topic}
+}
+
+func (s *StateStore) Close() {
+	s.writer.Close()
+}
+
+// Save синхронно пишет изменившиеся базовые линии
+func (s *StateStore) Save(baselines []*Baseline) error {
+	messages := make([]kafka.Message, 0, len(baselines))
+	for _, baseline := range baselines {
+		value, err := json.Marshal(baseline)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, kafka.Message{Key: []byte(baseline.Service), Value: value})
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return s.writer.WriteMessages(context.Background(), messages...)
+}
+
+// Load перечитывает топик состояния целиком
+func (s *StateStore) Load() ([]*Baseline, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), topics.Timeout)
+	defer cancel()
+	partitions, err := topics.Partitions(ctx, topics.NewClient(s.brokers), s.topic)
+	if err != nil {
+		return nil, err
+	}
+
+	baselines := make(map[string]*Baseline)
+	for _, partition := range partitions {
+		if err := s.loadPartition(partition.ID, baselines); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []*Baseline
+	for _, baseline := range baselines {
+		result = append(result, baseline)
+	}
+	return result, nil
+}
+
+func (s *StateStore) loadPartition(partition int, baselines map[string]*Baseline) error {
+	conn, err := kafka.DialLeader(context.Background(), "tcp", s.brokers[0], s.topic, partition)
+	if err != nil {
+		return err
+	}
+	first, last, err := conn.ReadOffsets()
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   s.brokers,
+		Topic:     s.topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  10e6,
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(first); err != nil {
+		return err
+	}
+
+	for offset := first; offset < last; {
+		message, err := reader.ReadMessage(context.Background())
+		if err != nil {
+			return err
+		}
+		offset = message.Offset + 1
+
+		if message.Value == nil {
+			delete(baselines, string(message.Key))
+			continue
+		}
+		var baseline Baseline
+		if err := json.Unmarshal(message.Value, &baseline); err != nil {
+			return err
+		}
+		baselines[baseline.Service] = &baseline
+	}
+	return nil
+}
+
+// EnsureStateTopic создает compacted топик состояния, если его еще нет, а у
+// существующего включает компакцию. Наличие топика проверяется запросом
+// метаданных без автосоздания: иначе брокер с auto.create.topics.enable
+// создал бы его с настройками по умолчанию, без компакции.
+func EnsureStateTopic(brokers []string, topic string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), topics.Timeout)
+	defer cancel()
+	client := topics.NewClient(brokers)
+
+	partitions, err := topics.Partitions(ctx, client, topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		log.Printf("🆕 Создаем топик состояния %s", topic)
+		err := topics.Create(ctx, client, kafka.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     1,
+			ReplicationFactor: -1,
+			ConfigEntries:     topics.Compacted(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	altered, err := topics.EnsureCompacted(ctx, client, topic)
+	if altered {
+		log.Printf("🔧 Включена компакция для топика %s", topic)
+	}
+	return err
+}
@@ -2,9 +2,22 @@ module enriched-consumer
 
 go 1.23.3
 
-require github.com/segmentio/kafka-go v0.4.47
+require (
+	dlq v0.0.0
+	events v0.0.0
+	github.com/segmentio/kafka-go v0.4.47
+	topics v0.0.0
+)
+
+require github.com/google/uuid v1.6.0 // indirect
 
 require (
 	github.com/klauspost/compress v1.15.9 // indirect
 	github.com/pierrec/lz4/v4 v4.1.15 // indirect
 )
+
+replace (
+	dlq => ../../dlq
+	events => ../../events
+	topics => ../../topics
+)
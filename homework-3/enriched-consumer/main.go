@@ -2,7 +2,8 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"dlq"
+	"events"
 	"fmt"
 	"log"
 	"os"
@@ -11,33 +12,6 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-// Структура ERROR лога
-type ErrorLog struct {
-	Timestamp   string `json:"timestamp"`
-	Service     string `json:"service"`
-	Error       string `json:"error"`
-	ProcessedAt string `json:"processed_at"`
-}
-
-// Структура метрик сервиса
-type ServiceMetrics struct {
-	Timestamp    string  `json:"timestamp"`
-	Service      string  `json:"service"`
-	CPUUsage     float64 `json:"cpu_usage"`
-	MemoryUsage  float64 `json:"memory_usage"`
-	LatencyMs    int     `json:"latency_ms"`
-	RequestCount int     `json:"request_count"`
-	GeneratedAt  string  `json:"generated_at"`
-}
-
-// Обогащенная структура (из join-processor)
-type EnrichedError struct {
-	ErrorLog
-	Metrics    *ServiceMetrics `json:"metrics,omitempty"`
-	JoinedAt   string          `json:"joined_at"`
-	MetricsAge string          `json:"metrics_age,omitempty"`
-}
-
 func main() {
 	// Получаем настройки
 	servers := getEnvOrDefault("KAFKA_BOOTSTRAP_SERVERS", "localhost:19092")
@@ -59,31 +33,47 @@ func main() {
 	})
 	defer reader.Close()
 
+	// Нечитаемые сообщения уходят в <топик>.dlq
+	deadLetters := dlq.NewWriter(brokers, "enriched-consumer")
+	defer deadLetters.Close()
+
 	log.Printf("✅ Подключение к Kafka установлено")
 	log.Printf("\n🔍 Ожидаем обогащенные ошибки...\n")
 
-	// Бесконечный цикл чтения обогащенных ошибок
+	// Бесконечный цикл чтения обогащенных ошибок. Offset коммитится после
+	// обработки: нечитаемая запись сначала должна попасть в DLQ
 	for {
-		message, err := reader.ReadMessage(context.Background())
+		message, err := reader.FetchMessage(context.Background())
 		if err != nil {
 			log.Printf("❌ Ошибка чтения: %v", err)
 			continue
 		}
 
 		// Парсим обогащенную ошибку
-		var enriched EnrichedError
-		err = json.Unmarshal(message.Value, &enriched)
+		var enriched events.EnrichedError
+		err = events.Decode(message.Value, &enriched)
 		if err != nil {
 			log.Printf("❌ Ошибка JSON: %v", err)
+			if err := deadLetters.Send(context.Background(), message, err); err != nil {
+				log.Fatalf("❌ Запись не отправлена в DLQ: %v", err)
+			}
+			commit(reader, message)
 			continue
 		}
 
 		// Красиво отображаем обогащенную ошибку
 		displayEnrichedError(&enriched)
+		commit(reader, message)
 	}
 }
 
-func displayEnrichedError(enriched *EnrichedError) {
+func commit(reader *kafka.Reader, message kafka.Message) {
+	if err := reader.CommitMessages(context.Background(), message); err != nil {
+		log.Printf("❌ Ошибка коммита offset: %v", err)
+	}
+}
+
+func displayEnrichedError(enriched *events.EnrichedError) {
 	fmt.Printf("\n" + strings.Repeat("=", 70) + "\n")
 
 	// Определяем цвет и иконку для сервиса
@@ -132,7 +122,7 @@ func displayEnrichedError(enriched *EnrichedError) {
 		fmt.Printf("   📅 Время метрик: %s", enriched.Metrics.Timestamp)
 
 		if enriched.MetricsAge != "" {
-			fmt.Printf(" (возраст: %s)", enriched.MetricsAge)
+			fmt.Printf(" (сдвиг от ошибки: %s)", enriched.MetricsAge)
 		}
 		fmt.Printf("\n")
 
@@ -143,7 +133,23 @@ func displayEnrichedError(enriched *EnrichedError) {
 
 	} else {
 		fmt.Printf(strings.Repeat("-", 70) + "\n")
-		fmt.Printf("❌ Метрики недоступны - JOIN не выполнен\n")
+		if enriched.JoinStatus == events.JoinNoMetrics {
+			fmt.Printf("❌ Нет метрик в окне ±%s от времени ошибки\n", enriched.JoinWindow)
+		} else {
+			fmt.Printf("❌ Метрики недоступны - JOIN не выполнен\n")
+		}
+	}
+
+	if enriched.Owner != nil {
+		fmt.Printf(strings.Repeat("-", 70) + "\n")
+		fmt.Printf("👥 ВЛАДЕЛЕЦ:")
+		if enriched.Owner.Stale {
+			fmt.Printf(" \033[93m(справочник недоступен, данные могли устареть)\033[0m")
+		}
+		fmt.Printf("\n")
+		fmt.Printf("   🏷️  Команда:   %s (%s)\n", enriched.Owner.Team, enriched.Owner.Tier)
+		fmt.Printf("   📟 Дежурный:  %s\n", enriched.Owner.OnCall)
+		fmt.Printf("   📖 Runbook:   %s\n", enriched.Owner.RunbookURL)
 	}
 
 	fmt.Printf(strings.Repeat("=", 70) + "\n\n")
@@ -184,7 +190,7 @@ func getColorByLatency(latency int) string {
 	return "\033[92m" // Green
 }
 
-func analyzeErrorContext(enriched *EnrichedError) {
+func analyzeErrorContext(enriched *events.EnrichedError) {
 	if enriched.Metrics == nil {
 		return
 	}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"events"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Образец метрик с разобранным временем
+type metricsSample struct {
+	time    time.Time
+	metrics *events.ServiceMetrics
+}
+
+// Буфер метрик для join операций: образцы по сервисам, отсортированные по времени.
+// Образцы старше retention относительно самого свежего образца вытесняются.
+type MetricsCache struct {
+	mu        sync.RWMutex
+	samples   map[string][]metricsSample // ключ: service
+	retention time.Duration
+	latest    time.Time // время самого свежего образца
+}
+
+func NewMetricsCache(retention time.Duration) *MetricsCache {
+	return &MetricsCache{
+		samples:   make(map[string][]metricsSample),
+		retention: retention,
+	}
+}
+
+// Add добавляет образец метрик в буфер сервиса.
+// Образец с тем же временем заменяет старый (повторное чтение топика).
+func (mc *MetricsCache) Add(metrics *events.ServiceMetrics) error {
+	sampleTime, err := events.ParseTime(metrics.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	samples := mc.samples[metrics.Service]
+	i := sort.Search(len(samples), func(i int) bool {
+		return samples[i].time.After(sampleTime)
+	})
+	if i > 0 && samples[i-1].time.Equal(sampleTime) {
+		samples[i-1].metrics = metrics
+		return nil
+	}
+	samples = append(samples, metricsSample{})
+	copy(samples[i+1:], samples[i:])
+	samples[i] = metricsSample{time: sampleTime, metrics: metrics}
+	mc.samples[metrics.Service] = samples
+
+	if sampleTime.After(mc.latest) {
+		mc.latest = sampleTime
+	}
+	mc.evict()
+
+	return nil
+}
+
+// Closest возвращает образец, ближайший ко времени ошибки в пределах ±window,
+// и сдвиг образца относительно этого времени
+func (mc *MetricsCache) Closest(service string, at time.Time, window time.Duration) (*events.ServiceMetrics, time.Duration, bool) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	samples := mc.samples[service]
+	i := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].time.Before(at)
+	})
+
+	var best *metricsSample
+	var bestDiff time.Duration
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(samples) {
+			continue
+		}
+		diff := samples[j].time.Sub(at)
+		if abs(diff) > window {
+			continue
+		}
+		if best == nil || abs(diff) < abs(bestDiff) {
+			best = &samples[j]
+			bestDiff = diff
+		}
+	}
+
+	if best == nil {
+		return nil, 0, false
+	}
+	return best.metrics, bestDiff, true
+}
+
+// Samples возвращает копию всех образцов буфера (для снапшота)
+func (mc *MetricsCache) Samples() []*events.ServiceMetrics {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	var result []*events.ServiceMetrics
+	for _, samples := range mc.samples {
+		for _, sample := range samples {
+			result = append(result, sample.metrics)
+		}
+	}
+	return result
+}
+
+// Size возвращает количество образцов в буфере
+func (mc *MetricsCache) Size() int {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	size := 0
+	for _, samples := range mc.samples {
+		size += len(samples)
+	}
+	return size
+}
+
+// Удаляет образцы старше retention относительно самого свежего образца
+func (mc *MetricsCache) evict() {
+	cutoff := mc.latest.Add(-mc.retention)
+	for service, samples := range mc.samples {
+		i := sort.Search(len(samples), func(i int) bool {
+			return !samples[i].time.Before(cutoff)
+		})
+		if i == 0 {
+			continue
+		}
+		if i == len(samples) {
+			delete(mc.samples, service)
+			continue
+		}
+		mc.samples[service] = append([]metricsSample(nil), samples[i:]...)
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -2,61 +2,20 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
+	"dlq"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
+	"topics"
 
+	_ "github.com/lib/pq"
 	"github.com/segmentio/kafka-go"
 )
 
-// Структура ERROR лога (из mapper)
-type ErrorLog struct {
-	Timestamp   string `json:"timestamp"`
-	Service     string `json:"service"`
-	Error       string `json:"error"`
-	ProcessedAt string `json:"processed_at"`
-}
-
-// Структура метрик сервиса (из metrics-producer)
-type ServiceMetrics struct {
-	Timestamp    string  `json:"timestamp"`
-	Service      string  `json:"service"`
-	CPUUsage     float64 `json:"cpu_usage"`
-	MemoryUsage  float64 `json:"memory_usage"`
-	LatencyMs    int     `json:"latency_ms"`
-	RequestCount int     `json:"request_count"`
-	GeneratedAt  string  `json:"generated_at"`
-}
-
-// Обогащенная структура - ошибка + метрики
-type EnrichedError struct {
-	ErrorLog
-	Metrics     *ServiceMetrics `json:"metrics,omitempty"`
-	JoinedAt    string          `json:"joined_at"`
-	MetricsAge  string          `json:"metrics_age,omitempty"` // Возраст метрик
-}
-
-// Кэш метрик для join операций
-type MetricsCache struct {
-	mu      sync.RWMutex
-	metrics map[string]*ServiceMetrics // ключ: service
-}
-
-func (mc *MetricsCache) Set(service string, metrics *ServiceMetrics) {
-	mc.mu.Lock()
-	defer mc.mu.Unlock()
-	mc.metrics[service] = metrics
-}
-
-func (mc *MetricsCache) Get(service string) *ServiceMetrics {
-	mc.mu.RLock()
-	defer mc.mu.RUnlock()
-	return mc.metrics[service]
-}
-
 func main() {
 	// Получаем настройки
 	servers := getEnvOrDefault("KAFKA_BOOTSTRAP_SERVERS", "localhost:19092")
@@ -64,37 +23,53 @@ func main() {
 	metricsTopic := getEnvOrDefault("METRICS_TOPIC", "service-metrics")
 	outputTopic := getEnvOrDefault("OUTPUT_TOPIC", "enriched-errors")
 	consumerGroup := getEnvOrDefault("CONSUMER_GROUP", "join-processor")
+	joinWindow := time.Duration(getEnvIntOrDefault("JOIN_WINDOW_SECONDS", 30)) * time.Second
+	retention := time.Duration(getEnvIntOrDefault("METRICS_RETENTION_SECONDS", 600)) * time.Second
+	// {hostname} в пути — свой снапшот у каждого экземпляра на общем томе
+	hostname, _ := os.Hostname()
+	snapshotPath := strings.ReplaceAll(getEnvOrDefault("SNAPSHOT_PATH", "metrics-snapshot.json"), "{hostname}", hostname)
+	snapshotInterval := time.Duration(getEnvIntOrDefault("SNAPSHOT_INTERVAL_SECONDS", 30)) * time.Second
+	grace := time.Duration(getEnvIntOrDefault("GRACE_PERIOD_SECONDS", 10)) * time.Second
+	pendingSize := getEnvIntOrDefault("PENDING_BUFFER_SIZE", 1000)
+
+	// Справочник владельцев сервисов в Postgres (пустой DB_HOST — отключен)
+	dbHost := getEnvOrDefault("DB_HOST", "")
+	dbPort := getEnvOrDefault("DB_PORT", "5432")
+	dbUser := getEnvOrDefault("DB_USER", "postgres")
+	dbPassword := getEnvOrDefault("DB_PASSWORD", "password")
+	dbName := getEnvOrDefault("DB_NAME", "owners")
+	ownersRefresh := getEnvOrDefault("OWNERS_REFRESH", "ttl") // ttl или notify
+	ownersChannel := getEnvOrDefault("OWNERS_NOTIFY_CHANNEL", "service_owners_changed")
+	ownersTTL := time.Duration(getEnvIntOrDefault("OWNERS_TTL_SECONDS", 300)) * time.Second
+	ownersCacheSize := getEnvIntOrDefault("OWNERS_CACHE_SIZE", 1000)
 
 	log.Printf("🔗 Join Processor запущен")
 	log.Printf("📥 ERROR логи из: %s", errorTopic)
 	log.Printf("📥 Метрики из: %s", metricsTopic)
 	log.Printf("📤 Записываем в: %s", outputTopic)
+	log.Printf("⏱️ Окно join: ±%s, храним метрики: %s", joinWindow, retention)
+	log.Printf("⏳ Ждем метрик до %s, буфер ожидания: %d ошибок", grace, pendingSize)
 
 	brokers := strings.Split(servers, ",")
 
-	// Создаем кэш метрик
-	cache := &MetricsCache{
-		metrics: make(map[string]*ServiceMetrics),
+	// service-metrics — таблица: compacted топик с ключом по сервису,
+	// ко-партиционированный с error-logs
+	errorPartitions, err := countPartitions(brokers, errorTopic)
+	if err != nil {
+		log.Fatalf("❌ Ошибка чтения партиций %s: %v", errorTopic, err)
 	}
-
-	// Создаем readers
-	errorReader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: brokers,
-		Topic:   errorTopic,
-		GroupID: consumerGroup + "-errors",
-		MinBytes: 10e3,
-		MaxBytes: 10e6,
-	})
-	defer errorReader.Close()
-
-	metricsReader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: brokers,
-		Topic:   metricsTopic,
-		GroupID: consumerGroup + "-metrics",
-		MinBytes: 10e3,
-		MaxBytes: 10e6,
-	})
-	defer metricsReader.Close()
+	if err := EnsureCompactedTopic(brokers, metricsTopic, errorPartitions); err != nil {
+		log.Fatalf("❌ Ошибка подготовки топика метрик: %v", err)
+	}
+	metricsPartitions, err := countPartitions(brokers, metricsTopic)
+	if err != nil {
+		log.Fatalf("❌ Ошибка чтения партиций %s: %v", metricsTopic, err)
+	}
+	if metricsPartitions != errorPartitions {
+		log.Fatalf("❌ Топики не ко-партиционированы: в %s %d партиций, а в %s — %d",
+			errorTopic, errorPartitions, metricsTopic, metricsPartitions)
+	}
+	log.Printf("🧩 Ко-партиционирование: %d партиций в обоих топиках", errorPartitions)
 
 	// Создаем writer для обогащенных данных
 	writer := kafka.NewWriter(kafka.WriterConfig{
@@ -104,105 +79,91 @@ func main() {
 	})
 	defer writer.Close()
 
-	log.Printf("✅ Подключение к Kafka установлено")
+	// Справочник владельцев подключается лениво: недоступная БД не мешает старту
+	var owners *OwnerLookup
+	if dbHost != "" {
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			dbHost, dbPort, dbUser, dbPassword, dbName)
 
-	// Каналы для обработки сообщений
-	errorChan := make(chan kafka.Message, 100)
-	metricsChan := make(chan kafka.Message, 100)
-
-	// Горутина для чтения ERROR логов
-	go func() {
-		for {
-			message, err := errorReader.ReadMessage(context.Background())
-			if err != nil {
-				log.Printf("❌ Ошибка чтения error: %v", err)
-				continue
-			}
-			errorChan <- message
-		}
-	}()
-
-	// Горутина для чтения метрик
-	go func() {
-		for {
-			message, err := metricsReader.ReadMessage(context.Background())
-			if err != nil {
-				log.Printf("❌ Ошибка чтения metrics: %v", err)
-				continue
-			}
-			metricsChan <- message
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			log.Fatalf("❌ Ошибка подключения к БД: %v", err)
 		}
-	}()
+		defer db.Close()
 
-	// Основной цикл join обработки
-	for {
-		select {
-		case metricsMsg := <-metricsChan:
-			// Обновляем кэш метрик
-			var metrics ServiceMetrics
-			err := json.Unmarshal(metricsMsg.Value, &metrics)
-			if err != nil {
-				log.Printf("❌ Ошибка JSON metrics: %v", err)
-				continue
-			}
+		if err := db.Ping(); err != nil {
+			log.Printf("⚠️  БД владельцев пока недоступна: %v", err)
+		}
 
-			cache.Set(metrics.Service, &metrics)
-			log.Printf("📊 Обновлены метрики для %s: CPU=%.1f%% LAT=%dms",
-				metrics.Service, metrics.CPUUsage, metrics.LatencyMs)
-
-		case errorMsg := <-errorChan:
-			// Обрабатываем ERROR лог
-			var errorLog ErrorLog
-			err := json.Unmarshal(errorMsg.Value, &errorLog)
-			if err != nil {
-				log.Printf("❌ Ошибка JSON error: %v", err)
-				continue
+		owners = NewOwnerLookup(db, ownersTTL, ownersCacheSize)
+		if ownersRefresh == "notify" {
+			if err := owners.Listen(context.Background(), dsn, ownersChannel); err != nil {
+				log.Printf("⚠️  LISTEN %s не удался, обновляем только по TTL: %v", ownersChannel, err)
 			}
+		}
+		log.Printf("👥 Справочник владельцев: %s/%s (обновление: %s, TTL %s, кэш %d)",
+			dbHost, dbName, ownersRefresh, ownersTTL, ownersCacheSize)
+	}
 
-			// Получаем метрики для данного сервиса
-			metrics := cache.Get(errorLog.Service)
-
-			// Создаем обогащенную запись
-			enriched := EnrichedError{
-				ErrorLog: errorLog,
-				Metrics:  metrics,
-				JoinedAt: time.Now().Format("2006-01-02 15:04:05"),
-			}
+	// Одна группа на оба топика: range balancer отдает партицию N
+	// error-logs и service-metrics одному экземпляру
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:             consumerGroup,
+		Brokers:        brokers,
+		Topics:         []string{errorTopic, metricsTopic},
+		GroupBalancers: []kafka.GroupBalancer{kafka.RangeGroupBalancer{}},
+	})
+	if err != nil {
+		log.Fatalf("❌ Ошибка создания consumer group: %v", err)
+	}
+	defer group.Close()
 
-			// Если нашли метрики, вычисляем их возраст
-			if metrics != nil {
-				metricsTime, err := time.Parse("2006-01-02 15:04:05", metrics.Timestamp)
-				if err == nil {
-					age := time.Since(metricsTime)
-					enriched.MetricsAge = age.String()
-				}
-			}
+	log.Printf("✅ Подключение к Kafka установлено")
 
-			// Сериализуем обогащенные данные
-			enrichedBytes, err := json.Marshal(enriched)
-			if err != nil {
-				log.Printf("❌ Ошибка сериализации: %v", err)
-				continue
-			}
+	// Нечитаемые ошибки и метрики уходят в error-logs.dlq и service-metrics.dlq
+	deadLetters := dlq.NewWriter(brokers, "join-processor")
+	defer deadLetters.Close()
+
+	processor := &JoinProcessor{
+		brokers:          brokers,
+		errorTopic:       errorTopic,
+		metricsTopic:     metricsTopic,
+		writer:           writer,
+		joinWindow:       joinWindow,
+		retention:        retention,
+		snapshotPath:     snapshotPath,
+		snapshotInterval: snapshotInterval,
+		pending:          NewPendingErrors(grace, pendingSize),
+		owners:           owners,
+		deadLetters:      deadLetters,
+	}
 
-			// Записываем обогащенные данные
-			err = writer.WriteMessages(context.Background(), kafka.Message{
-				Key:   []byte(errorLog.Service),
-				Value: enrichedBytes,
-			})
-
-			if err != nil {
-				log.Printf("❌ Ошибка записи: %v", err)
-			} else {
-				if metrics != nil {
-					log.Printf("🔗 JOIN: %s ошибка + метрики (CPU=%.1f%%, LAT=%dms)",
-						errorLog.Service, metrics.CPUUsage, metrics.LatencyMs)
-				} else {
-					log.Printf("🔗 JOIN: %s ошибка БЕЗ метрик", errorLog.Service)
-				}
-			}
+	// Каждый ребаланс начинает новое поколение с новым набором партиций
+	for {
+		gen, err := group.Next(context.Background())
+		if err != nil {
+			log.Printf("❌ Ошибка присоединения к группе: %v", err)
+			time.Sleep(time.Second)
+			continue
 		}
+		gen.Start(func(ctx context.Context) {
+			processor.Run(ctx, gen)
+		})
+	}
+}
+
+// Количество партиций топика; топик должен существовать
+func countPartitions(brokers []string, topic string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), topics.Timeout)
+	defer cancel()
+	partitions, err := topics.Partitions(ctx, topics.NewClient(brokers), topic)
+	if err != nil {
+		return 0, err
+	}
+	if len(partitions) == 0 {
+		return 0, fmt.Errorf("топик %s не найден", topic)
 	}
+	return len(partitions), nil
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -211,4 +172,12 @@ func getEnvOrDefault(key, defaultValue string) string {
 		return defaultValue
 	}
 	return value
-} 
\ No newline at end of file
+}
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"events"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Запись кэша владельцев. owner == nil — сервиса нет в справочнике.
+type ownerEntry struct {
+	service  string
+	owner    *events.ServiceOwner
+	loadedAt time.Time
+}
+
+// Счетчики справочника владельцев
+type OwnerStats struct {
+	Hits   int // ответ из свежего кэша
+	Loads  int // запросов в Postgres
+	Stale  int // отдали устаревшие данные, потому что БД недоступна
+	Errors int // ошибок запроса к БД
+}
+
+// Read-through LRU кэш поверх таблицы service_owners.
+//
+// Запись живет ttl, после чего перечитывается при следующем обращении. Если
+// Postgres недоступен, отдаются устаревшие данные с пометкой Stale, а новые
+// попытки запроса делаются не чаще раза в retryInterval, чтобы не тормозить join.
+// В режиме notify записи дополнительно сбрасываются по NOTIFY из триггера таблицы.
+type OwnerLookup struct {
+	db            *sql.DB
+	ttl           time.Duration
+	capacity      int
+	queryTimeout  time.Duration
+	retryInterval time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List // от недавно использованных к давно использованным
+	downUntil time.Time  // до этого времени БД считается недоступной
+	stats     OwnerStats
+}
+
+func NewOwnerLookup(db *sql.DB, ttl time.Duration, capacity int) *OwnerLookup {
+	return &OwnerLookup{
+		db:            db,
+		ttl:           ttl,
+		capacity:      capacity,
+		queryTimeout:  2 * time.Second,
+		retryInterval: 5 * time.Second,
+		entries:       make(map[string]*list.Element),
+		order:         list.New(),
+	}
+}
+
+// Get возвращает владельца сервиса или nil, если сервиса нет в справочнике
+// (или БД недоступна, а в кэше про сервис ничего нет)
+func (ol *OwnerLookup) Get(service string) *events.ServiceOwner {
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+
+	element, cached := ol.entries[service]
+	if cached {
+		ol.order.MoveToFront(element)
+		entry := element.Value.(*ownerEntry)
+		if time.Since(entry.loadedAt) < ol.ttl {
+			ol.stats.Hits++
+			return copyOwner(entry.owner, false)
+		}
+	}
+
+	if time.Now().Before(ol.downUntil) {
+		return ol.stale(element)
+	}
+
+	owner, err := ol.load(service)
+	if err != nil {
+		ol.stats.Errors++
+		ol.downUntil = time.Now().Add(ol.retryInterval)
+		log.Printf("⚠️  Справочник владельцев недоступен (%s): %v", service, err)
+		return ol.stale(element)
+	}
+	ol.stats.Loads++
+	ol.put(service, owner)
+	return copyOwner(owner, false)
+}
+
+// Invalidate сбрасывает запись сервиса; пустое имя сбрасывает весь кэш
+func (ol *OwnerLookup) Invalidate(service string) {
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+
+	if service == "" {
+		ol.entries = make(map[string]*list.Element)
+		ol.order.Init()
+		return
+	}
+	if element, ok := ol.entries[service]; ok {
+		ol.order.Remove(element)
+		delete(ol.entries, service)
+	}
+}
+
+// Listen подписывается на NOTIFY канала и сбрасывает записи измененных сервисов.
+// После переподключения кэш сбрасывается целиком: уведомления могли потеряться.
+func (ol *OwnerLookup) Listen(ctx context.Context, dsn, channel string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("⚠️  LISTEN %s: %v", channel, err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification := <-listener.Notify:
+				if notification == nil {
+					log.Printf("🔄 Переподключение LISTEN %s, сбрасываем кэш владельцев", channel)
+					ol.Invalidate("")
+					continue
+				}
+				log.Printf("🔄 Справочник владельцев изменен: %s", notification.Extra)
+				ol.Invalidate(notification.Extra)
+			}
+		}
+	}()
+	return nil
+}
+
+// Size возвращает количество записей в кэше
+func (ol *OwnerLookup) Size() int {
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+	return ol.order.Len()
+}
+
+// Stats возвращает счетчики справочника
+func (ol *OwnerLookup) Stats() OwnerStats {
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+	return ol.stats
+}
+
+func (ol *OwnerLookup) load(service string) (*events.ServiceOwner, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ol.queryTimeout)
+	defer cancel()
+
+	var owner events.ServiceOwner
+	err := ol.db.QueryRowContext(ctx,
+		"SELECT team, on_call, tier, runbook_url FROM service_owners WHERE service = $1",
+		service,
+	).Scan(&owner.Team, &owner.OnCall, &owner.Tier, &owner.RunbookURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+func (ol *OwnerLookup) stale(element *list.Element) *events.ServiceOwner {
+	if element == nil {
+		return nil
+	}
+	ol.stats.Stale++
+	return copyOwner(element.Value.(*ownerEntry).owner, true)
+}
+
+func (ol *OwnerLookup) put(service string, owner *events.ServiceOwner) {
+	if element, ok := ol.entries[service]; ok {
+		entry := element.Value.(*ownerEntry)
+		entry.owner = owner
+		entry.loadedAt = time.Now()
+		ol.order.MoveToFront(element)
+		return
+	}
+
+	ol.entries[service] = ol.order.PushFront(&ownerEntry{service: service, owner: owner, loadedAt: time.Now()})
+	if ol.order.Len() > ol.capacity {
+		oldest := ol.order.Back()
+		ol.order.Remove(oldest)
+		delete(ol.entries, oldest.Value.(*ownerEntry).service)
+	}
+}
+
+func copyOwner(owner *events.ServiceOwner, stale bool) *events.ServiceOwner {
+	if owner == nil {
+		return nil
+	}
+	result := *owner
+	result.Stale = stale
+	return &result
+}
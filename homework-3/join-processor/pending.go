@@ -0,0 +1,133 @@
+package main
+
+import (
+	"events"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Ошибка, для которой еще не пришли метрики
+type pendingError struct {
+	message   kafka.Message
+	errorLog  events.ErrorLog
+	errorTime time.Time
+	deadline  time.Time
+}
+
+// Счетчики буфера ожидания
+type PendingStats struct {
+	Held    int // ошибок ушло в буфер
+	Matched int // дождались метрик
+	Expired int // отправлены без метрик по истечении grace периода
+	Evicted int // вытеснены из переполненного буфера
+}
+
+// Буфер ошибок, пришедших раньше метрик (left join с grace периодом).
+//
+// Ошибка ждет метрик своего сервиса не дольше grace. Буфер ограничен maxSize:
+// при переполнении самая старая ошибка вытесняется и отправляется без метрик.
+// Offset'ы ошибок в буфере не коммитятся, поэтому после падения или ребаланса
+// они будут прочитаны заново.
+type PendingErrors struct {
+	grace   time.Duration
+	maxSize int
+	items   []*pendingError // в порядке поступления
+	stats   PendingStats
+}
+
+func NewPendingErrors(grace time.Duration, maxSize int) *PendingErrors {
+	return &PendingErrors{grace: grace, maxSize: maxSize}
+}
+
+// Enabled сообщает, нужно ли вообще придерживать ошибки
+func (pe *PendingErrors) Enabled() bool {
+	return pe.grace > 0 && pe.maxSize > 0
+}
+
+// Add кладет ошибку в буфер. Если буфер переполнен, возвращает вытесненную ошибку.
+func (pe *PendingErrors) Add(message kafka.Message, errorLog events.ErrorLog, errorTime time.Time) *pendingError {
+	pe.items = append(pe.items, &pendingError{
+		message:   message,
+		errorLog:  errorLog,
+		errorTime: errorTime,
+		deadline:  time.Now().Add(pe.grace),
+	})
+	pe.stats.Held++
+
+	if len(pe.items) <= pe.maxSize {
+		return nil
+	}
+	evicted := pe.items[0]
+	pe.items = pe.items[1:]
+	pe.stats.Evicted++
+	return evicted
+}
+
+// Match забирает из буфера ошибки сервиса, для которых в кэше нашлись метрики
+func (pe *PendingErrors) Match(service string, cache *MetricsCache, window time.Duration) []*pendingError {
+	var matched []*pendingError
+	kept := pe.items[:0]
+	for _, item := range pe.items {
+		if item.errorLog.Service == service {
+			if _, _, found := cache.Closest(service, item.errorTime, window); found {
+				matched = append(matched, item)
+				continue
+			}
+		}
+		kept = append(kept, item)
+	}
+	pe.items = kept
+	pe.stats.Matched += len(matched)
+	return matched
+}
+
+// Expired забирает из буфера ошибки, у которых истек grace период
+func (pe *PendingErrors) Expired(now time.Time) []*pendingError {
+	var expired []*pendingError
+	kept := pe.items[:0]
+	for _, item := range pe.items {
+		if now.After(item.deadline) {
+			expired = append(expired, item)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	pe.items = kept
+	pe.stats.Expired += len(expired)
+	return expired
+}
+
+// MinOffset возвращает наименьший offset ошибки партиции, которая еще в буфере
+func (pe *PendingErrors) MinOffset(partition int) (int64, bool) {
+	var lowest int64
+	found := false
+	for _, item := range pe.items {
+		if item.message.Partition != partition {
+			continue
+		}
+		if !found || item.message.Offset < lowest {
+			lowest = item.message.Offset
+			found = true
+		}
+	}
+	return lowest, found
+}
+
+// Reset очищает буфер после ребаланса и возвращает число сброшенных ошибок.
+// Их offset'ы не закоммичены, новый владелец партиции прочитает их заново.
+func (pe *PendingErrors) Reset() int {
+	dropped := len(pe.items)
+	pe.items = nil
+	return dropped
+}
+
+// Size возвращает количество ошибок в буфере
+func (pe *PendingErrors) Size() int {
+	return len(pe.items)
+}
+
+// Stats возвращает счетчики буфера
+func (pe *PendingErrors) Stats() PendingStats {
+	return pe.stats
+}
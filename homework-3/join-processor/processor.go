@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"dlq"
+	"events"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Join ошибок с метриками для партиций, назначенных экземпляру в текущем
+// поколении consumer group.
+//
+// error-logs и service-metrics ко-партиционированы: одинаковое число партиций
+// и Hash partitioner по ключу-сервису у обоих продюсеров. Range balancer
+// раздает партицию N обоих топиков одному и тому же экземпляру, поэтому
+// экземпляру достаточно держать в памяти метрики только своих партиций.
+type JoinProcessor struct {
+	brokers          []string
+	errorTopic       string
+	metricsTopic     string
+	writer           *kafka.Writer
+	joinWindow       time.Duration
+	retention        time.Duration
+	snapshotPath     string
+	snapshotInterval time.Duration
+	pending          *PendingErrors
+	owners           *OwnerLookup // nil — справочник владельцев отключен
+	deadLetters      *dlq.Writer
+}
+
+// Run обрабатывает одно поколение группы: таблица метрик перестраивается
+// под новое назначение партиций, затем ошибки читаются с закоммиченных offset'ов
+func (p *JoinProcessor) Run(ctx context.Context, gen *kafka.Generation) {
+	errorAssignments := gen.Assignments[p.errorTopic]
+	var metricsPartitions []int
+	for _, assignment := range gen.Assignments[p.metricsTopic] {
+		metricsPartitions = append(metricsPartitions, assignment.ID)
+	}
+	log.Printf("🔀 Поколение %d: партиции ошибок %v, партиции метрик %v",
+		gen.ID, partitionIDs(errorAssignments), metricsPartitions)
+
+	// Загружаем снапшот и дочитываем свои партиции метрик до старта join'а
+	cache := NewMetricsCache(p.retention)
+	table := NewMetricsTable(p.brokers, p.metricsTopic, metricsPartitions, cache)
+	if err := table.LoadSnapshot(p.snapshotPath); err != nil {
+		log.Printf("⚠️  Снапшот метрик не загружен: %v", err)
+	}
+	if err := table.Bootstrap(ctx); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("❌ Ошибка загрузки метрик: %v", err)
+		}
+		return
+	}
+	log.Printf("✅ Таблица метрик загружена: %d образцов", cache.Size())
+
+	if dropped := p.pending.Reset(); dropped > 0 {
+		log.Printf("⏳ Буфер ожидания сброшен после ребаланса: %d ошибок будут прочитаны заново", dropped)
+	}
+
+	// Каналы для обработки сообщений
+	errorChan := make(chan kafka.Message, 100)
+	metricsChan := make(chan kafka.Message, 100)
+
+	// Горутины для чтения новых метрик и ERROR логов своих партиций
+	table.Tail(ctx, metricsChan)
+	for _, assignment := range errorAssignments {
+		go p.readErrors(ctx, assignment, errorChan)
+	}
+
+	// Следующие offset'ы обработанных и закоммиченных ошибок по партициям
+	processed := make(map[int]int64)
+	committed := make(map[int]int64)
+
+	// Ticker для grace периода и коммита offset'ов ошибок
+	commitTicker := time.NewTicker(time.Second)
+	defer commitTicker.Stop()
+
+	// Ticker для локального снапшота таблицы метрик
+	snapshotTicker := time.NewTicker(p.snapshotInterval)
+	defer snapshotTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Ребаланс или остановка: фиксируем прогресс и отдаем партиции
+			p.commit(gen, processed, committed)
+			if err := table.SaveSnapshot(p.snapshotPath); err != nil {
+				log.Printf("❌ Ошибка сохранения снапшота метрик: %v", err)
+			}
+			log.Printf("🔀 Поколение %d завершено", gen.ID)
+			return
+
+		case metricsMsg := <-metricsChan:
+			// Обновляем таблицу метрик
+			metrics, err := table.Apply(metricsMsg)
+			if err != nil {
+				log.Printf("❌ Ошибка метрик: %v", err)
+				if err := p.deadLetters.Send(ctx, metricsMsg, err); err != nil {
+					// Поколение закончилось, а запись не в DLQ. Снапшот не
+					// сохраняем: его offset уже за этой записью
+					log.Printf("❌ %v", err)
+					p.commit(gen, processed, committed)
+					return
+				}
+				continue
+			}
+			log.Printf("📊 Добавлены метрики для %s: CPU=%.1f%% LAT=%dms (в буфере: %d)",
+				metrics.Service, metrics.CPUUsage, metrics.LatencyMs, cache.Size())
+
+			// Отпускаем ошибки, которые ждали метрик этого сервиса
+			for _, item := range p.pending.Match(metrics.Service, cache, p.joinWindow) {
+				p.join(cache, &item.errorLog, item.errorTime)
+			}
+
+		case errorMsg := <-errorChan:
+			// Запись не дошла до DLQ — ее offset не коммитим, и новый
+			// владелец прочитает ее заново
+			if err := p.handleError(ctx, cache, errorMsg); err != nil {
+				log.Printf("❌ %v", err)
+				p.commit(gen, processed, committed)
+				return
+			}
+			processed[errorMsg.Partition] = errorMsg.Offset + 1
+
+		case now := <-commitTicker.C:
+			// Ошибки, не дождавшиеся метрик, отправляем без них
+			for _, item := range p.pending.Expired(now) {
+				p.join(cache, &item.errorLog, item.errorTime)
+			}
+			p.commit(gen, processed, committed)
+
+		case <-snapshotTicker.C:
+			if err := table.SaveSnapshot(p.snapshotPath); err != nil {
+				log.Printf("❌ Ошибка сохранения снапшота метрик: %v", err)
+			}
+			if p.pending.Enabled() {
+				stats := p.pending.Stats()
+				log.Printf("⏳ Буфер ожидания: %d ошибок (дождались метрик: %d, по таймауту: %d, вытеснено: %d)",
+					p.pending.Size(), stats.Matched, stats.Expired, stats.Evicted)
+			}
+			if p.owners != nil {
+				stats := p.owners.Stats()
+				log.Printf("👥 Кэш владельцев: %d записей (из кэша: %d, из БД: %d, устаревших: %d, ошибок БД: %d)",
+					p.owners.Size(), stats.Hits, stats.Loads, stats.Stale, stats.Errors)
+			}
+		}
+	}
+}
+
+// Читает одну партицию ERROR логов с offset'а, закоммиченного группой
+func (p *JoinProcessor) readErrors(ctx context.Context, assignment kafka.PartitionAssignment, out chan<- kafka.Message) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   p.brokers,
+		Topic:     p.errorTopic,
+		Partition: assignment.ID,
+		MinBytes:  10e3,
+		MaxBytes:  10e6,
+		// mapper пишет в error-logs транзакциями — читаем только подтвержденные
+		IsolationLevel: kafka.ReadCommitted,
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(assignment.Offset); err != nil {
+		log.Printf("❌ Ошибка установки offset ошибок (партиция %d): %v", assignment.ID, err)
+		return
+	}
+
+	for {
+		message, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("❌ Ошибка чтения error: %v", err)
+			continue
+		}
+		select {
+		case out <- message:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Разбирает ERROR лог и либо сразу делает join, либо оставляет ошибку ждать
+// метрик. Ошибка — только если нечитаемую запись не удалось отправить в DLQ.
+func (p *JoinProcessor) handleError(ctx context.Context, cache *MetricsCache, errorMsg kafka.Message) error {
+	var errorLog events.ErrorLog
+	err := events.Decode(errorMsg.Value, &errorLog)
+	if err != nil {
+		log.Printf("❌ Ошибка JSON error: %v", err)
+		return p.deadLetters.Send(ctx, errorMsg, err)
+	}
+
+	errorTime, err := events.ParseTime(errorLog.Timestamp)
+	if err != nil {
+		log.Printf("❌ Ошибка времени error: %v", err)
+		return p.deadLetters.Send(ctx, errorMsg, err)
+	}
+
+	if _, _, found := cache.Closest(errorLog.Service, errorTime, p.joinWindow); found || !p.pending.Enabled() {
+		p.join(cache, &errorLog, errorTime)
+		return nil
+	}
+
+	// Метрик еще нет — ждем их grace период
+	log.Printf("⏳ %s: ошибка ждет метрик (в буфере: %d)", errorLog.Service, p.pending.Size()+1)
+	if evicted := p.pending.Add(errorMsg, errorLog, errorTime); evicted != nil {
+		log.Printf("⚠️  Буфер ожидания переполнен, отправляем ошибку %s без метрик", evicted.errorLog.Service)
+		p.join(cache, &evicted.errorLog, evicted.errorTime)
+	}
+	return nil
+}
+
+// Обогащает ERROR лог ближайшим образцом метрик и пишет результат
+func (p *JoinProcessor) join(cache *MetricsCache, errorLog *events.ErrorLog, errorTime time.Time) {
+	// Ищем образец метрик, ближайший ко времени ошибки
+	metrics, offset, found := cache.Closest(errorLog.Service, errorTime, p.joinWindow)
+
+	// Создаем обогащенную запись
+	enriched := events.EnrichedError{
+		ErrorLog:   *errorLog,
+		Metrics:    metrics,
+		JoinedAt:   events.FormatTime(time.Now()),
+		JoinStatus: events.JoinNoMetrics,
+		JoinWindow: p.joinWindow.String(),
+	}
+	if found {
+		enriched.JoinStatus = events.JoinMatched
+		enriched.MetricsAge = offset.String()
+	}
+	if p.owners != nil {
+		enriched.Owner = p.owners.Get(errorLog.Service)
+	}
+
+	// Сериализуем обогащенные данные
+	enrichedBytes, err := events.Encode(&enriched)
+	if err != nil {
+		log.Printf("❌ Ошибка сериализации: %v", err)
+		return
+	}
+
+	// Записываем обогащенные данные
+	err = p.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(errorLog.Service),
+		Value: enrichedBytes,
+	})
+
+	if err != nil {
+		log.Printf("❌ Ошибка записи: %v", err)
+	} else {
+		if found {
+			log.Printf("🔗 JOIN: %s ошибка + метрики (CPU=%.1f%%, LAT=%dms, сдвиг %s)",
+				errorLog.Service, metrics.CPUUsage, metrics.LatencyMs, offset)
+		} else {
+			log.Printf("🔗 JOIN: %s ошибка БЕЗ метрик в окне ±%s", errorLog.Service, p.joinWindow)
+		}
+	}
+}
+
+// Коммитит offset'ы обработанных ошибок в рамках поколения.
+// Offset партиции не уходит дальше первой ошибки, которая еще ждет метрик.
+func (p *JoinProcessor) commit(gen *kafka.Generation, processed, committed map[int]int64) {
+	offsets := make(map[int]int64)
+	for partition, offset := range processed {
+		if held, ok := p.pending.MinOffset(partition); ok && held < offset {
+			offset = held
+		}
+		if last, ok := committed[partition]; ok && last == offset {
+			continue
+		}
+		offsets[partition] = offset
+	}
+	if len(offsets) == 0 {
+		return
+	}
+
+	if err := gen.CommitOffsets(map[string]map[int]int64{p.errorTopic: offsets}); err != nil {
+		log.Printf("❌ Ошибка коммита offset'ов ошибок: %v", err)
+		return
+	}
+	for partition, offset := range offsets {
+		committed[partition] = offset
+	}
+}
+
+func partitionIDs(assignments []kafka.PartitionAssignment) []int {
+	ids := make([]int, 0, len(assignments))
+	for _, assignment := range assignments {
+		ids = append(ids, assignment.ID)
+	}
+	return ids
+}
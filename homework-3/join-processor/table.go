@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"events"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"topics"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Таблица метрик поверх compacted топика service-metrics.
+//
+// Таблица отвечает только за назначенные этому экземпляру партиции. При старте
+// и после каждого ребаланса она загружает локальный снапшот, дочитывает свои
+// партиции до текущего конца и только после этого разрешает обработку ошибок.
+// Дальше новые записи читаются с того же места, без consumer group.
+type MetricsTable struct {
+	brokers    []string
+	topic      string
+	partitions []int
+	cache      *MetricsCache
+
+	mu       sync.Mutex
+	offsets  map[int]int64  // следующий offset по партициям
+	services map[string]int // в какой партиции живет сервис
+}
+
+// Формат локального снапшота таблицы
+type tableSnapshot struct {
+	Partitions map[int]*partitionSnapshot `json:"partitions"`
+}
+
+type partitionSnapshot struct {
+	Offset  int64                    `json:"offset"`
+	Samples []*events.ServiceMetrics `json:"samples"`
+}
+
+func NewMetricsTable(brokers []string, topic string, partitions []int, cache *MetricsCache) *MetricsTable {
+	return &MetricsTable{
+		brokers:    brokers,
+		topic:      topic,
+		partitions: partitions,
+		cache:      cache,
+		offsets:    make(map[int]int64),
+		services:   make(map[string]int),
+	}
+}
+
+// Apply добавляет запись метрик в кэш и запоминает ее offset
+func (t *MetricsTable) Apply(message kafka.Message) (*events.ServiceMetrics, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.offsets[message.Partition] = message.Offset + 1
+
+	var metrics events.ServiceMetrics
+	if err := events.Decode(message.Value, &metrics); err != nil {
+		return nil, err
+	}
+	if err := t.cache.Add(&metrics); err != nil {
+		return nil, err
+	}
+	t.services[metrics.Service] = message.Partition
+	return &metrics, nil
+}
+
+// Bootstrap дочитывает назначенные партиции топика до текущего конца
+func (t *MetricsTable) Bootstrap(ctx context.Context) error {
+	for _, partition := range t.partitions {
+		loaded, err := t.bootstrapPartition(ctx, partition)
+		if err != nil {
+			return fmt.Errorf("партиция %d: %w", partition, err)
+		}
+		log.Printf("📚 Партиция %d метрик загружена: %d записей", partition, loaded)
+	}
+	return nil
+}
+
+func (t *MetricsTable) bootstrapPartition(ctx context.Context, partition int) (int, error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", t.brokers[0], t.topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	first, last, err := conn.ReadOffsets()
+	conn.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// Снапшот вне диапазона топика (компакция, пересоздание) — читаем с начала
+	t.mu.Lock()
+	start, ok := t.offsets[partition]
+	if !ok || start < first || start > last {
+		start = first
+		t.offsets[partition] = first
+	}
+	t.mu.Unlock()
+
+	if start >= last {
+		return 0, nil
+	}
+
+	reader := t.partitionReader(partition)
+	defer reader.Close()
+
+	if err := reader.SetOffset(start); err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+	for offset := start; offset < last; {
+		message, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return loaded, err
+		}
+		offset = message.Offset + 1
+
+		if _, err := t.Apply(message); err != nil {
+			log.Printf("❌ Ошибка метрик при загрузке (offset %d): %v", message.Offset, err)
+			continue
+		}
+		loaded++
+	}
+	return loaded, nil
+}
+
+// Tail читает новые записи назначенных партиций с места, где остановился Bootstrap
+func (t *MetricsTable) Tail(ctx context.Context, out chan<- kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for partition, offset := range t.offsets {
+		reader := t.partitionReader(partition)
+		if err := reader.SetOffset(offset); err != nil {
+			log.Printf("❌ Ошибка установки offset метрик (партиция %d): %v", partition, err)
+			continue
+		}
+
+		go func(reader *kafka.Reader) {
+			defer reader.Close()
+			for {
+				message, err := reader.ReadMessage(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("❌ Ошибка чтения metrics: %v", err)
+					continue
+				}
+				select {
+				case out <- message:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(reader)
+	}
+}
+
+func (t *MetricsTable) partitionReader(partition int) *kafka.Reader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   t.brokers,
+		Topic:     t.topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  10e6,
+	})
+}
+
+// LoadSnapshot загружает кэш и offset'ы назначенных партиций из локального снапшота
+func (t *MetricsTable) LoadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot tableSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	loaded := 0
+	for _, partition := range t.partitions {
+		saved, ok := snapshot.Partitions[partition]
+		if !ok {
+			continue
+		}
+		for _, metrics := range saved.Samples {
+			if err := t.cache.Add(metrics); err != nil {
+				return err
+			}
+			t.services[metrics.Service] = partition
+		}
+		t.offsets[partition] = saved.Offset
+		loaded += len(saved.Samples)
+	}
+
+	log.Printf("💾 Загружен снапшот метрик: %d образцов", loaded)
+	return nil
+}
+
+// SaveSnapshot атомарно записывает кэш и offset'ы в локальный файл: через
+// временный файл с уникальным именем, сброшенный на диск до переименования,
+// чтобы после сбоя не остался обрезанный снапшот
+func (t *MetricsTable) SaveSnapshot(path string) error {
+	t.mu.Lock()
+	snapshot := tableSnapshot{Partitions: make(map[int]*partitionSnapshot)}
+	for partition, offset := range t.offsets {
+		snapshot.Partitions[partition] = &partitionSnapshot{Offset: offset}
+	}
+	for _, metrics := range t.cache.Samples() {
+		if saved, ok := snapshot.Partitions[t.services[metrics.Service]]; ok {
+			saved.Samples = append(saved.Samples, metrics)
+		}
+	}
+	t.mu.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// EnsureCompactedTopic создает топик метрик с cleanup.policy=compact
+// или включает компакцию у уже существующего топика. Наличие топика
+// проверяется запросом метаданных без автосоздания: иначе брокер с
+// auto.create.topics.enable создал бы его с настройками по умолчанию.
+func EnsureCompactedTopic(brokers []string, topic string, partitions int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), topics.Timeout)
+	defer cancel()
+	client := topics.NewClient(brokers)
+
+	existing, err := topics.Partitions(ctx, client, topic)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		log.Printf("🆕 Создаем compacted топик %s (%d партиций)", topic, partitions)
+		// Топик мог создать другой экземпляр или продюсер метрик — тогда
+		// ниже проверяется его компакция
+		err := topics.Create(ctx, client, kafka.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     partitions,
+			ReplicationFactor: -1,
+			ConfigEntries:     topics.Compacted(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	altered, err := topics.EnsureCompacted(ctx, client, topic)
+	if altered {
+		log.Printf("🔧 Включена компакция для топика %s", topic)
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+// Переменная часть шаблона
+const wildcard = "<*>"
+
+// Поиск шаблонов сообщений в стиле Drain.
+//
+// Сообщение разбивается на слова; слова с цифрами сразу считаются переменными.
+// Дерево поиска: число слов → первые prefixTokens слов → список кластеров.
+// В листе выбирается кластер с наибольшей долей совпавших слов; если доля не
+// меньше similarity, различающиеся слова шаблона заменяются на <*>, иначе
+// заводится новый кластер.
+//
+// Отпечаток — хэш текущего шаблона. Пока шаблоны обобщаются на первых
+// сообщениях, отпечаток одного вида ошибок может смениться.
+type Drain struct {
+	prefixTokens int
+	similarity   float64
+	maxChildren  int
+
+	root map[int]*drainNode // ключ: количество слов
+}
+
+type drainNode struct {
+	children map[string]*drainNode
+	clusters []*drainCluster
+}
+
+type drainCluster struct {
+	tokens []string
+	size   int
+}
+
+func NewDrain(prefixTokens int, similarity float64, maxChildren int) *Drain {
+	return &Drain{
+		prefixTokens: prefixTokens,
+		similarity:   similarity,
+		maxChildren:  maxChildren,
+		root:         make(map[int]*drainNode),
+	}
+}
+
+// Mine возвращает шаблон сообщения и его отпечаток
+func (d *Drain) Mine(message string) (template, fingerprint string) {
+	tokens := tokenize(message)
+
+	node, ok := d.root[len(tokens)]
+	if !ok {
+		node = newDrainNode()
+		d.root[len(tokens)] = node
+	}
+
+	// Спускаемся по первым словам; переменные и переполненные узлы идут в <*>
+	for i := 0; i < d.prefixTokens && i < len(tokens); i++ {
+		key := tokens[i]
+		if _, exists := node.children[key]; !exists && len(node.children) >= d.maxChildren {
+			key = wildcard
+		}
+		child, exists := node.children[key]
+		if !exists {
+			child = newDrainNode()
+			node.children[key] = child
+		}
+		node = child
+	}
+
+	cluster := node.bestCluster(tokens, d.similarity)
+	if cluster == nil {
+		cluster = &drainCluster{tokens: append([]string(nil), tokens...)}
+		node.clusters = append(node.clusters, cluster)
+	} else {
+		for i, token := range tokens {
+			if cluster.tokens[i] != token {
+				cluster.tokens[i] = wildcard
+			}
+		}
+	}
+	cluster.size++
+
+	template = strings.Join(cluster.tokens, " ")
+	sum := sha1.Sum([]byte(template))
+	return template, hex.EncodeToString(sum[:])[:12]
+}
+
+func newDrainNode() *drainNode {
+	return &drainNode{children: make(map[string]*drainNode)}
+}
+
+// Кластер с наибольшей долей совпавших слов, не ниже порога.
+// При равной доле выбирается шаблон с большим числом переменных.
+func (n *drainNode) bestCluster(tokens []string, similarity float64) *drainCluster {
+	var best *drainCluster
+	bestSim, bestParams := -1.0, -1
+
+	for _, cluster := range n.clusters {
+		same, params := 0, 0
+		for i, token := range cluster.tokens {
+			if token == wildcard {
+				params++
+				continue
+			}
+			if token == tokens[i] {
+				same++
+			}
+		}
+
+		sim := 1.0
+		if len(tokens) > 0 {
+			sim = float64(same) / float64(len(tokens))
+		}
+		if sim > bestSim || (sim == bestSim && params > bestParams) {
+			best, bestSim, bestParams = cluster, sim, params
+		}
+	}
+
+	if best == nil || bestSim < similarity {
+		return nil
+	}
+	return best
+}
+
+// Разбивает сообщение на слова; слова с цифрами заменяются на <*>
+func tokenize(message string) []string {
+	tokens := strings.Fields(message)
+	for i, token := range tokens {
+		if strings.IndexFunc(token, unicode.IsDigit) >= 0 {
+			tokens[i] = wildcard
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestDrainNumbersAreVariables(t *testing.T) {
+	drain := NewDrain(2, 0.5, 100)
+	template, _ := drain.Mine("Connection to 10.0.0.1 failed after 3 retries")
+	if template != "Connection to <*> failed after <*> retries" {
+		t.Errorf("шаблон %q", template)
+	}
+}
+
+func TestDrainGeneralizesTemplate(t *testing.T) {
+	drain := NewDrain(2, 0.5, 100)
+
+	first, firstFingerprint := drain.Mine("User lookup failed for alice")
+	if first != "User lookup failed for alice" {
+		t.Fatalf("первый шаблон %q", first)
+	}
+
+	// Второе сообщение обобщает шаблон — отпечаток меняется один раз
+	second, fingerprint := drain.Mine("User lookup failed for bob")
+	if second != "User lookup failed for <*>" {
+		t.Fatalf("обобщенный шаблон %q", second)
+	}
+	if fingerprint == firstFingerprint {
+		t.Error("отпечаток не изменился после обобщения")
+	}
+
+	// Дальше отпечаток стабилен для всех сообщений этого вида
+	for _, message := range []string{"User lookup failed for carol", "User lookup failed for bob"} {
+		template, again := drain.Mine(message)
+		if template != second || again != fingerprint {
+			t.Errorf("%q: шаблон %q, отпечаток %s, ожидали %s", message, template, again, fingerprint)
+		}
+	}
+}
+
+func TestDrainFingerprintDependsOnlyOnTemplate(t *testing.T) {
+	// Отпечаток не зависит от экземпляра: другой mapper дает тот же
+	a, b := NewDrain(2, 0.5, 100), NewDrain(2, 0.5, 100)
+	_, first := a.Mine("Payment 42 declined by bank")
+	_, second := b.Mine("Payment 77 declined by bank")
+	if first != second || len(first) != 12 {
+		t.Errorf("отпечатки %q и %q", first, second)
+	}
+}
+
+func TestDrainSeparatesDifferentMessages(t *testing.T) {
+	drain := NewDrain(2, 0.5, 100)
+
+	// Совпадают 2 слова из 6 — ниже порога, шаблоны разные
+	first, firstFingerprint := drain.Mine("Payment failed: card declined by bank")
+	second, secondFingerprint := drain.Mine("Payment failed: upstream gateway timed out")
+	if first == second || firstFingerprint == secondFingerprint {
+		t.Errorf("сообщения слиты в шаблон %q", second)
+	}
+
+	// Разное число слов — разные ветви дерева
+	short, _ := drain.Mine("Payment failed: card declined")
+	if short != "Payment failed: card declined" {
+		t.Errorf("шаблон короткого сообщения %q", short)
+	}
+}
+
+func TestDrainMaxChildren(t *testing.T) {
+	// В узле помещается один потомок, остальные первые слова идут в <*>
+	drain := NewDrain(2, 0.5, 1)
+	drain.Mine("alpha error happened")
+	if template, _ := drain.Mine("beta error happened"); template != "beta error happened" {
+		t.Fatalf("шаблон %q", template)
+	}
+	if template, _ := drain.Mine("gamma error happened"); template != "<*> error happened" {
+		t.Errorf("шаблон переполненного узла %q", template)
+	}
+	if template, _ := drain.Mine("alpha error happened"); template != "alpha error happened" {
+		t.Errorf("шаблон первого потомка %q", template)
+	}
+}
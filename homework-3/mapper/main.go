@@ -2,112 +2,210 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/segmentio/kafka-go"
+	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-// Исходная структура лога (из основного producer)
-type LogMessage struct {
-	Timestamp string `json:"timestamp"`
-	Level     string `json:"level"`
-	Service   string `json:"service"`
-	Message   string `json:"message"`
-}
-
-// Упрощенная структура для ERROR логов
-type ErrorLog struct {
-	Timestamp string `json:"timestamp"`
-	Service   string `json:"service"`
-	Error     string `json:"error"`
-	ProcessedAt string `json:"processed_at"`
-}
-
 func main() {
 	// Получаем настройки
 	servers := getEnvOrDefault("KAFKA_BOOTSTRAP_SERVERS", "localhost:19092")
 	inputTopic := getEnvOrDefault("INPUT_TOPIC", "application-logs")
 	outputTopic := getEnvOrDefault("OUTPUT_TOPIC", "error-logs")
 	consumerGroup := getEnvOrDefault("CONSUMER_GROUP", "error-mapper")
-
-	log.Printf("🔄 Mapper запущен")
+	rulesPath := getEnvOrDefault("RULES_PATH", "") // пусто — только ERROR → OUTPUT_TOPIC
+	mode := getEnvOrDefault("MODE", "rules")       // rules или router
+	hostname, _ := os.Hostname()
+	transactionalID := getEnvOrDefault("TRANSACTIONAL_ID", consumerGroup+"-"+hostname)
+	retryMinMs := getEnvIntOrDefault("RETRY_BACKOFF_MIN_MS", 100)
+	retryMaxMs := getEnvIntOrDefault("RETRY_BACKOFF_MAX_MS", 10000)
+
+	// Настройки поиска шаблонов ошибок (Drain)
+	templatesEnabled := getEnvOrDefault("TEMPLATES_ENABLED", "true") == "true"
+	drainPrefix := getEnvIntOrDefault("DRAIN_PREFIX_TOKENS", 2)
+	drainSimilarity := getEnvFloatOrDefault("DRAIN_SIMILARITY", 0.5)
+	drainMaxChildren := getEnvIntOrDefault("DRAIN_MAX_CHILDREN", 100)
+
+	// Настройки режима роутера
+	routeTemplate := getEnvOrDefault("ROUTE_TOPIC_TEMPLATE", "logs.{{lower .level}}")
+	routeLevels := getEnvOrDefault("ROUTE_LEVELS", "ERROR,WARN,INFO,DEBUG")
+	routeDefault := getEnvOrDefault("ROUTE_DEFAULT_TOPIC", "logs.other")
+	routePartitions := getEnvIntOrDefault("ROUTE_PARTITIONS", 3)
+	routeReplication := getEnvIntOrDefault("ROUTE_REPLICATION_FACTOR", 3)
+
+	// Настройки маскирования персональных данных
+	redactEnabled := getEnvOrDefault("REDACT_ENABLED", "true") == "true"
+	redactPath := getEnvOrDefault("REDACT_PATH", "") // пусто — все встроенные детекторы, режим mask
+	redactSalt := getEnvOrDefault("REDACT_SALT", "")
+	redactStatsSeconds := getEnvIntOrDefault("REDACT_STATS_INTERVAL_SECONDS", 60)
+
+	log.Printf("🔄 Mapper запущен (режим: %s)", mode)
 	log.Printf("📥 Читаем из: %s", inputTopic)
-	log.Printf("📤 Записываем в: %s", outputTopic)
 
 	brokers := strings.Split(servers, ",")
 
-	// Создаем reader для чтения исходных логов
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: brokers,
-		Topic:   inputTopic,
-		GroupID: consumerGroup,
-		MinBytes: 10e3, // 10KB
-		MaxBytes: 10e6, // 10MB
-	})
-	defer reader.Close()
-
-	// Создаем writer для записи ERROR логов  
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: brokers,
-		Topic:   outputTopic,
-		Balancer: &kafka.LeastBytes{},
-	})
-	defer writer.Close()
-
-	log.Printf("✅ Подключение к Kafka установлено")
+	// Роутер раскладывает логи по топикам без преобразования
+	var router *Router
+	if mode == "router" {
+		var err error
+		router, err = NewRouter(routeTemplate, strings.Split(routeLevels, ","), routeDefault)
+		if err != nil {
+			log.Fatalf("❌ Ошибка настройки роутера: %v", err)
+		}
+		log.Printf("🔀 Топики: %s (уровни %s), по умолчанию: %s", routeTemplate, routeLevels, routeDefault)
+	}
 
-	// Основной цикл обработки
-	for {
-		// Читаем сообщение
-		message, err := reader.ReadMessage(context.Background())
+	// Загружаем правила; без файла работает прежнее правило ERROR → OUTPUT_TOPIC
+	var rules atomic.Pointer[RuleSet]
+	if router != nil {
+		rulesPath = ""
+	} else if rulesPath == "" {
+		defaults, err := DefaultRuleSet(outputTopic)
 		if err != nil {
-			log.Printf("❌ Ошибка чтения: %v", err)
-			continue
+			log.Fatalf("❌ Ошибка правил по умолчанию: %v", err)
 		}
+		rules.Store(defaults)
+	} else {
+		loaded, err := LoadRuleSet(rulesPath)
+		if err != nil {
+			log.Fatalf("❌ Ошибка загрузки правил %s: %v", rulesPath, err)
+		}
+		rules.Store(loaded)
+	}
+	if router == nil {
+		logRules(rules.Load())
+	}
 
-		// Парсим исходный лог
-		var logMsg LogMessage
-		err = json.Unmarshal(message.Value, &logMsg)
+	// Маскирование применяется к тексту лога до правил и роутера
+	var redactor atomic.Pointer[Redactor]
+	redactCounts := NewRedactionCounts()
+	if redactEnabled {
+		loaded, err := loadRedactor(redactPath, redactSalt, redactCounts)
 		if err != nil {
-			log.Printf("❌ Ошибка JSON: %v", err)
-			continue
+			log.Fatalf("❌ Ошибка настройки маскирования: %v", err)
 		}
+		redactor.Store(loaded)
+		log.Printf("🙈 Маскирование: %s", strings.Join(loaded.Rules(), ", "))
+
+		go func() {
+			for range time.Tick(time.Duration(redactStatsSeconds) * time.Second) {
+				log.Printf("🙈 Замаскировано: %s", redactCounts)
+			}
+		}()
+	}
 
-		// Фильтруем только ERROR логи
-		if logMsg.Level != "ERROR" {
-			continue // Пропускаем не-ERROR логи
+	// SIGHUP перечитывает файлы правил и маскирования без перезапуска
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if rulesPath != "" {
+				loaded, err := LoadRuleSet(rulesPath)
+				if err != nil {
+					log.Printf("❌ Правила не перезагружены, оставляем прежние: %v", err)
+				} else {
+					rules.Store(loaded)
+					log.Printf("🔁 Правила перезагружены из %s", rulesPath)
+					logRules(loaded)
+				}
+			}
+			if redactEnabled && redactPath != "" {
+				loaded, err := loadRedactor(redactPath, redactSalt, redactCounts)
+				if err != nil {
+					log.Printf("❌ Маскирование не перезагружено, оставляем прежнее: %v", err)
+				} else {
+					redactor.Store(loaded)
+					log.Printf("🔁 Маскирование перезагружено из %s: %s", redactPath, strings.Join(loaded.Rules(), ", "))
+				}
+			}
 		}
+	}()
+
+	// Транзакционная сессия: записи и offset'ы входного топика коммитятся атомарно,
+	// входной топик читается только с подтвержденными транзакциями
+	session, err := kgo.NewGroupTransactSession(
+		kgo.SeedBrokers(brokers...),
+		kgo.TransactionalID(transactionalID),
+		kgo.ConsumerGroup(consumerGroup),
+		kgo.ConsumeTopics(inputTopic),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.RequireStableFetchOffsets(),
+		kgo.RecordPartitioner(hashPartitioner()),
+	)
+	if err != nil {
+		log.Fatalf("❌ Ошибка создания транзакционной сессии: %v", err)
+	}
+	defer session.Close()
+
+	log.Printf("✅ Подключение к Kafka установлено (transactional.id: %s)", transactionalID)
+
+	// Выходные топики создаются до записи: топики роутера с ROUTE_PARTITIONS
+	// и ROUTE_REPLICATION_FACTOR, остальные — с настройками брокера
+	topics := NewTopics(brokers, -1, -1)
+	if router != nil {
+		topics = NewTopics(brokers, routePartitions, routeReplication)
+	}
+
+	mapper := &Mapper{rules: &rules, redactor: &redactor, router: router, topics: topics}
+	if templatesEnabled {
+		mapper.miner = NewDrain(drainPrefix, drainSimilarity, drainMaxChildren)
+		log.Printf("🧬 Шаблоны ошибок: Drain (слов в префиксе: %d, порог сходства: %.2f)", drainPrefix, drainSimilarity)
+	}
+	retry := &backoff{min: time.Duration(retryMinMs) * time.Millisecond, max: time.Duration(retryMaxMs) * time.Millisecond}
 
-		// Преобразуем в упрощенный формат
-		errorLog := ErrorLog{
-			Timestamp:   logMsg.Timestamp,
-			Service:     logMsg.Service,
-			Error:       logMsg.Message,
-			ProcessedAt: time.Now().Format("2006-01-02 15:04:05"),
+	// Основной цикл обработки
+	for {
+		// Читаем пачку сообщений
+		fetches := session.PollFetches(context.Background())
+		if fetches.IsClientClosed() {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			log.Printf("❌ Ошибка чтения %s/%d: %v", topic, partition, err)
+		})
+		if fetches.NumRecords() == 0 {
+			continue
 		}
 
-		// Сериализуем в JSON
-		errorBytes, err := json.Marshal(errorLog)
+		// Неудачная транзакция отменяется, пачка читается заново после паузы
+		produced, err := processBatch(context.Background(), session, mapper, fetches)
 		if err != nil {
-			log.Printf("❌ Ошибка сериализации: %v", err)
+			delay := retry.Next()
+			log.Printf("❌ %v, повтор через %s", err, delay)
+			time.Sleep(delay)
 			continue
 		}
+		retry.Reset()
+		log.Printf("🔄 Транзакция: %d логов → %d записей", fetches.NumRecords(), produced)
+	}
+}
 
-		// Записываем в выходной топик
-		err = writer.WriteMessages(context.Background(), kafka.Message{
-			Key:   []byte(errorLog.Service), // Ключ по сервису для партицирования
-			Value: errorBytes,
-		})
-
+// Файл маскирования необязателен; REDACT_SALT из окружения важнее соли из файла
+func loadRedactor(path, salt string, counts *RedactionCounts) (*Redactor, error) {
+	config := DefaultRedactionConfig()
+	if path != "" {
+		loaded, err := LoadRedactionConfig(path)
 		if err != nil {
-			log.Printf("❌ Ошибка записи: %v", err)
-		} else {
-			log.Printf("🔄 Обработан ERROR: %s - %s", errorLog.Service, errorLog.Error)
+			return nil, err
 		}
+		config = loaded
+	}
+	if salt != "" {
+		config.Salt = salt
+	}
+	return NewRedactor(config, counts)
+}
+
+func logRules(rules *RuleSet) {
+	for _, rule := range rules.Rules {
+		log.Printf("📋 Правило %s → %s", rule.Name, rule.OutputTopic)
 	}
 }
 
@@ -117,4 +215,20 @@ func getEnvOrDefault(key, defaultValue string) string {
 		return defaultValue
 	}
 	return value
-} 
\ No newline at end of file
+} 
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvFloatOrDefault(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Настройки маскирования персональных данных (файл REDACT_PATH в YAML или JSON)
+type RedactionConfig struct {
+	Mode      string          `yaml:"mode" json:"mode"`           // mask или hash
+	Salt      string          `yaml:"salt" json:"salt"`           // ключ HMAC для режима hash
+	Detectors []string        `yaml:"detectors" json:"detectors"` // встроенные: email, phone, card, ip
+	Custom    []CustomPattern `yaml:"custom" json:"custom"`
+}
+
+// Дополнительное регулярное выражение для маскирования
+type CustomPattern struct {
+	Name    string `yaml:"name" json:"name"`
+	Pattern string `yaml:"pattern" json:"pattern"`
+}
+
+// Правило маскирования: регулярное выражение + необязательная проверка найденного
+type redactRule struct {
+	name     string
+	pattern  *regexp.Regexp
+	validate func(string) bool
+}
+
+// Встроенные детекторы в порядке применения: номера карт раньше телефонов,
+// IP раньше телефонов, чтобы длинные числа не разбирались по частям
+var builtinDetectors = []string{"email", "card", "ip", "phone"}
+
+func builtinRule(name string) (*redactRule, error) {
+	switch name {
+	case "email":
+		return &redactRule{name: name, pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)}, nil
+	case "card":
+		return &redactRule{name: name, pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), validate: luhnValid}, nil
+	case "ip":
+		return &redactRule{name: name, pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}\b`), validate: ipValid}, nil
+	case "phone":
+		// Номер с префиксом +код, 8 или кодом в скобках либо с разделителями
+		// между группами: голые 10 цифр чаще оказываются временем или id
+		return &redactRule{name: name, pattern: regexp.MustCompile(`(?:(?:\+\d{1,3}|\b8)[\s.-]?(?:\(\d{3}\)|\d{3})|\(\d{3}\))[\s.-]?\d{3}[\s.-]?\d{2}[\s.-]?\d{2}\b|\b\d{3}[\s.-]\d{3}[\s.-]\d{2}[\s.-]?\d{2}\b`)}, nil
+	}
+	return nil, fmt.Errorf("неизвестный детектор %q", name)
+}
+
+// Адрес должен разбираться как IP и содержать хотя бы одну цифру: иначе
+// "::" из "std::io::Error" считался бы IPv6 адресом. Неопределенный адрес
+// (0.0.0.0, ::) персональных данных не содержит.
+func ipValid(value string) bool {
+	ip := net.ParseIP(value)
+	return ip != nil && !ip.IsUnspecified() && strings.ContainsAny(value, "0123456789abcdefABCDEF")
+}
+
+// Счетчики замаскированных значений по правилам. Переживают перезагрузку настроек.
+type RedactionCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func NewRedactionCounts() *RedactionCounts {
+	return &RedactionCounts{counts: make(map[string]int)}
+}
+
+func (rc *RedactionCounts) add(rule string, n int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.counts[rule] += n
+}
+
+// String возвращает счетчики в виде "email=3 card=1"
+func (rc *RedactionCounts) String() string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	var parts []string
+	for rule, count := range rc.counts {
+		parts = append(parts, fmt.Sprintf("%s=%d", rule, count))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// Маскирование персональных данных в тексте лога.
+//
+// В режиме mask значение заменяется на [REDACTED:<правило>], в режиме hash —
+// на [<правило>:<HMAC-SHA256>], чтобы одинаковые значения можно было сопоставить
+// между записями, не раскрывая их.
+type Redactor struct {
+	rules  []*redactRule
+	hash   bool
+	salt   []byte
+	counts *RedactionCounts
+}
+
+// Соль-заглушка из старых примеров конфигурации: с ней HMAC не защищает значения
+const placeholderSalt = "change-me"
+
+// DefaultRedactionConfig включает все встроенные детекторы в режиме mask
+func DefaultRedactionConfig() *RedactionConfig {
+	return &RedactionConfig{Mode: "mask", Detectors: append([]string(nil), builtinDetectors...)}
+}
+
+// LoadRedactionConfig читает файл настроек. Формат выбирается по расширению.
+func LoadRedactionConfig(path string) (*RedactionConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := DefaultRedactionConfig()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, config)
+	} else {
+		err = yaml.Unmarshal(data, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func NewRedactor(config *RedactionConfig, counts *RedactionCounts) (*Redactor, error) {
+	redactor := &Redactor{salt: []byte(config.Salt), counts: counts}
+
+	switch config.Mode {
+	case "", "mask":
+	case "hash":
+		// Без секретной соли HMAC известных значений (email, телефон)
+		// пересчитывается перебором, и хэш раскрывает исходное значение
+		if config.Salt == "" || config.Salt == placeholderSalt {
+			return nil, fmt.Errorf("режим hash требует секретную соль в REDACT_SALT (пустая и %q не подходят)", placeholderSalt)
+		}
+		redactor.hash = true
+	default:
+		return nil, fmt.Errorf("неизвестный режим %q", config.Mode)
+	}
+
+	enabled := make(map[string]bool)
+	for _, name := range config.Detectors {
+		enabled[strings.TrimSpace(name)] = true
+	}
+	for name := range enabled {
+		if _, err := builtinRule(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range builtinDetectors {
+		if enabled[name] {
+			rule, _ := builtinRule(name)
+			redactor.rules = append(redactor.rules, rule)
+		}
+	}
+
+	for _, custom := range config.Custom {
+		if custom.Name == "" {
+			return nil, fmt.Errorf("у пользовательского шаблона не задано имя")
+		}
+		pattern, err := regexp.Compile(custom.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("шаблон %s: %w", custom.Name, err)
+		}
+		redactor.rules = append(redactor.rules, &redactRule{name: custom.Name, pattern: pattern})
+	}
+
+	return redactor, nil
+}
+
+// Redact маскирует найденные значения и возвращает, изменился ли текст
+func (r *Redactor) Redact(text string) (string, bool) {
+	changed := false
+	for _, rule := range r.rules {
+		found := 0
+		text = rule.pattern.ReplaceAllStringFunc(text, func(value string) string {
+			if rule.validate != nil && !rule.validate(value) {
+				return value
+			}
+			found++
+			return r.replacement(rule.name, value)
+		})
+		if found > 0 {
+			r.counts.add(rule.name, found)
+			changed = true
+		}
+	}
+	return text, changed
+}
+
+// Rules возвращает имена включенных правил
+func (r *Redactor) Rules() []string {
+	names := make([]string, 0, len(r.rules))
+	for _, rule := range r.rules {
+		names = append(names, rule.name)
+	}
+	return names
+}
+
+func (r *Redactor) replacement(rule, value string) string {
+	if !r.hash {
+		return "[REDACTED:" + rule + "]"
+	}
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(value))
+	return "[" + rule + ":" + hex.EncodeToString(mac.Sum(nil))[:12] + "]"
+}
+
+// Проверка номера карты по алгоритму Луна
+func luhnValid(value string) bool {
+	var digits []int
+	for _, c := range value {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, int(c-'0'))
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := digits[i]
+		if (len(digits)-1-i)%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLuhnValid(t *testing.T) {
+	cases := map[string]bool{
+		"4111111111111111":     true,
+		"4111 1111 1111 1111":  true,
+		"5500-0000-0000-0004":  true,
+		"4111111111111112":     false,
+		"411111111111":         false, // меньше 13 цифр
+		"41111111111111111111": false, // больше 19 цифр
+	}
+	for value, want := range cases {
+		if got := luhnValid(value); got != want {
+			t.Errorf("luhnValid(%q) = %v, ожидали %v", value, got, want)
+		}
+	}
+}
+
+func TestRedactMask(t *testing.T) {
+	counts := NewRedactionCounts()
+	redactor, err := NewRedactor(DefaultRedactionConfig(), counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, changed := redactor.Redact("user john@example.com paid with 4111111111111111 from 10.0.0.1, call +7 999 123-45-67")
+	if !changed {
+		t.Fatal("текст не изменился")
+	}
+	want := "user [REDACTED:email] paid with [REDACTED:card] from [REDACTED:ip], call [REDACTED:phone]"
+	if text != want {
+		t.Errorf("получили %q, ожидали %q", text, want)
+	}
+	if got := counts.String(); got != "card=1 email=1 ip=1 phone=1" {
+		t.Errorf("счетчики %q", got)
+	}
+}
+
+func TestRedactSkipsInvalidValues(t *testing.T) {
+	redactor, err := NewRedactor(DefaultRedactionConfig(), NewRedactionCounts())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Номер не проходит проверку Луна, адрес не является IP
+	text := "order 4111111111111112 from 999.1.1.1"
+	if got, changed := redactor.Redact(text); changed || got != text {
+		t.Errorf("получили %q (changed=%v), ожидали текст без изменений", got, changed)
+	}
+}
+
+func TestRedactSkipsLookalikes(t *testing.T) {
+	redactor, err := NewRedactor(DefaultRedactionConfig(), NewRedactionCounts())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, text := range []string{
+		"panic in std::io::Error at Foo::bar", // "::" — не IPv6 адрес
+		"listening on :: and 0.0.0.0",         // неопределенный адрес
+		"ts=1718000000",                       // unix-время — не телефон
+		"order 1700000000 failed",
+	} {
+		if got, changed := redactor.Redact(text); changed || got != text {
+			t.Errorf("получили %q, ожидали текст без изменений", got)
+		}
+	}
+}
+
+func TestRedactPhoneAndIPFormats(t *testing.T) {
+	redactor, err := NewRedactor(DefaultRedactionConfig(), NewRedactionCounts())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"call 8 (999) 123-45-67":       "call [REDACTED:phone]",
+		"call (999) 123 45 67":         "call [REDACTED:phone]",
+		"call 999-123-4567":            "call [REDACTED:phone]",
+		"call 89991234567":             "call [REDACTED:phone]",
+		"from 2001:db8::1 and fe80::1": "from [REDACTED:ip] and [REDACTED:ip]",
+	}
+	for text, want := range cases {
+		if got, _ := redactor.Redact(text); got != want {
+			t.Errorf("%q: получили %q, ожидали %q", text, got, want)
+		}
+	}
+}
+
+func TestRedactCustomPattern(t *testing.T) {
+	config := &RedactionConfig{Custom: []CustomPattern{{Name: "token", Pattern: `tok_[a-z0-9]+`}}}
+	counts := NewRedactionCounts()
+	redactor, err := NewRedactor(config, counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := redactor.Redact("auth tok_abc123 and tok_def456")
+	if got != "auth [REDACTED:token] and [REDACTED:token]" {
+		t.Errorf("получили %q", got)
+	}
+	if counts.String() != "token=2" {
+		t.Errorf("счетчики %q", counts.String())
+	}
+}
+
+func TestRedactHash(t *testing.T) {
+	config := DefaultRedactionConfig()
+	config.Mode = "hash"
+	config.Salt = "0123456789abcdef"
+	redactor, err := NewRedactor(config, NewRedactionCounts())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := redactor.Redact("from john@example.com")
+	second, _ := redactor.Redact("to john@example.com")
+	other, _ := redactor.Redact("to jane@example.com")
+
+	hashed := regexp.MustCompile(`\[email:[0-9a-f]{12}\]`)
+	token := hashed.FindString(first)
+	if token == "" {
+		t.Fatalf("нет хэша в %q", first)
+	}
+	if strings.Contains(first, "john") {
+		t.Errorf("значение не замаскировано: %q", first)
+	}
+	// Одинаковые значения сопоставляются между записями, разные — нет
+	if hashed.FindString(second) != token {
+		t.Errorf("хэши одного значения различаются: %q и %q", first, second)
+	}
+	if hashed.FindString(other) == token {
+		t.Errorf("хэши разных значений совпали: %q", other)
+	}
+
+	// Другая соль дает другой хэш
+	config.Salt = "fedcba9876543210"
+	salted, err := NewRedactor(config, NewRedactionCounts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := salted.Redact("from john@example.com"); again == first {
+		t.Errorf("хэш не зависит от соли: %q", again)
+	}
+}
+
+func TestRedactHashRequiresSalt(t *testing.T) {
+	for _, salt := range []string{"", placeholderSalt} {
+		config := DefaultRedactionConfig()
+		config.Mode = "hash"
+		config.Salt = salt
+		if _, err := NewRedactor(config, NewRedactionCounts()); err == nil {
+			t.Errorf("соль %q принята в режиме hash", salt)
+		}
+	}
+}
+
+func TestRedactRejectsUnknownSettings(t *testing.T) {
+	if _, err := NewRedactor(&RedactionConfig{Mode: "drop"}, NewRedactionCounts()); err == nil {
+		t.Error("неизвестный режим принят")
+	}
+	if _, err := NewRedactor(&RedactionConfig{Detectors: []string{"passport"}}, NewRedactionCounts()); err == nil {
+		t.Error("неизвестный детектор принят")
+	}
+	if _, err := NewRedactor(&RedactionConfig{Custom: []CustomPattern{{Name: "bad", Pattern: "("}}}, NewRedactionCounts()); err == nil {
+		t.Error("некорректный шаблон принят")
+	}
+}
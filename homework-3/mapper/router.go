@@ -0,0 +1,70 @@
+package main
+
+import (
+	"events"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Допустимое имя топика Kafka
+var topicNamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// Режим роутера: каждый лог без изменений уходит в топик, имя которого
+// строится по шаблону (logs.{{lower .level}}, logs.{{.service}} и т.п.).
+//
+// Логи с уровнем не из списка levels и логи, для которых шаблон дал
+// недопустимое имя топика, уходят в топик по умолчанию. Отсутствующие топики
+// создаются (Topics) с заданным числом партиций и фактором репликации.
+type Router struct {
+	template     *template.Template
+	levels       map[string]bool
+	defaultTopic string
+}
+
+func NewRouter(topicTemplate string, levels []string, defaultTopic string) (*Router, error) {
+
+	tmpl, err := template.New("topic").Funcs(templateFuncs).Option("missingkey=zero").Parse(topicTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("шаблон топика: %w", err)
+	}
+	if !topicNamePattern.MatchString(defaultTopic) {
+		return nil, fmt.Errorf("недопустимое имя топика по умолчанию %q", defaultTopic)
+	}
+
+	known := make(map[string]bool)
+	for _, level := range levels {
+		known[strings.ToUpper(strings.TrimSpace(level))] = true
+	}
+
+	return &Router{
+		template:     tmpl,
+		levels:       known,
+		defaultTopic: defaultTopic,
+	}, nil
+}
+
+// Apply выбирает топик для лога; значение записи — исходное сообщение
+func (r *Router) Apply(logMsg *events.LogMessage, value []byte) ([]Mapped, error) {
+	topic := r.defaultTopic
+	rule := "default"
+
+	if r.levels[strings.ToUpper(logMsg.Level)] {
+		routed, err := execute(r.template, map[string]any{
+			"timestamp": logMsg.Timestamp,
+			"level":     logMsg.Level,
+			"service":   logMsg.Service,
+			"message":   logMsg.Message,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if topicNamePattern.MatchString(routed) {
+			topic = routed
+			rule = "route"
+		}
+	}
+
+	return []Mapped{{Rule: rule, Topic: topic, Key: []byte(logMsg.Service), Value: value}}, nil
+}
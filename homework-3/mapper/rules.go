@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"events"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Набор правил mapper'а (файл RULES_PATH в YAML или JSON)
+type RuleSet struct {
+	Rules []*Rule `yaml:"rules" json:"rules"`
+}
+
+// Правило: какие логи выбрать, как построить выходную запись и куда ее записать
+type Rule struct {
+	Name        string `yaml:"name" json:"name"`
+	OutputTopic string `yaml:"output_topic" json:"output_topic"`
+	Match       Match  `yaml:"match" json:"match"`
+	Output      Output `yaml:"output" json:"output"`
+}
+
+// Условия отбора. Все заданные условия должны выполниться одновременно.
+type Match struct {
+	Level   StringList `yaml:"level" json:"level"`     // один из уровней
+	Service StringList `yaml:"service" json:"service"` // один из сервисов
+	Message string     `yaml:"message" json:"message"` // регулярное выражение по тексту
+	All     []*Match   `yaml:"all" json:"all"`         // все вложенные условия
+	Any     []*Match   `yaml:"any" json:"any"`         // хотя бы одно вложенное условие
+	Not     *Match     `yaml:"not" json:"not"`         // вложенное условие не выполняется
+
+	message *regexp.Regexp
+}
+
+// Построение выходной записи из полей лога (timestamp, level, service, message,
+// а также fingerprint и template, если включен поиск шаблонов)
+type Output struct {
+	Schema   string            `yaml:"schema" json:"schema"`     // error_log — проверить как events.ErrorLog
+	Fields   []string          `yaml:"fields" json:"fields"`     // какие поля лога оставить (по умолчанию все)
+	Rename   map[string]string `yaml:"rename" json:"rename"`     // старое имя → новое
+	Static   map[string]any    `yaml:"static" json:"static"`     // постоянные поля
+	Template map[string]string `yaml:"template" json:"template"` // поля из text/template
+	Key      string            `yaml:"key" json:"key"`           // шаблон ключа, по умолчанию {{.service}}
+
+	templates map[string]*template.Template
+	key       *template.Template
+}
+
+// Строка или список строк в файле правил
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = StringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+func (l StringList) contains(value string) bool {
+	for _, item := range l {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Результат применения правила к логу
+type Mapped struct {
+	Rule  string
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// Правила по умолчанию повторяют прежнее поведение: ERROR → error-logs
+func DefaultRuleSet(outputTopic string) (*RuleSet, error) {
+	rules := &RuleSet{Rules: []*Rule{{
+		Name:        "errors",
+		OutputTopic: outputTopic,
+		Match:       Match{Level: StringList{"ERROR"}},
+		Output: Output{
+			Schema:   "error_log",
+			Fields:   []string{"timestamp", "service", "message", "fingerprint", "template"},
+			Rename:   map[string]string{"message": "error"},
+			Template: map[string]string{"processed_at": "{{now}}"},
+		},
+	}}}
+	if err := rules.compile(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// LoadRuleSet читает и проверяет файл правил. Формат выбирается по расширению.
+func LoadRuleSet(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules RuleSet
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &rules)
+	} else {
+		err = yaml.Unmarshal(data, &rules)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rules.compile(); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// Apply прогоняет лог через все правила и возвращает записи сработавших правил.
+// extra — дополнительные поля, доступные правилам наравне с полями лога.
+func (rs *RuleSet) Apply(logMsg *events.LogMessage, extra map[string]any) ([]Mapped, error) {
+	fields := map[string]any{
+		"timestamp": logMsg.Timestamp,
+		"level":     logMsg.Level,
+		"service":   logMsg.Service,
+		"message":   logMsg.Message,
+	}
+	for name, value := range extra {
+		fields[name] = value
+	}
+
+	var result []Mapped
+	for _, rule := range rs.Rules {
+		if !rule.Match.matches(logMsg) {
+			continue
+		}
+		mapped, err := rule.build(fields)
+		if err != nil {
+			return result, fmt.Errorf("правило %s: %w", rule.Name, err)
+		}
+		result = append(result, mapped)
+	}
+	return result, nil
+}
+
+func (rs *RuleSet) compile() error {
+	if len(rs.Rules) == 0 {
+		return fmt.Errorf("в файле нет ни одного правила")
+	}
+	for i, rule := range rs.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.OutputTopic == "" {
+			return fmt.Errorf("правило %s: не задан output_topic", rule.Name)
+		}
+		if err := rule.Match.compile(); err != nil {
+			return fmt.Errorf("правило %s: %w", rule.Name, err)
+		}
+		if err := rule.Output.compile(); err != nil {
+			return fmt.Errorf("правило %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Match) compile() error {
+	if m.Message != "" {
+		re, err := regexp.Compile(m.Message)
+		if err != nil {
+			return fmt.Errorf("message: %w", err)
+		}
+		m.message = re
+	}
+	for _, nested := range append(append([]*Match{}, m.All...), m.Any...) {
+		if err := nested.compile(); err != nil {
+			return err
+		}
+	}
+	if m.Not != nil {
+		return m.Not.compile()
+	}
+	return nil
+}
+
+func (m *Match) matches(logMsg *events.LogMessage) bool {
+	if len(m.Level) > 0 && !m.Level.contains(logMsg.Level) {
+		return false
+	}
+	if len(m.Service) > 0 && !m.Service.contains(logMsg.Service) {
+		return false
+	}
+	if m.message != nil && !m.message.MatchString(logMsg.Message) {
+		return false
+	}
+	for _, nested := range m.All {
+		if !nested.matches(logMsg) {
+			return false
+		}
+	}
+	if len(m.Any) > 0 {
+		matched := false
+		for _, nested := range m.Any {
+			if nested.matches(logMsg) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if m.Not != nil && m.Not.matches(logMsg) {
+		return false
+	}
+	return true
+}
+
+// Функции, доступные в шаблонах полей
+var templateFuncs = template.FuncMap{
+	"now":   func() string { return events.FormatTime(time.Now()) },
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+func (o *Output) compile() error {
+	switch o.Schema {
+	case "", "error_log":
+	default:
+		return fmt.Errorf("неизвестная схема %q", o.Schema)
+	}
+
+	o.templates = make(map[string]*template.Template)
+	for field, text := range o.Template {
+		tmpl, err := template.New(field).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return fmt.Errorf("шаблон %s: %w", field, err)
+		}
+		o.templates[field] = tmpl
+	}
+
+	key := o.Key
+	if key == "" {
+		key = "{{.service}}"
+	}
+	tmpl, err := template.New("key").Funcs(templateFuncs).Option("missingkey=zero").Parse(key)
+	if err != nil {
+		return fmt.Errorf("шаблон ключа: %w", err)
+	}
+	o.key = tmpl
+	return nil
+}
+
+func (r *Rule) build(fields map[string]any) (Mapped, error) {
+	out := make(map[string]any)
+	if len(r.Output.Fields) == 0 {
+		for name, value := range fields {
+			out[name] = value
+		}
+	} else {
+		for _, name := range r.Output.Fields {
+			if value, ok := fields[name]; ok {
+				out[name] = value
+			}
+		}
+	}
+
+	for from, to := range r.Output.Rename {
+		if value, ok := out[from]; ok {
+			delete(out, from)
+			out[to] = value
+		}
+	}
+	for name, value := range r.Output.Static {
+		out[name] = value
+	}
+	for name, tmpl := range r.Output.templates {
+		value, err := execute(tmpl, fields)
+		if err != nil {
+			return Mapped{}, err
+		}
+		out[name] = value
+	}
+
+	key, err := execute(r.Output.key, fields)
+	if err != nil {
+		return Mapped{}, err
+	}
+
+	value, err := encodeOutput(r.Output.Schema, out)
+	if err != nil {
+		return Mapped{}, err
+	}
+	return Mapped{Rule: r.Name, Topic: r.OutputTopic, Key: []byte(key), Value: value}, nil
+}
+
+// Записи со схемой проходят через events.Encode: версия схемы и проверка полей
+func encodeOutput(schema string, out map[string]any) ([]byte, error) {
+	if schema == "" {
+		return json.Marshal(out)
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	var errorLog events.ErrorLog
+	if err := json.Unmarshal(data, &errorLog); err != nil {
+		return nil, err
+	}
+	return events.Encode(&errorLog)
+}
+
+func execute(tmpl *template.Template, fields map[string]any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, fields); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"topics"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Выходные топики создаются явно до первой записи в них. Автосоздание при
+// записи или при запросе метаданных дало бы топик с настройками брокера по
+// умолчанию, и ROUTE_PARTITIONS с ROUTE_REPLICATION_FACTOR не применились бы.
+type Topics struct {
+	client            *kafka.Client
+	partitions        int // -1 — num.partitions брокера
+	replicationFactor int // -1 — default.replication.factor брокера
+
+	known map[string]bool // топики, которые уже существуют
+}
+
+func NewTopics(brokers []string, partitions, replicationFactor int) *Topics {
+	return &Topics{
+		client:            topics.NewClient(brokers),
+		partitions:        partitions,
+		replicationFactor: replicationFactor,
+		known:             make(map[string]bool),
+	}
+}
+
+// Ensure создает топик, если его еще нет
+func (t *Topics) Ensure(topic string) error {
+	if t.known[topic] {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), topics.Timeout)
+	defer cancel()
+
+	partitions, err := topics.Partitions(ctx, t.client, topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		log.Printf("🆕 Создаем топик %s (партиций: %s, репликация: %s)",
+			topic, describeSetting(t.partitions), describeSetting(t.replicationFactor))
+		// Топик мог создать другой экземпляр mapper'а
+		err := topics.Create(ctx, t.client, kafka.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     t.partitions,
+			ReplicationFactor: t.replicationFactor,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	t.known[topic] = true
+	return nil
+}
+
+func describeSetting(value int) string {
+	if value < 0 {
+		return "по умолчанию"
+	}
+	return fmt.Sprint(value)
+}
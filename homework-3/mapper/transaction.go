@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"dlq"
+	"events"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Преобразование одного входного лога: маскирование, затем правила или роутер
+type Mapper struct {
+	rules    *atomic.Pointer[RuleSet]
+	redactor *atomic.Pointer[Redactor]
+	router   *Router // nil — режим правил
+	miner    *Drain  // nil — шаблоны сообщений не ищутся
+	topics   *Topics // создание выходных топиков до записи
+}
+
+// Map возвращает выходные записи для лога. Ошибка значит, что лог нечитаем
+// или его не удалось преобразовать: повтор это не исправит.
+func (m *Mapper) Map(value []byte) ([]Mapped, error) {
+	// Парсим исходный лог
+	var logMsg events.LogMessage
+	err := events.Decode(value, &logMsg)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка JSON: %w", err)
+	}
+
+	// Маскируем персональные данные, пока лог не покинул сырой топик
+	if current := m.redactor.Load(); current != nil {
+		if redacted, changed := current.Redact(logMsg.Message); changed {
+			logMsg.Message = redacted
+			if value, err = events.Encode(&logMsg); err != nil {
+				return nil, fmt.Errorf("ошибка сериализации: %w", err)
+			}
+		}
+	}
+
+	// Применяем правила: каждое сработавшее правило дает свою запись.
+	// В режиме роутера запись одна — в топик по шаблону.
+	var mapped []Mapped
+	if m.router != nil {
+		mapped, err = m.router.Apply(&logMsg, value)
+	} else {
+		mapped, err = m.rules.Load().Apply(&logMsg, m.mine(&logMsg))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка преобразования: %w", err)
+	}
+	return mapped, nil
+}
+
+// Шаблон и отпечаток ищутся только для ERROR логов: по ним считаются виды ошибок
+func (m *Mapper) mine(logMsg *events.LogMessage) map[string]any {
+	if m.miner == nil || logMsg.Level != "ERROR" {
+		return nil
+	}
+	template, fingerprint := m.miner.Mine(logMsg.Message)
+	return map[string]any{"template": template, "fingerprint": fingerprint}
+}
+
+// Обрабатывает пачку записей в одной транзакции: выходные записи и offset'ы
+// входных коммитятся атомарно. При отмене транзакции сессия откатывает
+// чтение к последнему закоммиченному offset'у, и пачка будет прочитана заново.
+func processBatch(ctx context.Context, session *kgo.GroupTransactSession, mapper *Mapper, fetches kgo.Fetches) (int, error) {
+	if err := session.Begin(); err != nil {
+		return 0, fmt.Errorf("начало транзакции: %w", err)
+	}
+
+	// Первая ошибка записи отменяет все буферизованные записи транзакции
+	promise := kgo.AbortingFirstErrPromise(session.Client())
+
+	// Топик, который не удалось создать, отменяет транзакцию: без него
+	// запись в топик все равно не пройдет
+	var topicErr error
+	produce := func(record *kgo.Record) {
+		if topicErr != nil {
+			return
+		}
+		if err := mapper.topics.Ensure(record.Topic); err != nil {
+			topicErr = fmt.Errorf("создание топика %s: %w", record.Topic, err)
+			return
+		}
+		session.Produce(ctx, record, promise.Promise())
+	}
+
+	produced := 0
+	fetches.EachRecord(func(record *kgo.Record) {
+		mapped, err := mapper.Map(record.Value)
+		if err != nil {
+			// Необработанный лог уходит в DLQ в той же транзакции
+			log.Printf("❌ %s[%d]@%d: %v", record.Topic, record.Partition, record.Offset, err)
+			produce(deadLetter(record, err))
+			return
+		}
+
+		for _, out := range mapped {
+			produce(&kgo.Record{
+				Topic: out.Topic,
+				Key:   out.Key, // Ключ по сервису для партицирования
+				Value: out.Value,
+			})
+			produced++
+		}
+	})
+
+	writeErr := promise.Err()
+	if writeErr == nil {
+		writeErr = topicErr
+	}
+	committed, err := session.End(ctx, kgo.TransactionEndTry(writeErr == nil))
+	if err != nil {
+		return 0, fmt.Errorf("завершение транзакции: %w", err)
+	}
+	if !committed {
+		if writeErr != nil {
+			return 0, fmt.Errorf("транзакция отменена: %w", writeErr)
+		}
+		return 0, fmt.Errorf("транзакция отменена: ребаланс группы")
+	}
+	return produced, nil
+}
+
+// Запись для <топик>.dlq с исходными ключом и значением
+func deadLetter(record *kgo.Record, cause error) *kgo.Record {
+	message := kafka.Message{
+		Topic:     record.Topic,
+		Partition: int(record.Partition),
+		Offset:    record.Offset,
+		Key:       record.Key,
+		Value:     record.Value,
+	}
+	for _, header := range record.Headers {
+		message.Headers = append(message.Headers, kafka.Header{Key: header.Key, Value: header.Value})
+	}
+
+	dead := dlq.NewRecord(message, "mapper", cause).Message()
+	out := &kgo.Record{Topic: dead.Topic, Key: dead.Key, Value: dead.Value}
+	for _, header := range dead.Headers {
+		out.Headers = append(out.Headers, kgo.RecordHeader{Key: header.Key, Value: header.Value})
+	}
+	return out
+}
+
+// Partitioner, совместимый с kafka.Hash из kafka-go (FNV-1a, как в Sarama):
+// join-processor ждет ко-партиционирования error-logs с service-metrics
+func hashPartitioner() kgo.Partitioner {
+	return kgo.StickyKeyPartitioner(kgo.SaramaCompatHasher(func(key []byte) uint32 {
+		hasher := fnv.New32a()
+		hasher.Write(key)
+		return hasher.Sum32()
+	}))
+}
+
+// Экспоненциальная задержка между повторами
+type backoff struct {
+	min, max time.Duration
+	current  time.Duration
+}
+
+// Next возвращает следующую задержку: min, 2*min, 4*min ... до max
+func (b *backoff) Next() time.Duration {
+	b.current *= 2
+	if b.current == 0 {
+		b.current = b.min
+	}
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return b.current
+}
+
+// Reset возвращает задержку к минимальной после успешной попытки
+func (b *backoff) Reset() {
+	b.current = 0
+}
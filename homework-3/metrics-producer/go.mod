@@ -2,9 +2,16 @@ module metrics-producer
 
 go 1.23.3
 
-require github.com/segmentio/kafka-go v0.4.47
+require (
+	events v0.0.0
+	github.com/segmentio/kafka-go v0.4.47
+)
+
+require github.com/google/uuid v1.6.0 // indirect
 
 require (
 	github.com/klauspost/compress v1.15.9 // indirect
 	github.com/pierrec/lz4/v4 v4.1.15 // indirect
 )
+
+replace events => ../../events
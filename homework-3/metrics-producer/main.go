@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"events"
 	"log"
 	"math/rand"
 	"os"
@@ -12,17 +12,6 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-// Структура метрик сервиса
-type ServiceMetrics struct {
-	Timestamp    string  `json:"timestamp"`
-	Service      string  `json:"service"`
-	CPUUsage     float64 `json:"cpu_usage"`     // процент
-	MemoryUsage  float64 `json:"memory_usage"`  // процент
-	LatencyMs    int     `json:"latency_ms"`    // миллисекунды
-	RequestCount int     `json:"request_count"` // запросов в секунду
-	GeneratedAt  string  `json:"generated_at"`
-}
-
 func main() {
 	// Получаем настройки
 	servers := getEnvOrDefault("KAFKA_BOOTSTRAP_SERVERS", "localhost:19092")
@@ -39,7 +28,7 @@ func main() {
 	writer := kafka.NewWriter(kafka.WriterConfig{
 		Brokers:  brokers,
 		Topic:    topic,
-		Balancer: &kafka.LeastBytes{},
+		Balancer: &kafka.Hash{}, // join-processor ждет ко-партиционирования с error-logs
 	})
 	defer writer.Close()
 
@@ -50,18 +39,18 @@ func main() {
 	// Бесконечный цикл генерации метрик
 	for {
 		for _, service := range services {
-			metrics := ServiceMetrics{
-				Timestamp:    time.Now().Format("2006-01-02 15:04:05"),
+			metrics := events.ServiceMetrics{
+				Timestamp:    events.FormatTime(time.Now()),
 				Service:      service,
 				CPUUsage:     generateCPUUsage(service),
 				MemoryUsage:  generateMemoryUsage(service),
 				LatencyMs:    generateLatency(service),
 				RequestCount: generateRequestCount(service),
-				GeneratedAt:  time.Now().Format("2006-01-02 15:04:05"),
+				GeneratedAt:  events.FormatTime(time.Now()),
 			}
 
 			// Сериализуем метрики
-			metricsBytes, err := json.Marshal(metrics)
+			metricsBytes, err := events.Encode(&metrics)
 			if err != nil {
 				log.Printf("❌ Ошибка сериализации: %v", err)
 				continue
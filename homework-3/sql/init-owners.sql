@@ -0,0 +1,33 @@
+-- Справочник владельцев сервисов для join-processor
+
+CREATE TABLE service_owners (
+    service VARCHAR(100) PRIMARY KEY,
+    team VARCHAR(100) NOT NULL,
+    on_call VARCHAR(255) NOT NULL DEFAULT '',
+    tier VARCHAR(20) NOT NULL DEFAULT '',
+    runbook_url TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Уведомление join-processor об изменениях (OWNERS_REFRESH=notify)
+CREATE OR REPLACE FUNCTION notify_service_owners_changed()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('service_owners_changed', OLD.service);
+        RETURN OLD;
+    END IF;
+    PERFORM pg_notify('service_owners_changed', NEW.service);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER service_owners_changed
+    AFTER INSERT OR UPDATE OR DELETE ON service_owners
+    FOR EACH ROW EXECUTE FUNCTION notify_service_owners_changed();
+
+-- Начальные данные
+INSERT INTO service_owners (service, team, on_call, tier, runbook_url) VALUES
+    ('user-service', 'identity', 'identity-oncall@example.com', 'tier-1', 'https://runbooks.example.com/user-service'),
+    ('order-service', 'checkout', 'checkout-oncall@example.com', 'tier-1', 'https://runbooks.example.com/order-service'),
+    ('payment-service', 'payments', 'payments-oncall@example.com', 'tier-0', 'https://runbooks.example.com/payment-service');
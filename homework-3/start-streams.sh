@@ -55,6 +55,7 @@ echo ""
 echo "🔍 Для просмотра логов используйте:"
 echo "   📊 Статистика ошибок:    docker compose -f docker-compose.streams.yml logs -f stats-consumer"
 echo "   🔗 Обогащенные ошибки:   docker compose -f docker-compose.streams.yml logs -f enriched-consumer"
+echo "   🚨 Алерты:               docker compose -f docker-compose.streams.yml logs -f anomaly-detector"
 echo "   🔄 Все компоненты:       docker compose -f docker-compose.streams.yml logs -f"
 echo ""
 echo "🌐 Kafka UI: http://localhost:8180"
@@ -2,24 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"dlq"
+	"events"
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/segmentio/kafka-go"
 )
 
-// Структура статистики ошибок (из aggregator)
-type ErrorStats struct {
-	WindowStart string         `json:"window_start"`
-	WindowEnd   string         `json:"window_end"`
-	Services    map[string]int `json:"services"`
-	TotalErrors int            `json:"total_errors"`
-	GeneratedAt string         `json:"generated_at"`
-}
-
 func main() {
 	// Получаем настройки
 	servers := getEnvOrDefault("KAFKA_BOOTSTRAP_SERVERS", "localhost:19092")
@@ -41,34 +34,51 @@ func main() {
 	})
 	defer reader.Close()
 
+	// Нечитаемые сообщения уходят в <топик>.dlq
+	deadLetters := dlq.NewWriter(brokers, "stats-consumer")
+	defer deadLetters.Close()
+
 	log.Printf("✅ Подключение к Kafka установлено")
 	log.Printf("\n🔍 Ожидаем статистику ошибок...\n")
 
-	// Бесконечный цикл чтения статистики
+	// Бесконечный цикл чтения статистики. Offset коммитится после обработки:
+	// нечитаемая запись сначала должна попасть в DLQ
 	for {
-		message, err := reader.ReadMessage(context.Background())
+		message, err := reader.FetchMessage(context.Background())
 		if err != nil {
 			log.Printf("❌ Ошибка чтения: %v", err)
 			continue
 		}
 
 		// Парсим статистику
-		var stats ErrorStats
-		err = json.Unmarshal(message.Value, &stats)
+		var stats events.ErrorStats
+		err = events.Decode(message.Value, &stats)
 		if err != nil {
 			log.Printf("❌ Ошибка JSON: %v", err)
+			if err := deadLetters.Send(context.Background(), message, err); err != nil {
+				log.Fatalf("❌ Запись не отправлена в DLQ: %v", err)
+			}
+			commit(reader, message)
 			continue
 		}
 
 		// Красиво отображаем статистику
 		displayStats(&stats)
+		commit(reader, message)
+	}
+}
+
+func commit(reader *kafka.Reader, message kafka.Message) {
+	if err := reader.CommitMessages(context.Background(), message); err != nil {
+		log.Printf("❌ Ошибка коммита offset: %v", err)
 	}
 }
 
-func displayStats(stats *ErrorStats) {
+func displayStats(stats *events.ErrorStats) {
 	fmt.Printf("\n" + strings.Repeat("=", 70) + "\n")
 	fmt.Printf("📊 СТАТИСТИКА ОШИБОК ЗА ПЕРИОД\n")
 	fmt.Printf("🕐 Период: %s → %s\n", stats.WindowStart, stats.WindowEnd)
+	fmt.Printf("🪟 Окно: %s\n", describeWindow(stats))
 	fmt.Printf("📈 Всего ошибок: %d\n", stats.TotalErrors)
 	fmt.Printf("⏰ Сгенерировано: %s\n", stats.GeneratedAt)
 	fmt.Printf(strings.Repeat("-", 70) + "\n")
@@ -119,9 +129,78 @@ func displayStats(stats *ErrorStats) {
 		}
 	}
 
+	if len(stats.TopKinds) > 0 {
+		fmt.Printf(strings.Repeat("-", 70) + "\n")
+		fmt.Printf("🧬 Топ видов ошибок:\n")
+		for i, kind := range stats.TopKinds {
+			fmt.Printf("   %d. [%s] %3d × %s\n", i+1, kind.Fingerprint, kind.Count, kind.Template)
+		}
+	}
+
+	if len(stats.TopMessages) > 0 {
+		fmt.Printf(strings.Repeat("-", 70) + "\n")
+		fmt.Printf("💬 Частые сообщения (оценка сверху):\n")
+		for i, message := range stats.TopMessages {
+			fmt.Printf("   %d. ~%3d × %s\n", i+1, message.Count, message.Message)
+		}
+
+		services := make([]string, 0, len(stats.TopMessagesByService))
+		for service := range stats.TopMessagesByService {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		for _, service := range services {
+			fmt.Printf("   %s:\n", service)
+			for _, message := range stats.TopMessagesByService[service] {
+				fmt.Printf("      ~%3d × %s\n", message.Count, message.Message)
+			}
+		}
+	}
+
+	if len(stats.Groups) > 0 {
+		fmt.Printf(strings.Repeat("-", 70) + "\n")
+		fmt.Printf("🧮 Группы по %s:\n", strings.Join(stats.Dimensions, ", "))
+		for i, group := range stats.Groups {
+			if i == maxGroupsShown {
+				fmt.Printf("   ... еще %d групп\n", len(stats.Groups)-maxGroupsShown)
+				break
+			}
+			values := make([]string, 0, len(stats.Dimensions))
+			for _, dimension := range stats.Dimensions {
+				values = append(values, group.Key[dimension])
+			}
+			fmt.Printf("   %-40s %4d", strings.Join(values, " / "), group.Count)
+			for _, aggregate := range stats.Aggregates {
+				if value, ok := group.Values[aggregate.Name]; ok {
+					fmt.Printf("  %s=%g", aggregate.Name, value)
+				}
+			}
+			fmt.Printf("\n")
+		}
+		if stats.GroupsLimited {
+			fmt.Printf("   ⚠️  часть групп свернута в %s\n", events.OtherGroup)
+		}
+	}
+
 	fmt.Printf(strings.Repeat("=", 70) + "\n\n")
 }
 
+// Сколько групп показывать в окне
+const maxGroupsShown = 10
+
+// Описание окна; статистика без window_type записана tumbling окнами
+func describeWindow(stats *events.ErrorStats) string {
+	switch stats.WindowType {
+	case events.WindowHopping:
+		return fmt.Sprintf("hopping %s, шаг %s", stats.WindowSize, stats.WindowAdvance)
+	case events.WindowSession:
+		return fmt.Sprintf("session, пауза %s", stats.SessionGap)
+	case "":
+		return events.WindowTumbling
+	}
+	return fmt.Sprintf("%s %s", stats.WindowType, stats.WindowSize)
+}
+
 func generateBar(percentage float64) string {
 	barLength := 20
 	filled := int(percentage / 100.0 * float64(barLength))
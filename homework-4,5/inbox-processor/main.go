@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"events"
 	"fmt"
 	"log"
 	"os"
@@ -16,16 +17,6 @@ import (
 	"github.com/nats-io/nats.go/jetstream"
 )
 
-type ReplicationMessage struct {
-	EventID       uuid.UUID       `json:"event_id"`
-	AggregateID   uuid.UUID       `json:"aggregate_id"`
-	AggregateType string          `json:"aggregate_type"`
-	EventType     string          `json:"event_type"`
-	EventData     json.RawMessage `json:"event_data"`
-	OriginalTime  time.Time       `json:"original_time"`
-	PublishedAt   time.Time       `json:"published_at"`
-}
-
 type InboxEvent struct {
 	ID            uuid.UUID       `json:"id"`
 	EventID       uuid.UUID       `json:"event_id"`
@@ -145,8 +136,8 @@ func processMessageBatch(db *sql.DB, messages []jetstream.Msg) {
 	var paymentMessages []jetstream.Msg
 
 	for _, msg := range messages {
-		var replicationMsg ReplicationMessage
-		if err := json.Unmarshal(msg.Data(), &replicationMsg); err != nil {
+		var replicationMsg events.ReplicationMessage
+		if err := events.Decode(msg.Data(), &replicationMsg); err != nil {
 			log.Printf("❌ Ошибка парсинга для сортировки: %v", err)
 			continue
 		}
@@ -178,8 +169,8 @@ func processMessageBatch(db *sql.DB, messages []jetstream.Msg) {
 
 func processMessage(db *sql.DB, msg jetstream.Msg) bool {
 	// Парсим сообщение
-	var replicationMsg ReplicationMessage
-	err := json.Unmarshal(msg.Data(), &replicationMsg)
+	var replicationMsg events.ReplicationMessage
+	err := events.Decode(msg.Data(), &replicationMsg)
 	if err != nil {
 		log.Printf("❌ Ошибка парсинга сообщения: %v", err)
 		return false // Некорректное сообщение, не переотправляем
@@ -3,6 +3,7 @@ module outbox-publisher
 go 1.23.3
 
 require (
+	events v0.0.0
 	github.com/google/uuid v1.6.0
 	github.com/lib/pq v1.10.9
 	github.com/nats-io/nats.go v1.31.0
@@ -16,3 +17,5 @@ require (
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 )
+
+replace events => ../../events
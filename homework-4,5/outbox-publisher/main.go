@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"events"
 	"fmt"
 	"log"
 	"os"
@@ -25,16 +26,6 @@ type OutboxEvent struct {
 	CreatedAt     time.Time       `json:"created_at"`
 }
 
-type ReplicationMessage struct {
-	EventID       uuid.UUID       `json:"event_id"`
-	AggregateID   uuid.UUID       `json:"aggregate_id"`
-	AggregateType string          `json:"aggregate_type"`
-	EventType     string          `json:"event_type"`
-	EventData     json.RawMessage `json:"event_data"`
-	OriginalTime  time.Time       `json:"original_time"`
-	PublishedAt   time.Time       `json:"published_at"`
-}
-
 func main() {
 	log.Println("📤 Outbox Publisher запускается...")
 
@@ -129,23 +120,23 @@ func main() {
 
 func processOutboxBatch(db *sql.DB, js jetstream.JetStream, subject string, batchSize int) {
 	// Получаем необработанные события из outbox
-	events, err := getUnprocessedEvents(db, batchSize)
+	outboxEvents, err := getUnprocessedEvents(db, batchSize)
 	if err != nil {
 		log.Printf("❌ Ошибка чтения outbox: %v", err)
 		return
 	}
 
-	if len(events) == 0 {
+	if len(outboxEvents) == 0 {
 		return // Нет новых событий
 	}
 
-	log.Printf("📥 Найдено событий для обработки: %d", len(events))
+	log.Printf("📥 Найдено событий для обработки: %d", len(outboxEvents))
 
 	// Обрабатываем события
 	var processedIDs []uuid.UUID
 
-	for _, event := range events {
-		message := ReplicationMessage{
+	for _, event := range outboxEvents {
+		message := events.ReplicationMessage{
 			EventID:       event.ID,
 			AggregateID:   event.AggregateID,
 			AggregateType: event.AggregateType,
@@ -155,7 +146,7 @@ func processOutboxBatch(db *sql.DB, js jetstream.JetStream, subject string, batc
 			PublishedAt:   time.Now(),
 		}
 
-		messageBytes, err := json.Marshal(message)
+		messageBytes, err := events.Encode(&message)
 		if err != nil {
 			log.Printf("❌ Ошибка сериализации события %s: %v", event.ID, err)
 			continue
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"events"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Настройки нагрузочного теста
+type BenchConfig struct {
+	Rate        int           // сообщений в секунду на все потоки; 0 — без ограничения
+	Duration    time.Duration // длительность теста
+	MessageSize int           // размер сообщения в байтах (JSON LogMessage)
+	Concurrency int           // потоков, вызывающих WriteMessages
+	BatchSize   int           // сообщений в одном вызове WriteMessages
+}
+
+// Нагрузочный тест: потоки пишут пачки в Kafka с заданной общей скоростью.
+//
+// Задержка отправки — от момента, когда пачка должна была уйти по
+// расписанию, до подтверждения брокера. Если продюсер не успевает за
+// Rate, ожидание своей очереди тоже попадает в задержку, как у реального
+// приложения; без ограничения скорости — от вызова WriteMessages.
+type Bench struct {
+	config  BenchConfig
+	writer  *kafka.Writer
+	payload string
+
+	scheduled atomic.Int64 // сообщений, которым уже назначено время отправки
+	sent      atomic.Int64
+	bytes     atomic.Int64
+	failed    atomic.Int64
+
+	mu        sync.Mutex
+	errors    map[string]int
+	latencies []time.Duration
+}
+
+func NewBench(config BenchConfig, writer *kafka.Writer) *Bench {
+	b := &Bench{config: config, writer: writer, errors: make(map[string]int)}
+	// Наполнитель подбирается так, чтобы JSON сообщения был около MessageSize
+	empty, _ := events.Encode(b.message(time.Now(), "ERROR", "payment-service"))
+	b.payload = strings.Repeat("x", max(config.MessageSize-len(empty), 0))
+	return b
+}
+
+func (b *Bench) message(now time.Time, level, service string) *events.LogMessage {
+	return &events.LogMessage{
+		Timestamp: events.FormatTime(now),
+		Level:     level,
+		Service:   service,
+		Message:   b.payload,
+	}
+}
+
+// Run выполняет тест и возвращает отчет
+func (b *Bench) Run(ctx context.Context) BenchReport {
+	ctx, cancel := context.WithTimeout(ctx, b.config.Duration)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan struct{})
+	go b.progress(start, done)
+
+	var workers sync.WaitGroup
+	for range b.config.Concurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			b.worker(ctx, start)
+		}()
+	}
+	workers.Wait()
+	close(done)
+	return b.report(time.Since(start))
+}
+
+func (b *Bench) worker(ctx context.Context, start time.Time) {
+	batch := make([]kafka.Message, b.config.BatchSize)
+	for ctx.Err() == nil {
+		// Время отправки пачки по общему расписанию всех потоков
+		sendAt := time.Now()
+		if b.config.Rate > 0 {
+			n := b.scheduled.Add(int64(b.config.BatchSize)) - int64(b.config.BatchSize)
+			sendAt = start.Add(time.Duration(float64(n) / float64(b.config.Rate) * float64(time.Second)))
+			select {
+			case <-time.After(time.Until(sendAt)):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		size := 0
+		for i := range batch {
+			message := b.message(sendAt, getRandomLevel(), getRandomService())
+			value, _ := events.Encode(message)
+			batch[i] = kafka.Message{Key: []byte(message.Service), Value: value}
+			size += len(value)
+		}
+
+		err := b.writer.WriteMessages(ctx, batch...)
+		latency := time.Since(sendAt)
+		if err != nil && ctx.Err() != nil {
+			return // тест закончился во время записи
+		}
+		failed := 0
+		if err != nil {
+			failed = b.recordError(err)
+		}
+		if failed == len(batch) {
+			continue
+		}
+		b.sent.Add(int64(len(batch) - failed))
+		b.bytes.Add(int64(size * (len(batch) - failed) / len(batch)))
+		b.mu.Lock()
+		b.latencies = append(b.latencies, latency)
+		b.mu.Unlock()
+	}
+}
+
+// Учитывает ошибку записи пачки и возвращает число неотправленных сообщений
+func (b *Bench) recordError(err error) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := b.config.BatchSize
+	// WriteErrors — ошибки отдельных сообщений, остальные сообщения записаны
+	if writeErrors, ok := err.(kafka.WriteErrors); ok {
+		failed = 0
+		for _, writeErr := range writeErrors {
+			if writeErr != nil {
+				b.errors[writeErr.Error()]++
+				failed++
+			}
+		}
+	} else {
+		b.errors[err.Error()] += failed
+	}
+	b.failed.Add(int64(failed))
+	return failed
+}
+
+// Промежуточная скорость каждые 5 секунд
+func (b *Bench) progress(start time.Time, done <-chan struct{}) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	last, lastTime := int64(0), start
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			sent := b.sent.Load()
+			log.Printf("Отправлено %d (%.0f сообщ/с), ошибок %d",
+				sent, float64(sent-last)/now.Sub(lastTime).Seconds(), b.failed.Load())
+			last, lastTime = sent, now
+		}
+	}
+}
+
+// Итоги нагрузочного теста
+type BenchReport struct {
+	Config    BenchConfig
+	Elapsed   time.Duration
+	Sent      int64
+	Failed    int64
+	Bytes     int64
+	Errors    map[string]int
+	Latencies map[string]time.Duration // p50, p90, p99, p99.9, max
+}
+
+func (b *Bench) report(elapsed time.Duration) BenchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	report := BenchReport{
+		Config:    b.config,
+		Elapsed:   elapsed,
+		Sent:      b.sent.Load(),
+		Failed:    b.failed.Load(),
+		Bytes:     b.bytes.Load(),
+		Errors:    b.errors,
+		Latencies: make(map[string]time.Duration),
+	}
+	sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+	for _, q := range []struct {
+		name     string
+		quantile float64
+	}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}, {"p99.9", 0.999}, {"max", 1}} {
+		report.Latencies[q.name] = quantile(b.latencies, q.quantile)
+	}
+	return report
+}
+
+// Значение квантиля q отсортированной выборки (ближайший ранг)
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted))*q+0.5) - 1
+	return sorted[min(max(index, 0), len(sorted)-1)]
+}
+
+func (r BenchReport) String() string {
+	seconds := r.Elapsed.Seconds()
+	var s strings.Builder
+	fmt.Fprintf(&s, "\n=== Нагрузочный тест ===\n")
+	fmt.Fprintf(&s, "Скорость:      %s\n", rateLabel(r.Config.Rate))
+	fmt.Fprintf(&s, "Длительность:  %s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&s, "Сообщение:     %d байт, пачка %d, потоков %d\n", r.Config.MessageSize, r.Config.BatchSize, r.Config.Concurrency)
+	fmt.Fprintf(&s, "Отправлено:    %d сообщений, %.1f MB\n", r.Sent, float64(r.Bytes)/1e6)
+	fmt.Fprintf(&s, "Пропускная:    %.0f сообщ/с, %.2f MB/s\n", float64(r.Sent)/seconds, float64(r.Bytes)/1e6/seconds)
+	fmt.Fprintf(&s, "Ошибок:        %d\n", r.Failed)
+	errors := make([]string, 0, len(r.Errors))
+	for err := range r.Errors {
+		errors = append(errors, err)
+	}
+	sort.Slice(errors, func(i, j int) bool { return r.Errors[errors[i]] > r.Errors[errors[j]] })
+	for _, err := range errors {
+		fmt.Fprintf(&s, "  %8d  %s\n", r.Errors[err], err)
+	}
+	fmt.Fprintf(&s, "Задержка отправки пачки:\n")
+	for _, name := range []string{"p50", "p90", "p99", "p99.9", "max"} {
+		fmt.Fprintf(&s, "  %-6s %s\n", name, r.Latencies[name].Round(10*time.Microsecond))
+	}
+	return s.String()
+}
+
+func rateLabel(rate int) string {
+	if rate == 0 {
+		return "без ограничения"
+	}
+	return fmt.Sprintf("%d сообщ/с", rate)
+}
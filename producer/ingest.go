@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"events"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Сколько секунд клиенту ждать перед повтором при 429/503
+const retryAfterSeconds = 1
+
+var (
+	errQueueFull        = errors.New("очередь отправки заполнена")
+	errKafkaUnavailable = errors.New("Kafka недоступна, очередь отправки заполнена")
+	errBatchTooLarge    = errors.New("пачка больше очереди отправки")
+)
+
+// Очередь приема логов от HTTP и syslog. Одна горутина забирает записи
+// пачками и пишет их в Kafka; пока запись не удалась, пачка повторяется,
+// а очередь заполняется — тогда прием отвечает 429 (Kafka медленная)
+// или 503 (запись в Kafka падает с ошибкой).
+type Ingester struct {
+	writer    messageWriter
+	queue     chan kafka.Message
+	batchSize int
+
+	mu          sync.Mutex // резервирование места в очереди под пачку
+	unavailable atomic.Bool
+	accepted    atomic.Int64
+	rejected    atomic.Int64
+	sent        atomic.Int64
+}
+
+func NewIngester(writer messageWriter, queueSize, batchSize int) *Ingester {
+	return &Ingester{
+		writer:    writer,
+		queue:     make(chan kafka.Message, queueSize),
+		batchSize: batchSize,
+	}
+}
+
+// Offer ставит все сообщения в очередь или ни одного. Пачка больше всей
+// очереди не поместится никогда, и повтор ее не поможет.
+func (i *Ingester) Offer(messages []*events.LogMessage) error {
+	records, err := encodeMessages(messages)
+	if err != nil {
+		return err
+	}
+	if len(records) > cap(i.queue) {
+		i.rejected.Add(int64(len(records)))
+		return fmt.Errorf("%w: %d записей при емкости %d", errBatchTooLarge, len(records), cap(i.queue))
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.queue)+len(records) > cap(i.queue) {
+		i.rejected.Add(int64(len(records)))
+		if i.unavailable.Load() {
+			return errKafkaUnavailable
+		}
+		return errQueueFull
+	}
+	for _, record := range records {
+		i.queue <- record
+	}
+	i.accepted.Add(int64(len(records)))
+	return nil
+}
+
+// Put ставит сообщение в очередь, дожидаясь места. Для TCP: пока ждем,
+// соединение не читается, и клиент упирается в окно TCP.
+func (i *Ingester) Put(ctx context.Context, message *events.LogMessage) error {
+	records, err := encodeMessages([]*events.LogMessage{message})
+	if err != nil {
+		return err
+	}
+	for {
+		// Под mu, чтобы не занять место, которое Offer уже отвел под пачку
+		i.mu.Lock()
+		select {
+		case i.queue <- records[0]:
+			i.mu.Unlock()
+			i.accepted.Add(1)
+			return nil
+		default:
+		}
+		i.mu.Unlock()
+
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func encodeMessages(messages []*events.LogMessage) ([]kafka.Message, error) {
+	records := make([]kafka.Message, 0, len(messages))
+	for n, message := range messages {
+		value, err := events.Encode(message)
+		if err != nil {
+			return nil, fmt.Errorf("запись %d: %w", n, err)
+		}
+		records = append(records, kafka.Message{Key: []byte(message.Service), Value: value})
+	}
+	return records, nil
+}
+
+// Run пишет очередь в Kafka до отмены ctx, затем дописывает остаток очереди
+func (i *Ingester) Run(ctx context.Context) {
+	batch := make([]kafka.Message, 0, i.batchSize)
+	for {
+		if ctx.Err() != nil {
+			i.drain()
+			return
+		}
+		select {
+		case record := <-i.queue:
+			batch = append(batch[:0], record)
+		case <-ctx.Done():
+			i.drain()
+			return
+		}
+		// Добираем то, что уже лежит в очереди
+		for len(batch) < i.batchSize && len(i.queue) > 0 {
+			batch = append(batch, <-i.queue)
+		}
+		i.write(ctx, batch)
+	}
+}
+
+// Пишет пачку, повторяя с паузой, пока не получится или не отменят ctx
+func (i *Ingester) write(ctx context.Context, batch []kafka.Message) {
+	backoff := 100 * time.Millisecond
+	for {
+		err := i.writer.WriteMessages(ctx, batch...)
+		if err == nil {
+			if i.unavailable.Swap(false) {
+				log.Printf("Kafka снова доступна")
+			}
+			i.sent.Add(int64(len(batch)))
+			return
+		}
+		if ctx.Err() != nil {
+			// Остановка: пачка допишется в drain
+			i.requeue(batch)
+			return
+		}
+		if !i.unavailable.Swap(true) {
+			log.Printf("Ошибка отправки: %v", err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			i.requeue(batch)
+			return
+		}
+		backoff = min(backoff*2, 5*time.Second)
+	}
+}
+
+// При остановке прием уже закрыт, поэтому место в очереди есть: пачку
+// только что забрали из нее
+func (i *Ingester) requeue(batch []kafka.Message) {
+	pending := make([]kafka.Message, 0, len(batch)+len(i.queue))
+	pending = append(pending, batch...)
+	for len(i.queue) > 0 {
+		pending = append(pending, <-i.queue)
+	}
+	for _, record := range pending {
+		select {
+		case i.queue <- record:
+		default:
+			log.Printf("Запись потеряна при остановке")
+		}
+	}
+}
+
+// Дописывает очередь перед остановкой
+func (i *Ingester) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var pending []kafka.Message
+	for len(i.queue) > 0 {
+		pending = append(pending, <-i.queue)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	if err := i.writer.WriteMessages(ctx, pending...); err != nil {
+		log.Printf("Не отправлено при остановке: %d записей: %v", len(pending), err)
+		return
+	}
+	i.sent.Add(int64(len(pending)))
+}
+
+// Состояние приема для GET /v1/health
+type IngestStatus struct {
+	Kafka    string `json:"kafka"` // ok или unavailable
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Accepted int64  `json:"accepted"`
+	Rejected int64  `json:"rejected"`
+	Sent     int64  `json:"sent"`
+}
+
+func (i *Ingester) Status() IngestStatus {
+	status := IngestStatus{
+		Kafka:    "ok",
+		Queued:   len(i.queue),
+		Capacity: cap(i.queue),
+		Accepted: i.accepted.Load(),
+		Rejected: i.rejected.Load(),
+		Sent:     i.sent.Load(),
+	}
+	if i.unavailable.Load() {
+		status.Kafka = "unavailable"
+	}
+	return status
+}
+
+// HTTP прием логов
+type IngestServer struct {
+	ingester     *Ingester
+	maxBodyBytes int64
+}
+
+func NewIngestServer(ingester *Ingester, maxBodyBytes int64) *IngestServer {
+	return &IngestServer{ingester: ingester, maxBodyBytes: maxBodyBytes}
+}
+
+func (s *IngestServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/logs", s.handleLogs)
+	mux.HandleFunc("GET /v1/health", s.handleHealth)
+	return mux
+}
+
+// POST /v1/logs — один JSON-объект или массив объектов. Поля как у
+// агента: timestamp/time/ts, level/severity, service/app, message/msg.
+// Пачка принимается целиком или отклоняется целиком.
+func (s *IngestServer) handleLogs(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("тело больше %d байт", s.maxBodyBytes))
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	messages, err := parseIngestBody(body, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch err := s.ingester.Offer(messages); {
+	case errors.Is(err, errBatchTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, errQueueFull):
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+		writeError(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, errKafkaUnavailable):
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+		writeError(w, http.StatusServiceUnavailable, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]int{"accepted": len(messages)})
+	}
+}
+
+// GET /v1/health — 200, пока Kafka доступна, иначе 503
+func (s *IngestServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := s.ingester.Status()
+	code := http.StatusOK
+	if status.Kafka != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
+
+// Тело запроса в сообщения; сервис обязателен, уровень по умолчанию INFO
+func parseIngestBody(body []byte, now time.Time) ([]*events.LogMessage, error) {
+	body = bytes.TrimSpace(body)
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var objects []map[string]any
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := decoder.Decode(&objects); err != nil {
+			return nil, err
+		}
+	} else {
+		var object map[string]any
+		if err := decoder.Decode(&object); err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("пустая пачка")
+	}
+
+	messages := make([]*events.LogMessage, 0, len(objects))
+	for n, object := range objects {
+		fields := stringFields(object)
+		if firstField(fields, messageFields, "") == "" {
+			return nil, fmt.Errorf("запись %d: нет поля message", n)
+		}
+		message := toLogMessage(fields, "", "INFO", "", now)
+		if err := message.Validate(); err != nil {
+			return nil, fmt.Errorf("запись %d: %w", n, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Ошибка ответа: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
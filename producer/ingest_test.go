@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newIngestTest(t *testing.T, writer messageWriter, queueSize int, maxBodyBytes int64) (*Ingester, *httptest.Server) {
+	t.Helper()
+	ingester := NewIngester(writer, queueSize, 10)
+	server := httptest.NewServer(NewIngestServer(ingester, maxBodyBytes).Handler())
+	t.Cleanup(server.Close)
+	return ingester, server
+}
+
+// Отправляет тело в POST /v1/logs и возвращает код и разобранный ответ
+func postLogs(t *testing.T, server *httptest.Server, body string) (*http.Response, map[string]any) {
+	t.Helper()
+	response, err := http.Post(server.URL+"/v1/logs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var result map[string]any
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return response, result
+}
+
+func TestIngestAcceptsObjectAndArray(t *testing.T) {
+	ingester, server := newIngestTest(t, &fakeWriter{}, 10, 1<<20)
+
+	response, result := postLogs(t, server, `{"service":"api","level":"error","msg":"boom"}`)
+	if response.StatusCode != http.StatusAccepted || result["accepted"] != 1.0 {
+		t.Fatalf("один объект: %d %v", response.StatusCode, result)
+	}
+	response, result = postLogs(t, server, `[{"service":"api","message":"one"},{"app":"db","message":"two","severity":"warn"}]`)
+	if response.StatusCode != http.StatusAccepted || result["accepted"] != 2.0 {
+		t.Fatalf("массив: %d %v", response.StatusCode, result)
+	}
+
+	status := ingester.Status()
+	if status.Queued != 3 || status.Accepted != 3 || status.Rejected != 0 {
+		t.Errorf("состояние %+v", status)
+	}
+}
+
+func TestIngestRejectsInvalidBody(t *testing.T) {
+	ingester, server := newIngestTest(t, &fakeWriter{}, 10, 1<<20)
+
+	for _, body := range []string{
+		`{"service":"api"`,                  // неверный JSON
+		`[]`,                                // пустая пачка
+		`{"service":"api","level":"ERROR"}`, // нет сообщения
+		`{"message":"boom"}`,                // нет сервиса
+		`[{"service":"api","message":"ok"},{"x":1}]`, // пачка целиком или никак
+	} {
+		if response, result := postLogs(t, server, body); response.StatusCode != http.StatusBadRequest || result["error"] == nil {
+			t.Errorf("%s: %d %v", body, response.StatusCode, result)
+		}
+	}
+	if status := ingester.Status(); status.Queued != 0 {
+		t.Errorf("в очереди %d записей", status.Queued)
+	}
+}
+
+func TestIngestTooLarge(t *testing.T) {
+	_, server := newIngestTest(t, &fakeWriter{}, 2, 100)
+
+	// Тело больше MAX_BODY_BYTES
+	body := `{"service":"api","message":"` + strings.Repeat("x", 200) + `"}`
+	if response, _ := postLogs(t, server, body); response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("большое тело: %d", response.StatusCode)
+	}
+
+	// Пачка больше всей очереди не поместится никогда
+	body = `[{"service":"a","message":"1"},{"service":"a","message":"2"},{"service":"a","message":"3"}]`
+	if response, _ := postLogs(t, server, body); response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("большая пачка: %d", response.StatusCode)
+	}
+}
+
+func TestIngestQueueFull(t *testing.T) {
+	ingester, server := newIngestTest(t, &fakeWriter{}, 3, 1<<20)
+
+	if response, _ := postLogs(t, server, `[{"service":"a","message":"1"},{"service":"a","message":"2"}]`); response.StatusCode != http.StatusAccepted {
+		t.Fatalf("первая пачка: %d", response.StatusCode)
+	}
+
+	// Очередь не разбирается (Kafka медленная): пачка не помещается целиком
+	response, _ := postLogs(t, server, `[{"service":"a","message":"3"},{"service":"a","message":"4"}]`)
+	if response.StatusCode != http.StatusTooManyRequests || response.Header.Get("Retry-After") != "1" {
+		t.Errorf("переполнение: %d, Retry-After %q", response.StatusCode, response.Header.Get("Retry-After"))
+	}
+	if status := ingester.Status(); status.Queued != 2 || status.Rejected != 2 {
+		t.Errorf("состояние %+v", status)
+	}
+}
+
+func TestIngestKafkaUnavailable(t *testing.T) {
+	writer := &fakeWriter{}
+	writer.fail(errors.New("kafka недоступна"))
+	ingester, server := newIngestTest(t, writer, 2, 1<<20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		ingester.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	postLogs(t, server, `{"service":"a","message":"1"}`)
+	waitFor(t, func() bool { return ingester.Status().Kafka == "unavailable" })
+
+	// Запись в Kafka падает, и очередь заполнилась — 503 вместо 429
+	postLogs(t, server, `[{"service":"a","message":"2"},{"service":"a","message":"3"}]`)
+	response, _ := postLogs(t, server, `{"service":"a","message":"4"}`)
+	if response.StatusCode != http.StatusServiceUnavailable || response.Header.Get("Retry-After") != "1" {
+		t.Errorf("Kafka недоступна: %d, Retry-After %q", response.StatusCode, response.Header.Get("Retry-After"))
+	}
+	health, err := http.Get(server.URL + "/v1/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	health.Body.Close()
+	if health.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("health при недоступной Kafka: %d", health.StatusCode)
+	}
+
+	// Kafka вернулась: принятое дописывается без потерь
+	writer.fail(nil)
+	waitFor(t, func() bool { return ingester.Status().Sent == 3 })
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(writer.written(), want) {
+		t.Errorf("записано %q, ожидали %q", writer.written(), want)
+	}
+	if status := ingester.Status(); status.Kafka != "ok" || status.Queued != 0 {
+		t.Errorf("состояние %+v", status)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("условие не выполнилось за 10 секунд")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,24 +2,25 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"events"
+	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
-// Простая структура лога
-type LogMessage struct {
-	Timestamp string `json:"timestamp"`
-	Level     string `json:"level"`
-	Service   string `json:"service"`
-	Message   string `json:"message"`
-}
-
 func main() {
 	// Получаем настройки
 	servers := os.Getenv("KAFKA_BOOTSTRAP_SERVERS")
@@ -37,38 +38,76 @@ func main() {
 		topic = "application-logs"
 	}
 
+	// Режим: random — случайные логи, replay — воспроизведение файлов,
+	// tail — агент, читающий файлы логов, ingest — прием по HTTP и syslog,
+	// bench — нагрузочный тест
+	switch mode := getEnvOrDefault("MODE", "random"); mode {
+	case "random":
+	case "replay":
+		runReplay(brokers, topic)
+		return
+	case "tail":
+		runTail(brokers, topic)
+		return
+	case "ingest":
+		runIngest(brokers, topic)
+		return
+	case "bench":
+		runBench(brokers, topic)
+		return
+	default:
+		log.Fatalf("Неизвестный MODE: %s (random, replay, tail, ingest или bench)", mode)
+	}
+
 	// Создаем подключение к Kafka
 	writer := kafka.NewWriter(kafka.WriterConfig{
 		Brokers: brokers,
 		Topic:   topic,
 	})
 
+	// Сообщения, которые не удалось отправить, ждут Kafka в буфере на диске
+	spill, err := OpenSpillBuffer(
+		getEnvOrDefault("SPILL_DIR", "spill"),
+		int64(getEnvIntOrDefault("SPILL_SEGMENT_BYTES", 16<<20)),
+		int64(getEnvIntOrDefault("SPILL_MAX_BYTES", 1<<30)),
+	)
+	if err != nil {
+		log.Fatalf("Ошибка буфера на диске: %v", err)
+	}
+	defer spill.Close()
+	if stats := spill.Stats(); stats.Depth > 0 {
+		log.Printf("В буфере с прошлого запуска: %d сообщений", stats.Depth)
+	}
+	go serveSpillStats(getEnvOrDefault("HTTP_ADDR", ":8080"), spill)
+
 	log.Printf("Продюсер запущен, отправляем в топик: %s", topic)
 
 	// Бесконечный цикл отправки сообщений
 	for {
 		// Создаем простое сообщение
-		message := LogMessage{
-			Timestamp: time.Now().Format("2006-01-02 15:04:05"),
+		message := events.LogMessage{
+			Timestamp: events.FormatTime(time.Now()),
 			Level:     getRandomLevel(),
 			Service:   getRandomService(),
 			Message:   getRandomMessage(),
 		}
 
 		// Превращаем в JSON
-		messageBytes, err := json.Marshal(message)
+		messageBytes, err := events.Encode(&message)
 		if err != nil {
 			log.Printf("Ошибка JSON: %v", err)
 			continue
 		}
 
-		// Отправляем в Kafka
-		err = writer.WriteMessages(context.Background(), kafka.Message{
+		// Отправляем в Kafka, а если она недоступна — в буфер
+		err = sendWithSpill(writer, spill, kafka.Message{
 			Value: messageBytes,
 		})
 		
 		if err != nil {
 			log.Printf("Ошибка отправки: %v", err)
+		} else if stats := spill.Stats(); stats.Depth > 0 {
+			log.Printf("Kafka недоступна, в буфере %d сообщений, старейшему %.0f с", stats.Depth, stats.OldestAgeSeconds)
 		} else {
 			log.Printf("Отправлено: %s", message.Message)
 		}
@@ -78,6 +117,278 @@ func main() {
 	}
 }
 
+// Отправляет сообщение после уже накопленных в буфере, чтобы сохранить
+// порядок. Если Kafka недоступна, сообщение остается в буфере; ошибка —
+// только когда его не удалось сохранить и оно потеряно.
+func sendWithSpill(writer *kafka.Writer, spill *SpillBuffer, record kafka.Message) error {
+	if spill.Depth() == 0 {
+		if err := writer.WriteMessages(context.Background(), record); err == nil {
+			return nil
+		}
+	}
+	if err := spill.Append([]kafka.Message{record}); err != nil {
+		return err
+	}
+	return drainSpill(writer, spill)
+}
+
+// Отправляет буфер пачками по порядку, пока он не опустеет или Kafka не
+// вернет ошибку. Если Kafka записала часть пачки, пачка уйдет повторно.
+func drainSpill(writer *kafka.Writer, spill *SpillBuffer) error {
+	drained := 0
+	for spill.Depth() > 0 {
+		batch, err := spill.Peek(500)
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteMessages(context.Background(), batch...); err != nil {
+			return nil // остаются в буфере до следующей попытки
+		}
+		if err := spill.Commit(); err != nil {
+			return err
+		}
+		drained += len(batch)
+	}
+	if drained > 0 {
+		log.Printf("Kafka доступна, из буфера отправлено %d сообщений", drained)
+	}
+	return nil
+}
+
+// GET /v1/spill — глубина буфера и возраст старейшего сообщения
+func serveSpillStats(addr string, spill *SpillBuffer) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/spill", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, spill.Stats())
+	})
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Ошибка HTTP: %v", err)
+	}
+}
+
+// Воспроизведение файлов логов из REPLAY_PATH
+func runReplay(brokers []string, topic string) {
+	paths, err := ExpandReplayPaths(os.Getenv("REPLAY_PATH"))
+	if err != nil {
+		log.Fatalf("Ошибка REPLAY_PATH: %v", err)
+	}
+	config := ReplayConfig{
+		Paths:      paths,
+		Format:     getEnvOrDefault("REPLAY_FORMAT", "auto"),
+		Speed:      getEnvFloatOrDefault("REPLAY_SPEED", 1),
+		Timestamps: getEnvOrDefault("REPLAY_TIMESTAMPS", "shift"),
+		Loop:       getEnvOrDefault("REPLAY_LOOP", "false") == "true",
+		BatchSize:  getEnvIntOrDefault("REPLAY_BATCH_SIZE", 500),
+	}
+	if config.Format != "auto" && config.Format != "ndjson" && config.Format != "text" {
+		log.Fatalf("Неизвестный REPLAY_FORMAT: %s (auto, ndjson или text)", config.Format)
+	}
+	if config.Timestamps != "shift" && config.Timestamps != "original" {
+		log.Fatalf("Неизвестный REPLAY_TIMESTAMPS: %s (shift или original)", config.Timestamps)
+	}
+	if config.Speed < 0 {
+		log.Fatalf("REPLAY_SPEED не может быть отрицательным: %g", config.Speed)
+	}
+	if config.BatchSize <= 0 {
+		log.Fatalf("REPLAY_BATCH_SIZE должен быть положительным: %d", config.BatchSize)
+	}
+
+	writer := newKeyedWriter(brokers, topic, config.BatchSize)
+	defer writer.Close()
+
+	log.Printf("Воспроизведение %d файлов в топик %s, скорость %g, время %s",
+		len(paths), topic, config.Speed, config.Timestamps)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := NewReplayer(config, writer).Run(ctx); err != nil {
+		log.Fatalf("Ошибка воспроизведения: %v", err)
+	}
+}
+
+// Агент: отправляет новые строки файлов из TAIL_PATHS
+func runTail(brokers []string, topic string) {
+	var patterns []string
+	for _, pattern := range strings.Split(os.Getenv("TAIL_PATHS"), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.Fatalf("Ошибка TAIL_PATHS: %s: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) == 0 {
+		log.Fatalf("TAIL_PATHS не задан")
+	}
+
+	parser, err := NewLineParser(getEnvOrDefault("TAIL_PARSER", "json"), os.Getenv("TAIL_REGEX"))
+	if err != nil {
+		log.Fatalf("Ошибка парсера: %v", err)
+	}
+	start := getEnvOrDefault("TAIL_START", "end")
+	if start != "end" && start != "beginning" {
+		log.Fatalf("Неизвестный TAIL_START: %s (end или beginning)", start)
+	}
+	pollInterval := time.Duration(getEnvIntOrDefault("TAIL_POLL_INTERVAL_MS", 500)) * time.Millisecond
+	if pollInterval <= 0 {
+		log.Fatalf("TAIL_POLL_INTERVAL_MS должен быть положительным")
+	}
+
+	config := TailConfig{
+		Patterns:       patterns,
+		Parser:         parser,
+		DefaultLevel:   getEnvOrDefault("TAIL_LEVEL", "INFO"),
+		DefaultService: os.Getenv("TAIL_SERVICE"),
+		Checkpoint:     getEnvOrDefault("TAIL_CHECKPOINT", "checkpoint.json"),
+		PollInterval:   pollInterval,
+		FromEnd:        start == "end",
+	}
+	writer := newKeyedWriter(brokers, topic, 1000)
+	defer writer.Close()
+	tailer, err := NewTailer(config, writer)
+	if err != nil {
+		log.Fatalf("Ошибка загрузки позиций: %v", err)
+	}
+
+	log.Printf("Агент запущен: %v → %s, парсер %s", patterns, topic, getEnvOrDefault("TAIL_PARSER", "json"))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tailer.Run(ctx)
+}
+
+// Прием логов по HTTP (POST /v1/logs) и syslog (UDP и TCP)
+func runIngest(brokers []string, topic string) {
+	httpAddr := getEnvOrDefault("HTTP_ADDR", ":8080")
+	udpAddr := os.Getenv("SYSLOG_UDP_ADDR") // пусто — не слушать
+	tcpAddr := os.Getenv("SYSLOG_TCP_ADDR")
+	queueSize := getEnvIntOrDefault("INGEST_QUEUE_SIZE", 10000)
+	batchSize := getEnvIntOrDefault("INGEST_BATCH_SIZE", 500)
+	maxBodyBytes := getEnvIntOrDefault("INGEST_MAX_BODY_BYTES", 1<<20)
+	if queueSize <= 0 || batchSize <= 0 || maxBodyBytes <= 0 {
+		log.Fatalf("INGEST_QUEUE_SIZE, INGEST_BATCH_SIZE и INGEST_MAX_BODY_BYTES должны быть положительными")
+	}
+
+	writer := newKeyedWriter(brokers, topic, batchSize)
+	defer writer.Close()
+	ingester := NewIngester(writer, queueSize, batchSize)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Запись в Kafka останавливается последней, чтобы дописать очередь
+	writerCtx, stopWriter := context.WithCancel(context.Background())
+	writerDone := make(chan struct{})
+	go func() {
+		ingester.Run(writerCtx)
+		close(writerDone)
+	}()
+
+	syslog := NewSyslogServer(ingester)
+	var listeners []io.Closer
+	if udpAddr != "" {
+		conn, err := net.ListenPacket("udp", udpAddr)
+		if err != nil {
+			log.Fatalf("Ошибка syslog UDP: %v", err)
+		}
+		listeners = append(listeners, conn)
+		go syslog.ServeUDP(ctx, conn)
+		log.Printf("Syslog UDP: %s", udpAddr)
+	}
+	if tcpAddr != "" {
+		listener, err := net.Listen("tcp", tcpAddr)
+		if err != nil {
+			log.Fatalf("Ошибка syslog TCP: %v", err)
+		}
+		listeners = append(listeners, listener)
+		go syslog.ServeTCP(ctx, listener)
+		log.Printf("Syslog TCP: %s", tcpAddr)
+	}
+
+	server := &http.Server{Addr: httpAddr, Handler: NewIngestServer(ingester, int64(maxBodyBytes)).Handler()}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Ошибка HTTP: %v", err)
+		}
+	}()
+	log.Printf("Прием логов запущен: HTTP %s → %s, очередь %d", httpAddr, topic, queueSize)
+
+	<-ctx.Done()
+	log.Printf("Остановка приема")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	stopWriter()
+	<-writerDone
+
+	status := ingester.Status()
+	log.Printf("Прием остановлен: принято %d, отклонено %d, отправлено %d", status.Accepted, status.Rejected, status.Sent)
+}
+
+// Нагрузочный тест: отчет о пропускной способности и задержках
+func runBench(brokers []string, topic string) {
+	config := BenchConfig{
+		Rate:        getEnvIntOrDefault("MESSAGES_PER_SECOND", 0),
+		Duration:    time.Duration(getEnvIntOrDefault("DURATION_SECONDS", 60)) * time.Second,
+		MessageSize: getEnvIntOrDefault("BENCH_MESSAGE_SIZE", 512),
+		Concurrency: getEnvIntOrDefault("BENCH_CONCURRENCY", 4),
+		BatchSize:   getEnvIntOrDefault("BENCH_BATCH_SIZE", 100),
+	}
+	if config.Rate < 0 || config.Duration <= 0 || config.MessageSize <= 0 || config.Concurrency <= 0 || config.BatchSize <= 0 {
+		log.Fatalf("Неверные настройки теста: MESSAGES_PER_SECOND ≥ 0, остальные должны быть положительными")
+	}
+
+	compressionName := getEnvOrDefault("BENCH_COMPRESSION", "none")
+	compression, ok := map[string]kafka.Compression{
+		"none": 0, "gzip": kafka.Gzip, "snappy": kafka.Snappy, "lz4": kafka.Lz4, "zstd": kafka.Zstd,
+	}[compressionName]
+	if !ok {
+		log.Fatalf("Неизвестный BENCH_COMPRESSION: %s (none, gzip, snappy, lz4, zstd)", compressionName)
+	}
+	acksName := getEnvOrDefault("BENCH_ACKS", "all")
+	acks, ok := map[string]kafka.RequiredAcks{
+		"0": kafka.RequireNone, "1": kafka.RequireOne, "all": kafka.RequireAll,
+	}[acksName]
+	if !ok {
+		log.Fatalf("Неизвестный BENCH_ACKS: %s (0, 1 или all)", acksName)
+	}
+
+	writer := newKeyedWriter(brokers, topic, config.BatchSize)
+	writer.BatchTimeout = time.Duration(getEnvIntOrDefault("BENCH_BATCH_TIMEOUT_MS", 10)) * time.Millisecond
+	writer.Compression = compression
+	writer.RequiredAcks = acks
+	defer writer.Close()
+
+	log.Printf("Нагрузочный тест: %s, %s, сообщение %d байт, потоков %d, пачка %d, сжатие %s, acks %s → %s",
+		rateLabel(config.Rate), config.Duration, config.MessageSize, config.Concurrency, config.BatchSize,
+		compressionName, acksName, topic)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Print(NewBench(config, writer).Run(ctx))
+}
+
+// Запись пачки сообщений в Kafka: *kafka.Writer или заглушка в тестах
+type messageWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+}
+
+// Writer с ключом-сервисом: записи сервиса идут в одну партицию по порядку.
+// Пачки собирает вызывающий, поэтому ждать заполнения пачки незачем.
+func newKeyedWriter(brokers []string, topic string, batchSize int) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    batchSize,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+}
+
 func getRandomLevel() string {
 	levels := []string{"INFO", "WARN", "ERROR"}
 	return levels[rand.Intn(len(levels))]
@@ -98,3 +409,26 @@ func getRandomMessage() string {
 	}
 	return messages[rand.Intn(len(messages))]
 }
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvFloatOrDefault(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"events"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Разбирает строку лога в поля: json, logfmt или regex
+type LineParser func(line string) (map[string]string, error)
+
+// Имена полей, из которых берутся части LogMessage (первое найденное)
+var (
+	timestampFields = []string{"timestamp", "time", "ts", "@timestamp"}
+	levelFields     = []string{"level", "lvl", "severity"}
+	serviceFields   = []string{"service", "app"}
+	messageFields   = []string{"message", "msg"}
+)
+
+// NewLineParser возвращает парсер по TAIL_PARSER. Для regex в pattern
+// именованные группы timestamp, level, service и message.
+func NewLineParser(kind, pattern string) (LineParser, error) {
+	switch kind {
+	case "json":
+		return parseJSONLine, nil
+	case "logfmt":
+		return parseLogfmtLine, nil
+	case "regex":
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("TAIL_REGEX: %w", err)
+		}
+		if re.SubexpIndex("message") < 0 {
+			return nil, fmt.Errorf("TAIL_REGEX: нет группы (?P<message>...)")
+		}
+		return regexLineParser(re), nil
+	}
+	return nil, fmt.Errorf("неизвестный TAIL_PARSER: %s (json, logfmt или regex)", kind)
+}
+
+func parseJSONLine(line string) (map[string]string, error) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return stringFields(raw), nil
+}
+
+// Значения JSON-объекта строками; числа — как в исходном JSON при UseNumber
+func stringFields(raw map[string]any) map[string]string {
+	fields := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if s, ok := value.(string); ok {
+			fields[key] = s
+		} else if value != nil {
+			fields[key] = fmt.Sprint(value)
+		}
+	}
+	return fields
+}
+
+// logfmt: key=value key="значение с пробелами" flag
+func parseLogfmtLine(line string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, fmt.Errorf("пустой ключ в позиции %d", start)
+		}
+		if i >= len(line) || line[i] != '=' {
+			fields[key] = "true"
+			continue
+		}
+		i++
+
+		if i < len(line) && line[i] == '"' {
+			var value bytes.Buffer
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						value.WriteByte('\n')
+					case 't':
+						value.WriteByte('\t')
+					default:
+						value.WriteByte(line[i])
+					}
+					continue
+				}
+				value.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("незакрытая кавычка у ключа %s", key)
+			}
+			i++
+			fields[key] = value.String()
+			continue
+		}
+
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("нет ни одного поля")
+	}
+	return fields, nil
+}
+
+func regexLineParser(re *regexp.Regexp) LineParser {
+	return func(line string) (map[string]string, error) {
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("строка не подходит под TAIL_REGEX")
+		}
+		fields := make(map[string]string)
+		for i, name := range re.SubexpNames() {
+			if name != "" && match[i] != "" {
+				fields[name] = match[i]
+			}
+		}
+		return fields, nil
+	}
+}
+
+// Собирает LogMessage из полей строки. Чего нет в строке, берется из
+// значений по умолчанию: уровень и сервис из настроек, время — текущее.
+func toLogMessage(fields map[string]string, line, defaultLevel, defaultService string, now time.Time) *events.LogMessage {
+	message := &events.LogMessage{
+		Timestamp: events.FormatTime(now),
+		Level:     normalizeLevel(firstField(fields, levelFields, defaultLevel)),
+		Service:   firstField(fields, serviceFields, defaultService),
+		Message:   firstField(fields, messageFields, line),
+	}
+	if value := firstField(fields, timestampFields, ""); value != "" {
+		if t, err := parseReplayTime(value); err == nil {
+			message.Timestamp = events.FormatTime(t)
+		}
+	}
+	return message
+}
+
+func firstField(fields map[string]string, names []string, defaultValue string) string {
+	for _, name := range names {
+		if value := fields[name]; value != "" {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+// Уровни к виду INFO/WARN/ERROR, который понимают consumer и mapper
+func normalizeLevel(level string) string {
+	level = strings.ToUpper(level)
+	switch level {
+	case "WARNING":
+		return "WARN"
+	case "ERR":
+		return "ERROR"
+	}
+	return level
+}
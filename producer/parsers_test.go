@@ -0,0 +1,112 @@
+package main
+
+import (
+	"events"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseJSONLine(t *testing.T) {
+	fields, err := parseJSONLine(`{"level":"error","service":"api","msg":"boom","latency_ms":1234567890123,"ok":false,"extra":null}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"level": "error", "service": "api", "msg": "boom", "latency_ms": "1234567890123", "ok": "false"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("получили %v, ожидали %v", fields, want)
+	}
+
+	if _, err := parseJSONLine(`not json`); err == nil {
+		t.Error("строка не JSON разобрана без ошибки")
+	}
+}
+
+func TestParseLogfmtLine(t *testing.T) {
+	fields, err := parseLogfmtLine(`level=warn  service=billing msg="payment \"retry\" failed\tagain" cached	attempt=3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"level":   "warn",
+		"service": "billing",
+		"msg":     "payment \"retry\" failed\tagain",
+		"cached":  "true",
+		"attempt": "3",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("получили %v, ожидали %v", fields, want)
+	}
+
+	for _, line := range []string{`msg="unterminated`, `=value`, `   `} {
+		if _, err := parseLogfmtLine(line); err == nil {
+			t.Errorf("%q разобрана без ошибки", line)
+		}
+	}
+}
+
+func TestRegexLineParser(t *testing.T) {
+	parse, err := NewLineParser("regex", `^(?P<timestamp>\S+) \[(?P<level>\w+)\] (?:(?P<service>[\w-]+): )?(?P<message>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := parse("2024-05-01T10:00:00Z [ERROR] auth: token expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"timestamp": "2024-05-01T10:00:00Z", "level": "ERROR", "service": "auth", "message": "token expired"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("получили %v, ожидали %v", fields, want)
+	}
+
+	// Необязательная группа, которая не совпала, в поля не попадает
+	fields, err = parse("2024-05-01T10:00:00Z [INFO] started")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["service"]; ok || fields["message"] != "started" {
+		t.Errorf("поля %v", fields)
+	}
+
+	if _, err := parse("garbage"); err == nil {
+		t.Error("неподходящая строка разобрана без ошибки")
+	}
+}
+
+func TestNewLineParserRejectsInvalidSettings(t *testing.T) {
+	for _, c := range []struct{ kind, pattern string }{
+		{"csv", ""},
+		{"regex", `(?P<level>\w+`},
+		{"regex", `(?P<level>\w+) (?P<msg>.*)`}, // нет группы message
+	} {
+		if _, err := NewLineParser(c.kind, c.pattern); err == nil {
+			t.Errorf("TAIL_PARSER=%s TAIL_REGEX=%q приняты", c.kind, c.pattern)
+		}
+	}
+}
+
+func TestToLogMessage(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+
+	message := toLogMessage(map[string]string{"severity": "warning", "app": "billing", "msg": "slow"}, "raw", "INFO", "tail", now)
+	if message.Level != "WARN" || message.Service != "billing" || message.Message != "slow" || message.Timestamp != events.FormatTime(now) {
+		t.Errorf("получили %+v", message)
+	}
+
+	// Строка без полей: уровень и сервис по умолчанию, сообщение — вся строка
+	message = toLogMessage(nil, "raw line", "info", "tail", now)
+	if message.Level != "INFO" || message.Service != "tail" || message.Message != "raw line" {
+		t.Errorf("получили %+v", message)
+	}
+
+	// Время из строки заменяет текущее, нераспознанное игнорируется
+	message = toLogMessage(map[string]string{"ts": "2024-04-30T08:15:00Z", "level": "err"}, "raw", "INFO", "tail", now)
+	if message.Level != "ERROR" || message.Timestamp == events.FormatTime(now) {
+		t.Errorf("получили %+v", message)
+	}
+	message = toLogMessage(map[string]string{"ts": "yesterday"}, "raw", "INFO", "tail", now)
+	if message.Timestamp != events.FormatTime(now) {
+		t.Errorf("время %q", message.Timestamp)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"events"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Настройки воспроизведения файлов
+type ReplayConfig struct {
+	Paths      []string // файлы в порядке воспроизведения
+	Format     string   // auto, ndjson или text
+	Speed      float64  // 1 — исходный темп, 10 — в 10 раз быстрее, 0 — без пауз
+	Timestamps string   // shift — сдвинуть к текущему времени, original — оставить
+	Loop       bool     // повторять файлы по кругу
+	BatchSize  int      // записей в одной отправке
+}
+
+// Строка текстового лога: "<время> <уровень> <сервис> <сообщение>"
+var textLine = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}\S*)\s+([A-Za-z]+)\s+(\S+)\s+(.*)$`)
+
+// ExpandReplayPaths раскрывает список путей и шаблонов через запятую
+func ExpandReplayPaths(patterns string) ([]string, error) {
+	var paths []string
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("нет файлов по пути %s", pattern)
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("REPLAY_PATH не задан")
+	}
+	return paths, nil
+}
+
+// Воспроизведение: исходные интервалы между записями сохраняются с учетом
+// скорости. Время отправки каждой записи считается от начала воспроизведения,
+// поэтому задержки Kafka не накапливаются.
+type Replayer struct {
+	config ReplayConfig
+	writer *kafka.Writer
+
+	started   time.Time // начало текущего прохода
+	first     time.Time // время первой записи прохода
+	last      time.Time // время предыдущей записи
+	sent      int
+	skipped   int
+	batch     []kafka.Message
+	batchTime time.Time
+}
+
+func NewReplayer(config ReplayConfig, writer *kafka.Writer) *Replayer {
+	return &Replayer{config: config, writer: writer}
+}
+
+func (r *Replayer) Run(ctx context.Context) error {
+	for pass := 1; ; pass++ {
+		r.started, r.first, r.last = time.Now(), time.Time{}, time.Time{}
+		for _, path := range r.config.Paths {
+			if err := r.replayFile(ctx, path); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		if err := r.flush(ctx); err != nil {
+			return err
+		}
+		log.Printf("Проход %d завершен: отправлено %d, пропущено %d, за %s",
+			pass, r.sent, r.skipped, time.Since(r.started).Round(time.Millisecond))
+		if !r.config.Loop {
+			return nil
+		}
+	}
+}
+
+func (r *Replayer) replayFile(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := decompress(file)
+	if err != nil {
+		return err
+	}
+	log.Printf("Воспроизводим %s", path)
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		message, eventTime, err := r.parse(line)
+		if err != nil {
+			log.Printf("%s:%d пропущена: %v", path, lineNumber, err)
+			r.skipped++
+			continue
+		}
+		if err := r.schedule(ctx, message, eventTime); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// gzip определяется по сигнатуре, а не по расширению
+func decompress(file *os.File) (io.Reader, error) {
+	buffered := bufio.NewReader(file)
+	magic, err := buffered.Peek(2)
+	if err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// Разбирает строку в LogMessage и возвращает ее исходное время
+func (r *Replayer) parse(line string) (*events.LogMessage, time.Time, error) {
+	var message events.LogMessage
+	switch {
+	case r.config.Format == "ndjson" || (r.config.Format == "auto" && strings.HasPrefix(line, "{")):
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			return nil, time.Time{}, err
+		}
+	default:
+		parts := textLine.FindStringSubmatch(line)
+		if parts == nil {
+			return nil, time.Time{}, fmt.Errorf("строка не в формате \"<время> <уровень> <сервис> <сообщение>\"")
+		}
+		message = events.LogMessage{Timestamp: parts[1], Level: strings.ToUpper(parts[2]), Service: parts[3], Message: parts[4]}
+	}
+
+	eventTime, err := parseReplayTime(message.Timestamp)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &message, eventTime, nil
+}
+
+// Время в общем формате сообщений или RFC 3339
+func parseReplayTime(value string) (time.Time, error) {
+	if t, err := events.ParseTime(value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.Local(), nil
+	}
+	return time.Time{}, fmt.Errorf("не удалось разобрать время %q", value)
+}
+
+// Ждет момента отправки записи и добавляет ее в пачку
+func (r *Replayer) schedule(ctx context.Context, message *events.LogMessage, eventTime time.Time) error {
+	if r.first.IsZero() {
+		r.first = eventTime
+	}
+	// Записи не по порядку отправляются сразу после предыдущей
+	if eventTime.Before(r.last) {
+		eventTime = r.last
+	}
+	r.last = eventTime
+
+	offset := eventTime.Sub(r.first)
+	if r.config.Speed > 0 {
+		offset = time.Duration(float64(offset) / r.config.Speed)
+	}
+	sendAt := r.started.Add(offset)
+
+	// Время из файла приводится к общему формату сообщений
+	message.Timestamp = events.FormatTime(eventTime)
+	if r.config.Timestamps == "shift" {
+		// Время события совпадает с моментом отправки, и окна aggregator'а
+		// получают запись вовремя, а не как опоздавшую. При REPLAY_SPEED=0
+		// исходные интервалы сохраняются только во времени событий.
+		message.Timestamp = events.FormatTime(sendAt)
+	}
+	value, err := events.Encode(message)
+	if err != nil {
+		log.Printf("Запись %s пропущена: %v", message.Timestamp, err)
+		r.skipped++
+		return nil
+	}
+
+	if r.config.Speed > 0 {
+		if wait := time.Until(sendAt); wait > 0 {
+			// Перед паузой отправляем то, что уже пора отправить
+			if err := r.flush(ctx); err != nil {
+				return err
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	r.batch = append(r.batch, kafka.Message{Key: []byte(message.Service), Value: value})
+	r.batchTime = eventTime
+	if len(r.batch) >= r.config.BatchSize {
+		return r.flush(ctx)
+	}
+	return nil
+}
+
+func (r *Replayer) flush(ctx context.Context) error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+	if err := r.writer.WriteMessages(ctx, r.batch...); err != nil {
+		return fmt.Errorf("ошибка отправки: %w", err)
+	}
+	r.sent += len(r.batch)
+	log.Printf("Отправлено %d (всего %d), исходное время %s",
+		len(r.batch), r.sent, events.FormatTime(r.batchTime))
+	r.batch = r.batch[:0]
+	return nil
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Заголовок записи: длина и CRC32
+const spillHeaderSize = 4 + 4
+
+// Запись больше этого размера при чтении считается поврежденной
+const maxSpillRecord = 16 << 20
+
+var errSpillFull = errors.New("буфер на диске заполнен")
+
+// Позиция чтения: сегмент и смещение в нем
+type spillCursor struct {
+	Segment int64 `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// Буфер сообщений на диске на время недоступности Kafka.
+//
+// Сообщения дописываются в сегменты <номер>.seg; сегмент закрывается, когда
+// превышает segmentBytes. Позиция чтения хранится в файле cursor и
+// сдвигается только после успешной записи в Kafka (Commit), поэтому после
+// перезапуска отправка продолжается с первого неотправленного сообщения.
+// Прочитанные сегменты удаляются. Запись, оборванная при сбое, и все после
+// нее отбрасываются при открытии.
+type SpillBuffer struct {
+	dir          string
+	segmentBytes int64
+	maxBytes     int64
+
+	mu       sync.Mutex
+	segments []int64 // номера сегментов по возрастанию
+	head     spillCursor
+	next     spillCursor // позиция после записей последнего Peek
+	peeked   int
+	file     *os.File // последний сегмент, открыт на дозапись
+	fileSize int64
+	lock     *os.File // блокировка каталога от второго процесса
+
+	depth   int       // неотправленных сообщений
+	bytes   int64     // неотправленных байт
+	oldest  time.Time // время постановки первого неотправленного
+	dropped int64     // отброшено из-за переполнения
+}
+
+// Состояние буфера для логов и HTTP
+type SpillStats struct {
+	Depth            int     `json:"depth"`
+	Bytes            int64   `json:"bytes"`
+	MaxBytes         int64   `json:"max_bytes"`
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+	Dropped          int64   `json:"dropped"`
+}
+
+func OpenSpillBuffer(dir string, segmentBytes, maxBytes int64) (*SpillBuffer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	b := &SpillBuffer{dir: dir, segmentBytes: segmentBytes, maxBytes: maxBytes}
+
+	// Два продюсера в одном каталоге испортили бы сегменты друг друга
+	lock, err := os.OpenFile(filepath.Join(dir, "lock"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("каталог %s занят другим процессом: %w", dir, err)
+	}
+	b.lock = lock
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".seg")
+		if !ok {
+			continue
+		}
+		if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+			b.segments = append(b.segments, id)
+		}
+	}
+	sort.Slice(b.segments, func(i, j int) bool { return b.segments[i] < b.segments[j] })
+
+	if err := b.open(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *SpillBuffer) open() error {
+	if err := b.loadCursor(); err != nil {
+		return err
+	}
+	if err := b.recover(); err != nil {
+		return err
+	}
+	if err := b.openLast(); err != nil {
+		return err
+	}
+	b.next = b.head
+	return nil
+}
+
+func (b *SpillBuffer) segmentPath(id int64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d.seg", id))
+}
+
+func (b *SpillBuffer) loadCursor() error {
+	data, err := os.ReadFile(filepath.Join(b.dir, "cursor"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if len(b.segments) > 0 {
+			b.head = spillCursor{Segment: b.segments[0]}
+		}
+		return nil
+	case err != nil:
+		return err
+	}
+	return json.Unmarshal(data, &b.head)
+}
+
+// Удаляет прочитанные сегменты, считает неотправленное и обрезает
+// оборванную запись в конце
+func (b *SpillBuffer) recover() error {
+	segments := b.segments[:0]
+	for _, id := range b.segments {
+		if id < b.head.Segment {
+			if err := os.Remove(b.segmentPath(id)); err != nil {
+				return err
+			}
+			continue
+		}
+		segments = append(segments, id)
+	}
+	b.segments = segments
+
+	for i, id := range b.segments {
+		offset := int64(0)
+		if id == b.head.Segment {
+			offset = b.head.Offset
+		}
+		valid, err := b.scan(id, offset)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(b.segmentPath(id))
+		if err != nil {
+			return err
+		}
+		if valid < info.Size() {
+			// Все после поврежденной записи недостоверно
+			log.Printf("Буфер: сегмент %d поврежден с позиции %d, остаток отброшен", id, valid)
+			if err := os.Truncate(b.segmentPath(id), valid); err != nil {
+				return err
+			}
+			for _, later := range b.segments[i+1:] {
+				os.Remove(b.segmentPath(later))
+			}
+			b.segments = b.segments[:i+1]
+			return nil
+		}
+	}
+	return nil
+}
+
+// Считает записи сегмента с offset; возвращает конец последней целой записи
+func (b *SpillBuffer) scan(id, offset int64) (int64, error) {
+	file, err := os.Open(b.segmentPath(id))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(file)
+	for {
+		_, queued, size, err := readSpillRecord(reader)
+		if err != nil {
+			return offset, nil
+		}
+		if b.depth == 0 {
+			b.oldest = queued
+		}
+		b.depth++
+		b.bytes += size
+		offset += size
+	}
+}
+
+func (b *SpillBuffer) openLast() error {
+	if len(b.segments) == 0 {
+		b.segments = []int64{b.head.Segment}
+	}
+	id := b.segments[len(b.segments)-1]
+	file, err := os.OpenFile(b.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	b.file, b.fileSize = file, info.Size()
+	return nil
+}
+
+// Append дописывает сообщения и сбрасывает их на диск. Если буфер
+// заполнен, сообщения отбрасываются целиком и возвращается errSpillFull.
+func (b *SpillBuffer) Append(messages []kafka.Message) error {
+	now := time.Now()
+	var data []byte
+	for _, message := range messages {
+		data = appendSpillRecord(data, message, now)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bytes+int64(len(data)) > b.maxBytes {
+		b.dropped += int64(len(messages))
+		return errSpillFull
+	}
+
+	if b.fileSize > 0 && b.fileSize+int64(len(data)) > b.segmentBytes {
+		if err := b.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := b.file.Write(data); err != nil {
+		return err
+	}
+	if err := b.file.Sync(); err != nil {
+		return err
+	}
+	b.fileSize += int64(len(data))
+
+	if b.depth == 0 {
+		b.oldest = now
+	}
+	b.depth += len(messages)
+	b.bytes += int64(len(data))
+	return nil
+}
+
+// Закрывает текущий сегмент и начинает следующий
+func (b *SpillBuffer) rotate() error {
+	if err := b.file.Close(); err != nil {
+		return err
+	}
+	id := b.segments[len(b.segments)-1] + 1
+	file, err := os.OpenFile(b.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	b.segments = append(b.segments, id)
+	b.file, b.fileSize = file, 0
+	return nil
+}
+
+// Peek возвращает до limit первых неотправленных сообщений, не сдвигая
+// позицию чтения
+func (b *SpillBuffer) Peek(limit int) ([]kafka.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []kafka.Message
+	position := b.head
+	for len(messages) < limit && len(messages) < b.depth {
+		read, next, err := b.readFrom(position, limit-len(messages))
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, read...)
+		if next == position {
+			break
+		}
+		position = next
+	}
+	b.next, b.peeked = position, len(messages)
+	return messages, nil
+}
+
+// Читает записи одного сегмента с позиции; в конце сегмента переходит к следующему
+func (b *SpillBuffer) readFrom(position spillCursor, limit int) ([]kafka.Message, spillCursor, error) {
+	file, err := os.Open(b.segmentPath(position.Segment))
+	if err != nil {
+		return nil, position, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(position.Offset, io.SeekStart); err != nil {
+		return nil, position, err
+	}
+
+	reader := bufio.NewReader(file)
+	var messages []kafka.Message
+	for len(messages) < limit {
+		message, _, size, err := readSpillRecord(reader)
+		if errors.Is(err, io.EOF) {
+			if last := b.segments[len(b.segments)-1]; position.Segment < last {
+				return messages, spillCursor{Segment: b.nextSegment(position.Segment)}, nil
+			}
+			break
+		}
+		if err != nil {
+			return nil, position, err
+		}
+		messages = append(messages, message)
+		position.Offset += size
+	}
+	return messages, position, nil
+}
+
+func (b *SpillBuffer) nextSegment(id int64) int64 {
+	for _, segment := range b.segments {
+		if segment > id {
+			return segment
+		}
+	}
+	return id
+}
+
+// Commit отмечает сообщения последнего Peek отправленными
+func (b *SpillBuffer) Commit() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.peeked == 0 {
+		return nil
+	}
+
+	if err := b.saveCursor(b.next); err != nil {
+		return err
+	}
+	consumed := b.consumedBytes(b.head, b.next)
+	b.head = b.next
+	b.depth -= b.peeked
+	b.bytes -= consumed
+	b.peeked = 0
+
+	// Полностью прочитанные сегменты больше не нужны
+	for len(b.segments) > 1 && b.segments[0] < b.head.Segment {
+		if err := os.Remove(b.segmentPath(b.segments[0])); err != nil {
+			log.Printf("Буфер: не удалось удалить сегмент %d: %v", b.segments[0], err)
+		}
+		b.segments = b.segments[1:]
+	}
+
+	b.oldest = time.Time{}
+	if b.depth > 0 {
+		b.oldest = b.headTime()
+	}
+	return nil
+}
+
+// Байт между двумя позициями: хвосты пройденных сегментов и начало текущего
+func (b *SpillBuffer) consumedBytes(from, to spillCursor) int64 {
+	if from.Segment == to.Segment {
+		return to.Offset - from.Offset
+	}
+	total := to.Offset
+	for _, id := range b.segments {
+		if id < from.Segment || id >= to.Segment {
+			continue
+		}
+		if info, err := os.Stat(b.segmentPath(id)); err == nil {
+			total += info.Size()
+		}
+		if id == from.Segment {
+			total -= from.Offset
+		}
+	}
+	return total
+}
+
+// Время постановки первого неотправленного сообщения
+func (b *SpillBuffer) headTime() time.Time {
+	position := b.head
+	for {
+		file, err := os.Open(b.segmentPath(position.Segment))
+		if err != nil {
+			return time.Now()
+		}
+		file.Seek(position.Offset, io.SeekStart)
+		_, queued, _, err := readSpillRecord(bufio.NewReader(file))
+		file.Close()
+		if err == nil {
+			return queued
+		}
+		next := b.nextSegment(position.Segment)
+		if next == position.Segment {
+			return time.Now()
+		}
+		position = spillCursor{Segment: next}
+	}
+}
+
+// Позиция пишется во временный файл и переименовывается
+func (b *SpillBuffer) saveCursor(cursor spillCursor) error {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(b.dir, "cursor")
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (b *SpillBuffer) Depth() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.depth
+}
+
+func (b *SpillBuffer) Stats() SpillStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := SpillStats{Depth: b.depth, Bytes: b.bytes, MaxBytes: b.maxBytes, Dropped: b.dropped}
+	if b.depth > 0 {
+		stats.OldestAgeSeconds = time.Since(b.oldest).Seconds()
+	}
+	return stats
+}
+
+func (b *SpillBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.lock.Close()
+	return b.file.Close()
+}
+
+// Запись: длина, CRC32, время (нс), длина ключа (2 байта), ключ, число
+// заголовков (2 байта), заголовки, значение. Заголовок: длина имени
+// (2 байта), имя, длина значения (4 байта), значение. Длина и CRC считаются
+// по всему после CRC.
+func appendSpillRecord(data []byte, message kafka.Message, queued time.Time) []byte {
+	body := binary.BigEndian.AppendUint64(nil, uint64(queued.UnixNano()))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(message.Key)))
+	body = append(body, message.Key...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(message.Headers)))
+	for _, header := range message.Headers {
+		body = binary.BigEndian.AppendUint16(body, uint16(len(header.Key)))
+		body = append(body, header.Key...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(header.Value)))
+		body = append(body, header.Value...)
+	}
+	body = append(body, message.Value...)
+
+	data = binary.BigEndian.AppendUint32(data, uint32(len(body)))
+	data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(body))
+	return append(data, body...)
+}
+
+// Читает запись; io.EOF — записей больше нет, другая ошибка — запись
+// оборвана или повреждена
+func readSpillRecord(reader *bufio.Reader) (kafka.Message, time.Time, int64, error) {
+	var header [spillHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return kafka.Message{}, time.Time{}, 0, io.EOF
+		}
+		return kafka.Message{}, time.Time{}, 0, fmt.Errorf("оборванный заголовок: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 12 || length > maxSpillRecord {
+		return kafka.Message{}, time.Time{}, 0, fmt.Errorf("неверная длина записи %d", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return kafka.Message{}, time.Time{}, 0, fmt.Errorf("оборванная запись: %w", err)
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+		return kafka.Message{}, time.Time{}, 0, fmt.Errorf("неверная контрольная сумма")
+	}
+
+	queued := time.Unix(0, int64(binary.BigEndian.Uint64(body[:8])))
+	message, err := decodeSpillBody(body[8:])
+	if err != nil {
+		return kafka.Message{}, time.Time{}, 0, err
+	}
+	return message, queued, int64(spillHeaderSize + len(body)), nil
+}
+
+// Ключ, заголовки и значение записи
+func decodeSpillBody(body []byte) (kafka.Message, error) {
+	var message kafka.Message
+	field := func(size int) ([]byte, bool) {
+		if size > len(body) {
+			return nil, false
+		}
+		value := body[:size:size]
+		body = body[size:]
+		return value, true
+	}
+
+	raw, ok := field(2)
+	if !ok {
+		return message, fmt.Errorf("нет длины ключа")
+	}
+	key, ok := field(int(binary.BigEndian.Uint16(raw)))
+	if !ok {
+		return message, fmt.Errorf("неверная длина ключа %d", binary.BigEndian.Uint16(raw))
+	}
+	if len(key) > 0 {
+		message.Key = key
+	}
+
+	raw, ok = field(2)
+	if !ok {
+		return message, fmt.Errorf("нет числа заголовков")
+	}
+	count := int(binary.BigEndian.Uint16(raw))
+	for i := 0; i < count; i++ {
+		var header kafka.Header
+		size, ok := field(2)
+		if ok {
+			var name []byte
+			name, ok = field(int(binary.BigEndian.Uint16(size)))
+			header.Key = string(name)
+		}
+		if ok {
+			size, ok = field(4)
+		}
+		if ok {
+			header.Value, ok = field(int(binary.BigEndian.Uint32(size)))
+		}
+		if !ok {
+			return message, fmt.Errorf("заголовок %d оборван", i)
+		}
+		message.Headers = append(message.Headers, header)
+	}
+
+	message.Value = body
+	return message, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func spillMessages(from, to int) []kafka.Message {
+	var messages []kafka.Message
+	for i := from; i < to; i++ {
+		messages = append(messages, kafka.Message{
+			Key:   []byte(fmt.Sprintf("key-%d", i)),
+			Value: []byte(fmt.Sprintf("value-%d", i)),
+		})
+	}
+	return messages
+}
+
+func openSpill(t *testing.T, dir string, segmentBytes, maxBytes int64) *SpillBuffer {
+	t.Helper()
+	buffer, err := OpenSpillBuffer(dir, segmentBytes, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// Сравнивает ключи и значения прочитанных сообщений
+func checkSpilled(t *testing.T, got, want []kafka.Message) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("прочитано %d сообщений, ожидали %d", len(got), len(want))
+	}
+	for i := range want {
+		if string(got[i].Key) != string(want[i].Key) || string(got[i].Value) != string(want[i].Value) {
+			t.Fatalf("сообщение %d: %s=%s, ожидали %s=%s", i, got[i].Key, got[i].Value, want[i].Key, want[i].Value)
+		}
+	}
+}
+
+func TestSpillBufferRoundTrip(t *testing.T) {
+	buffer := openSpill(t, t.TempDir(), 1<<20, 1<<20)
+	defer buffer.Close()
+
+	messages := []kafka.Message{
+		{Key: []byte("api"), Value: []byte(`{"level":"ERROR"}`), Headers: []kafka.Header{
+			{Key: "trace_id", Value: []byte("abc123")},
+			{Key: "empty", Value: []byte{}},
+		}},
+		{Value: []byte("без ключа и заголовков")},
+	}
+	if err := buffer.Append(messages); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.Depth() != 2 {
+		t.Fatalf("глубина %d", buffer.Depth())
+	}
+
+	got, err := buffer.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpilled(t, got, messages)
+	if got[1].Key != nil || len(got[1].Headers) != 0 {
+		t.Errorf("второе сообщение: ключ %q, заголовки %v", got[1].Key, got[1].Headers)
+	}
+	if len(got[0].Headers) != 2 || got[0].Headers[0].Key != "trace_id" || string(got[0].Headers[0].Value) != "abc123" ||
+		got[0].Headers[1].Key != "empty" || len(got[0].Headers[1].Value) != 0 {
+		t.Errorf("заголовки %v", got[0].Headers)
+	}
+
+	// Без Commit сообщения остаются в буфере
+	if again, _ := buffer.Peek(10); len(again) != 2 || buffer.Depth() != 2 {
+		t.Fatalf("после Peek без Commit: прочитано %d, глубина %d", len(again), buffer.Depth())
+	}
+	if err := buffer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := buffer.Stats(); stats.Depth != 0 || stats.Bytes != 0 {
+		t.Errorf("после Commit: %+v", stats)
+	}
+	if rest, _ := buffer.Peek(10); len(rest) != 0 {
+		t.Errorf("после Commit прочитано %d", len(rest))
+	}
+}
+
+func TestSpillBufferSegmentRollover(t *testing.T) {
+	dir := t.TempDir()
+	// Сегмент вмещает пару записей
+	buffer := openSpill(t, dir, 64, 1<<20)
+	defer buffer.Close()
+
+	messages := spillMessages(0, 10)
+	for _, message := range messages {
+		if err := buffer.Append([]kafka.Message{message}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segments := len(segmentFiles(t, dir))
+	if segments < 4 {
+		t.Fatalf("сегментов %d, ожидали несколько", segments)
+	}
+
+	// Чтение идет через границы сегментов по порядку
+	got, err := buffer.Peek(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpilled(t, got, messages[:7])
+	if err := buffer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Прочитанные сегменты удалены
+	if left := len(segmentFiles(t, dir)); left >= segments {
+		t.Errorf("сегментов после Commit %d из %d", left, segments)
+	}
+	got, err = buffer.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpilled(t, got, messages[7:])
+	if err := buffer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := buffer.Stats(); stats.Depth != 0 || stats.Bytes != 0 {
+		t.Errorf("после чтения всего: %+v", stats)
+	}
+}
+
+func TestSpillBufferCursorRecovery(t *testing.T) {
+	dir := t.TempDir()
+	buffer := openSpill(t, dir, 64, 1<<20)
+	messages := spillMessages(0, 6)
+	if err := buffer.Append(messages); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buffer.Peek(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := buffer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// Прочитаны, но не подтверждены — после перезапуска отправятся снова
+	if _, err := buffer.Peek(2); err != nil {
+		t.Fatal(err)
+	}
+	bytes := buffer.Stats().Bytes
+	buffer.Close()
+
+	reopened := openSpill(t, dir, 64, 1<<20)
+	defer reopened.Close()
+	if stats := reopened.Stats(); stats.Depth != 4 || stats.Bytes != bytes {
+		t.Fatalf("после перезапуска %+v, ожидали 4 сообщения и %d байт", stats, bytes)
+	}
+	got, err := reopened.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpilled(t, got, messages[2:])
+}
+
+func TestSpillBufferLock(t *testing.T) {
+	dir := t.TempDir()
+	buffer := openSpill(t, dir, 1<<20, 1<<20)
+	if _, err := OpenSpillBuffer(dir, 1<<20, 1<<20); err == nil {
+		t.Fatal("каталог открыт дважды")
+	}
+	buffer.Close()
+	openSpill(t, dir, 1<<20, 1<<20).Close()
+}
+
+func TestSpillBufferCorruption(t *testing.T) {
+	dir := t.TempDir()
+	buffer := openSpill(t, dir, 1<<20, 1<<20)
+	messages := spillMessages(0, 3)
+	for _, message := range messages {
+		if err := buffer.Append([]kafka.Message{message}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buffer.Close()
+
+	segments := segmentFiles(t, dir)
+	if len(segments) != 1 {
+		t.Fatalf("сегментов %d", len(segments))
+	}
+	data, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSize := len(data) / 3
+	// Портим последний байт значения второй записи — CRC не сойдется
+	data[2*recordSize-1] ^= 0xff
+	if err := os.WriteFile(segments[0], data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openSpill(t, dir, 1<<20, 1<<20)
+	defer reopened.Close()
+	if reopened.Depth() != 1 {
+		t.Fatalf("глубина %d, ожидали 1", reopened.Depth())
+	}
+	// Поврежденная запись и все после нее обрезаны
+	info, err := os.Stat(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(recordSize) {
+		t.Fatalf("размер сегмента %d, ожидали %d", info.Size(), recordSize)
+	}
+
+	// Новые записи дописываются после уцелевших
+	if err := reopened.Append(spillMessages(3, 4)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpilled(t, got, append(messages[:1], spillMessages(3, 4)...))
+}
+
+func TestSpillBufferTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	buffer := openSpill(t, dir, 1<<20, 1<<20)
+	if err := buffer.Append(spillMessages(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	buffer.Close()
+
+	// Запись оборвана при сбое посередине
+	segment := segmentFiles(t, dir)[0]
+	info, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(segment, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openSpill(t, dir, 1<<20, 1<<20)
+	defer reopened.Close()
+	got, err := reopened.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpilled(t, got, spillMessages(0, 1))
+}
+
+func TestSpillBufferFull(t *testing.T) {
+	dir := t.TempDir()
+	record := len(appendSpillRecord(nil, spillMessages(0, 1)[0], time.Now()))
+	// Помещаются ровно две записи
+	buffer := openSpill(t, dir, 1<<20, int64(2*record))
+	defer buffer.Close()
+
+	if err := buffer.Append(spillMessages(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := buffer.Append(spillMessages(2, 3)); !errors.Is(err, errSpillFull) {
+		t.Fatalf("ожидали errSpillFull, получили %v", err)
+	}
+	stats := buffer.Stats()
+	if stats.Depth != 2 || stats.Dropped != 1 || stats.Bytes != int64(2*record) {
+		t.Errorf("после переполнения %+v", stats)
+	}
+
+	// Пачка отбрасывается целиком, даже если часть поместилась бы
+	if _, err := buffer.Peek(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := buffer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := buffer.Append(spillMessages(3, 5)); !errors.Is(err, errSpillFull) {
+		t.Fatalf("пачка больше свободного места: %v", err)
+	}
+	if err := buffer.Append(spillMessages(5, 6)); err != nil {
+		t.Fatalf("после освобождения места: %v", err)
+	}
+	got, err := buffer.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSpilled(t, got, append(spillMessages(1, 2), spillMessages(5, 6)...))
+}
+
+func TestDecodeSpillBodyRejectsTruncated(t *testing.T) {
+	message := kafka.Message{Key: []byte("k"), Value: []byte("v"), Headers: []kafka.Header{{Key: "h", Value: []byte("value")}}}
+	record := appendSpillRecord(nil, message, time.Now())
+	body := record[spillHeaderSize+8:]
+
+	decoded, err := decodeSpillBody(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Headers, message.Headers) || string(decoded.Value) != "v" {
+		t.Errorf("получили %+v", decoded)
+	}
+	// Обрезанное тело до начала значения не разбирается
+	for size := 0; size < len(body)-1; size++ {
+		if _, err := decodeSpillBody(body[:size]); err == nil {
+			t.Errorf("тело из %d байт разобрано без ошибки", size)
+		}
+	}
+}
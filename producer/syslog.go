@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"events"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Максимальный размер сообщения syslog
+const maxSyslogMessage = 64 * 1024
+
+// Разбирает сообщение syslog: RFC 5424 ("<PRI>1 TIMESTAMP HOST APP PROCID
+// MSGID SD MSG") или RFC 3164 ("<PRI>Mmm dd hh:mm:ss HOST TAG[PID]: MSG").
+// Уровень — по severity из PRI, сервис — APP-NAME или TAG, иначе хост.
+func parseSyslog(line string, now time.Time) (*events.LogMessage, error) {
+	line = strings.TrimRight(line, "\r\n\x00")
+	if !strings.HasPrefix(line, "<") {
+		return nil, fmt.Errorf("нет PRI в начале сообщения")
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return nil, fmt.Errorf("неверный PRI")
+	}
+	priority, err := strconv.Atoi(line[1:end])
+	if err != nil || priority > 191 {
+		return nil, fmt.Errorf("неверный PRI %q", line[1:end])
+	}
+	rest := line[end+1:]
+
+	message := &events.LogMessage{Level: syslogLevel(priority % 8), Timestamp: events.FormatTime(now)}
+	var host string
+	if version, after, ok := strings.Cut(rest, " "); ok && version == "1" {
+		host, err = parseRFC5424(after, message)
+	} else {
+		host = parseRFC3164(rest, message, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if message.Service == "" {
+		message.Service = host
+	}
+	if message.Service == "" {
+		message.Service = "syslog"
+	}
+	return message, nil
+}
+
+func parseRFC5424(rest string, message *events.LogMessage) (string, error) {
+	// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	header := strings.SplitN(rest, " ", 6)
+	if len(header) < 6 {
+		return "", fmt.Errorf("RFC 5424: неполный заголовок")
+	}
+	if header[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return "", fmt.Errorf("RFC 5424: время %q: %w", header[0], err)
+		}
+		message.Timestamp = events.FormatTime(t.Local())
+	}
+	if header[2] != "-" {
+		message.Service = header[2]
+	}
+
+	text, err := skipStructuredData(header[5])
+	if err != nil {
+		return "", err
+	}
+	message.Message = strings.TrimPrefix(text, "\ufeff")
+	return nilValue(header[1]), nil
+}
+
+// STRUCTURED-DATA: "-" или [id param="value"]... с экранированием \] \" \\
+func skipStructuredData(data string) (string, error) {
+	if data == "-" || strings.HasPrefix(data, "- ") {
+		return strings.TrimPrefix(data[1:], " "), nil
+	}
+	i := 0
+	for i < len(data) && data[i] == '[' {
+		end := elementEnd(data, i)
+		if end < 0 {
+			return "", fmt.Errorf("RFC 5424: незакрытый STRUCTURED-DATA")
+		}
+		i = end + 1
+	}
+	if i == 0 {
+		return "", fmt.Errorf("RFC 5424: нет STRUCTURED-DATA")
+	}
+	return strings.TrimPrefix(data[i:], " "), nil
+}
+
+// Позиция "]", закрывающей элемент STRUCTURED-DATA с позиции start; -1 — нет
+func elementEnd(data string, start int) int {
+	inQuotes := false
+	for i := start + 1; i < len(data); i++ {
+		switch {
+		case data[i] == '\\' && inQuotes:
+			i++
+		case data[i] == '"':
+			inQuotes = !inQuotes
+		case data[i] == ']' && !inQuotes:
+			return i
+		}
+	}
+	return -1
+}
+
+func nilValue(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+// RFC 3164 не строгий: если время или хост не распознаны, остаток
+// строки целиком считается сообщением
+func parseRFC3164(rest string, message *events.LogMessage, now time.Time) string {
+	message.Message = rest
+	if len(rest) < 16 || rest[15] != ' ' {
+		return ""
+	}
+	t, err := time.ParseInLocation(time.Stamp, rest[:15], time.Local)
+	if err != nil {
+		return ""
+	}
+	// В RFC 3164 нет года: берем текущий, а если дата в будущем — прошлый
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	message.Timestamp = events.FormatTime(t)
+
+	host, text, ok := strings.Cut(rest[16:], " ")
+	if !ok {
+		message.Message = rest[16:]
+		return ""
+	}
+	message.Message = text
+
+	// TAG[PID]: MSG или TAG: MSG
+	if tag, msg, ok := strings.Cut(text, ": "); ok && !strings.ContainsAny(tag, " ") {
+		if bracket := strings.IndexByte(tag, '['); bracket > 0 {
+			tag = tag[:bracket]
+		}
+		message.Service = tag
+		message.Message = msg
+	}
+	return host
+}
+
+// Severity syslog к уровням INFO/WARN/ERROR
+func syslogLevel(severity int) string {
+	switch {
+	case severity <= 3: // emerg, alert, crit, err
+		return "ERROR"
+	case severity == 4: // warning
+		return "WARN"
+	case severity == 7:
+		return "DEBUG"
+	}
+	return "INFO" // notice, info
+}
+
+// Прием syslog по UDP и TCP
+type SyslogServer struct {
+	ingester *Ingester
+	dropped  atomic.Int64
+}
+
+func NewSyslogServer(ingester *Ingester) *SyslogServer {
+	return &SyslogServer{ingester: ingester}
+}
+
+// ServeUDP: датаграмма — одно сообщение. Обратного канала у UDP нет,
+// поэтому при заполненной очереди сообщения отбрасываются и считаются.
+func (s *SyslogServer) ServeUDP(ctx context.Context, conn net.PacketConn) {
+	buffer := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Ошибка чтения syslog UDP: %v", err)
+			}
+			return
+		}
+		message, err := parseSyslog(string(buffer[:n]), time.Now())
+		if err != nil {
+			log.Printf("Syslog UDP: сообщение отброшено: %v", err)
+			continue
+		}
+		if err := s.ingester.Offer([]*events.LogMessage{message}); err != nil {
+			if dropped := s.dropped.Add(1); dropped%1000 == 1 {
+				log.Printf("Syslog UDP: %v, отброшено всего %d", err, dropped)
+			}
+		}
+	}
+}
+
+// ServeTCP принимает соединения до закрытия listener
+func (s *SyslogServer) ServeTCP(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Ошибка приема syslog TCP: %v", err)
+			}
+			return
+		}
+		go s.serveConn(ctx, conn)
+	}
+}
+
+// Кадры RFC 6587: с подсчетом октетов ("LEN <PRI>...") или через перевод
+// строки. Пока очередь заполнена, соединение не читается.
+func (s *SyslogServer) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	reader := bufio.NewReaderSize(conn, maxSyslogMessage)
+	for {
+		frame, err := readSyslogFrame(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				log.Printf("Syslog TCP %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if strings.TrimSpace(frame) == "" {
+			continue
+		}
+		message, err := parseSyslog(frame, time.Now())
+		if err != nil {
+			log.Printf("Syslog TCP %s: сообщение отброшено: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		if err := s.ingester.Put(ctx, message); err != nil {
+			return
+		}
+	}
+}
+
+func readSyslogFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) && line != "" {
+			return line, nil
+		}
+		return line, err
+	}
+
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil || size <= 0 || size > maxSyslogMessage {
+		return "", fmt.Errorf("неверная длина кадра %q", strings.TrimSpace(length))
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		return "", err
+	}
+	return string(frame), nil
+}
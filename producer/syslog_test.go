@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"events"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var syslogNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+func TestParseSyslogRFC5424(t *testing.T) {
+	message, err := parseSyslog("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8\n", syslogNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := events.FormatTime(time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC).Local())
+	if message.Level != "ERROR" || message.Service != "su" || message.Timestamp != timestamp ||
+		message.Message != "BOM'su root' failed for lonvick on /dev/pts/8" {
+		t.Errorf("получили %+v", message)
+	}
+}
+
+func TestParseSyslogRFC5424StructuredData(t *testing.T) {
+	line := `<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 - 8710 - [exampleSDID@32473 iut="3" eventSource="App\]lication"][meta seq="1"] ` + "\ufeff" + `An application event`
+	message, err := parseSyslog(line, syslogNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// APP-NAME не задан — сервисом становится хост; BOM отрезается
+	if message.Level != "INFO" || message.Service != "192.0.2.1" || message.Message != "An application event" {
+		t.Errorf("получили %+v", message)
+	}
+
+	// Без хоста и приложения
+	message, err = parseSyslog("<12>1 - - - - - -", syslogNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Level != "WARN" || message.Service != "syslog" || message.Message != "" || message.Timestamp != events.FormatTime(syslogNow) {
+		t.Errorf("получили %+v", message)
+	}
+}
+
+func TestParseSyslogRFC3164(t *testing.T) {
+	message, err := parseSyslog("<13>Feb  5 17:32:18 10.0.0.99 myapp[123]: Use the BFG!", syslogNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := events.FormatTime(time.Date(2024, 2, 5, 17, 32, 18, 0, time.Local))
+	if message.Level != "INFO" || message.Service != "myapp" || message.Message != "Use the BFG!" || message.Timestamp != timestamp {
+		t.Errorf("получили %+v", message)
+	}
+
+	// Без TAG сервисом становится хост
+	message, err = parseSyslog("<11>Feb  5 17:32:18 mymachine disk is full", syslogNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Level != "ERROR" || message.Service != "mymachine" || message.Message != "disk is full" {
+		t.Errorf("получили %+v", message)
+	}
+}
+
+func TestParseSyslogRFC3164Year(t *testing.T) {
+	// Дата позже текущей больше чем на сутки — это прошлый год
+	message, err := parseSyslog("<14>Dec 31 23:59:59 host app: bye", syslogNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := events.FormatTime(time.Date(2023, 12, 31, 23, 59, 59, 0, time.Local)); message.Timestamp != want {
+		t.Errorf("время %s, ожидали %s", message.Timestamp, want)
+	}
+}
+
+func TestParseSyslogRFC3164Lenient(t *testing.T) {
+	// Время не распознано — остаток строки целиком сообщение
+	message, err := parseSyslog("<15>something went wrong", syslogNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Level != "DEBUG" || message.Service != "syslog" || message.Message != "something went wrong" || message.Timestamp != events.FormatTime(syslogNow) {
+		t.Errorf("получили %+v", message)
+	}
+}
+
+func TestParseSyslogRejectsInvalid(t *testing.T) {
+	for _, line := range []string{
+		"no priority",
+		"<>1 - - - - - -",
+		"<192>1 - - - - - -",
+		"<abc>hello",
+		"<34>1 2003-10-11 host app - - - msg",         // время не RFC 3339
+		"<34>1 2003-10-11T22:14:15Z host app",         // неполный заголовок
+		`<34>1 - host app - - [id a="1" unterminated`, // незакрытый STRUCTURED-DATA
+		"<34>1 - host app - - text",                   // нет STRUCTURED-DATA
+	} {
+		if _, err := parseSyslog(line, syslogNow); err == nil {
+			t.Errorf("%q разобрано без ошибки", line)
+		}
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	first := "<34>1 - host su - - - first"
+	stream := "27 " + first + "<13>Feb  5 17:32:18 host app: second\n<13>Feb  5 17:32:18 host app: last"
+	reader := bufio.NewReader(strings.NewReader(stream))
+
+	want := []string{first, "<13>Feb  5 17:32:18 host app: second\n", "<13>Feb  5 17:32:18 host app: last"}
+	for _, expected := range want {
+		frame, err := readSyslogFrame(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame != expected {
+			t.Errorf("кадр %q, ожидали %q", frame, expected)
+		}
+	}
+	if _, err := readSyslogFrame(reader); !errors.Is(err, io.EOF) {
+		t.Errorf("в конце потока: %v", err)
+	}
+
+	for _, stream := range []string{"0 <13>x", "99999999 <13>x", "12x <13>x"} {
+		if _, err := readSyslogFrame(bufio.NewReader(strings.NewReader(stream))); err == nil {
+			t.Errorf("%q прочитан без ошибки", stream)
+		}
+	}
+}
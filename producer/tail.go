@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"events"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Сколько байт файла читать за один опрос
+const tailReadBytes = 1 << 20
+
+// Настройки агента
+type TailConfig struct {
+	Patterns       []string      // шаблоны файлов
+	Parser         LineParser    // разбор строки в поля
+	DefaultLevel   string        // уровень для строк без уровня
+	DefaultService string        // сервис для строк без сервиса; пусто — имя файла
+	Checkpoint     string        // файл с позициями
+	PollInterval   time.Duration // период опроса файлов
+	FromEnd        bool          // файлы, найденные при старте без позиции, читать с конца
+}
+
+// Файл идентифицируется устройством и inode: после переименования при
+// ротации это тот же файл, а новый файл под старым именем — другой
+type fileID struct {
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+}
+
+func fileIDOf(info os.FileInfo) fileID {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}
+	}
+	return fileID{Device: uint64(stat.Dev), Inode: stat.Ino}
+}
+
+// Позиция файла в checkpoint-файле
+type checkpointEntry struct {
+	Path string `json:"path"`
+	fileID
+	Offset int64 `json:"offset"`
+}
+
+type tailedFile struct {
+	path    string
+	service string // сервис по умолчанию: имя файла при открытии, не после ротации
+	id      fileID
+	file    *os.File
+	offset  int64 // до этой позиции строки записаны в Kafka
+	next    int64 // позиция после прочитанных, но еще не записанных строк
+	idle    bool  // ротированный файл: опрос без новых данных уже был
+}
+
+// Агент: читает новые строки файлов и отправляет их в Kafka.
+//
+// Позиция файла сдвигается и сохраняется в checkpoint только после
+// успешной записи в Kafka, поэтому после перезапуска строки не теряются.
+// Повторно могут уйти только строки последней пачки, если процесс упал
+// между записью и сохранением позиций.
+//
+// Ротация:
+//   - переименование — старый файл дочитывается до конца по открытому
+//     дескриптору, новый файл под тем же именем читается с начала;
+//   - усечение (copytruncate) — размер стал меньше позиции, чтение с начала.
+type Tailer struct {
+	config TailConfig
+	writer messageWriter
+
+	files    map[string]*tailedFile // по пути
+	draining []*tailedFile          // ротированные и удаленные файлы
+	restored map[fileID]int64       // позиции из checkpoint до первого опроса
+	started  bool
+
+	pending []kafka.Message
+	sent    int
+	dirty   bool
+}
+
+func NewTailer(config TailConfig, writer messageWriter) (*Tailer, error) {
+	t := &Tailer{
+		config:   config,
+		writer:   writer,
+		files:    make(map[string]*tailedFile),
+		restored: make(map[fileID]int64),
+	}
+	entries, err := loadCheckpoint(config.Checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		t.restored[entry.fileID] = entry.Offset
+	}
+	log.Printf("Загружено позиций из %s: %d", config.Checkpoint, len(entries))
+	return t, nil
+}
+
+func (t *Tailer) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.config.PollInterval)
+	defer ticker.Stop()
+	defer t.close()
+
+	for {
+		if err := t.poll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Ошибка отправки: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Один опрос: новые и ротированные файлы, чтение строк, запись, checkpoint
+func (t *Tailer) poll(ctx context.Context) error {
+	// Пока прошлая пачка не записана, новые строки не читаем
+	if len(t.pending) == 0 {
+		t.scan()
+		t.read()
+	}
+	if err := t.flush(ctx); err != nil {
+		return err
+	}
+	if t.dirty {
+		if err := t.saveCheckpoint(); err != nil {
+			log.Printf("Ошибка сохранения позиций: %v", err)
+		} else {
+			t.dirty = false
+		}
+	}
+	return nil
+}
+
+// Находит новые файлы и ротацию уже открытых
+func (t *Tailer) scan() {
+	paths := make(map[string]bool)
+	for _, pattern := range t.config.Patterns {
+		matches, _ := filepath.Glob(pattern) // шаблоны проверены при старте
+		for _, path := range matches {
+			paths[path] = true
+		}
+	}
+
+	// Файл удален или переименован в имя вне шаблонов — дочитываем
+	for path, f := range t.files {
+		if !paths[path] {
+			t.retire(f)
+		}
+	}
+
+	for _, path := range sortedKeys(paths) {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		id := fileIDOf(info)
+
+		if f := t.files[path]; f != nil {
+			if f.id == id {
+				if info.Size() < f.offset {
+					log.Printf("%s усечен, читаем с начала", path)
+					f.offset, f.next = 0, 0
+					t.dirty = true
+				}
+				continue
+			}
+			// Под именем уже другой файл — ротация переименованием
+			log.Printf("%s ротирован, дочитываем старый файл", path)
+			t.retire(f)
+		}
+
+		// Файл мог переехать под другое подходящее имя (app.log → app.log.1)
+		if f := t.adopt(id); f != nil {
+			f.path, f.idle = path, false
+			t.files[path] = f
+			t.dirty = true
+			continue
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("Не удалось открыть %s: %v", path, err)
+			continue
+		}
+		offset, ok := t.restored[id]
+		switch {
+		case ok && offset <= info.Size():
+			delete(t.restored, id)
+		case ok:
+			log.Printf("%s меньше сохраненной позиции, читаем с начала", path)
+			delete(t.restored, id)
+			offset = 0
+		case !t.started && t.config.FromEnd:
+			offset = info.Size()
+		default:
+			offset = 0
+		}
+		service := t.config.DefaultService
+		if service == "" {
+			service = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		log.Printf("Читаем %s с позиции %d", path, offset)
+		t.files[path] = &tailedFile{path: path, service: service, id: id, file: file, offset: offset, next: offset}
+		t.dirty = true
+	}
+
+	// Файлы прошлого запуска, которых больше нет под шаблонами, не ищем:
+	// inode удаленного файла может достаться новому
+	if !t.started {
+		t.restored = nil
+		t.started = true
+	}
+}
+
+// Убирает файл из отслеживаемых по имени: он дочитывается и закрывается
+func (t *Tailer) retire(f *tailedFile) {
+	delete(t.files, f.path)
+	t.draining = append(t.draining, f)
+}
+
+// Возвращает отслеживаемый файл с этим id, убирая его с прежнего места
+func (t *Tailer) adopt(id fileID) *tailedFile {
+	for i, f := range t.draining {
+		if f.id == id {
+			t.draining = append(t.draining[:i], t.draining[i+1:]...)
+			return f
+		}
+	}
+	for path, f := range t.files {
+		if f.id == id {
+			delete(t.files, path)
+			return f
+		}
+	}
+	return nil
+}
+
+// Читает новые строки всех файлов в pending
+func (t *Tailer) read() {
+	for _, path := range sortedKeys(t.files) {
+		t.readFile(t.files[path], false)
+	}
+
+	// Ротированный файл закрывается после опроса без новых данных;
+	// последняя строка без перевода строки отправляется перед этим
+	draining := t.draining[:0]
+	for _, f := range t.draining {
+		if t.readFile(f, f.idle) {
+			f.idle = false
+		} else if f.idle {
+			log.Printf("%s дочитан", f.path)
+			f.file.Close()
+			t.dirty = true
+			continue
+		} else {
+			f.idle = true
+		}
+		draining = append(draining, f)
+	}
+	t.draining = draining
+}
+
+// Читает полные строки с позиции файла; false — новых данных нет
+func (t *Tailer) readFile(f *tailedFile, final bool) bool {
+	lines, next, err := readLines(f.file, f.offset, final)
+	if err != nil {
+		log.Printf("Ошибка чтения %s: %v", f.path, err)
+		return false
+	}
+	if next == f.offset {
+		return false
+	}
+
+	now := time.Now()
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		fields, err := t.config.Parser(line)
+		if err != nil {
+			// Строку не теряем: уходит целиком как сообщение
+			log.Printf("%s: строка не разобрана (%v), отправляем как есть", f.path, err)
+		}
+		message := toLogMessage(fields, line, t.config.DefaultLevel, f.service, now)
+		value, err := events.Encode(message)
+		if err != nil {
+			log.Printf("%s: строка пропущена: %v", f.path, err)
+			continue
+		}
+		t.pending = append(t.pending, kafka.Message{Key: []byte(message.Service), Value: value})
+	}
+	f.next = next
+	return true
+}
+
+// Строки файла с offset до последнего перевода строки
+func readLines(file *os.File, offset int64, final bool) ([]string, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, offset, err
+	}
+	size := min(info.Size()-offset, tailReadBytes)
+	if size <= 0 {
+		return nil, offset, nil
+	}
+
+	buffer := make([]byte, size)
+	n, err := file.ReadAt(buffer, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, offset, err
+	}
+	buffer = buffer[:n]
+
+	end := bytes.LastIndexByte(buffer, '\n') + 1
+	if end == 0 && (final || n == tailReadBytes) {
+		// Строка длиннее лимита или хвост дочитываемого файла
+		end = n
+	}
+	if end == 0 {
+		return nil, offset, nil
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(buffer[:end]), "\n") {
+		lines = append(lines, strings.TrimSuffix(line, "\r"))
+	}
+	return lines, offset + int64(end), nil
+}
+
+// Записывает pending в Kafka и сдвигает позиции файлов
+func (t *Tailer) flush(ctx context.Context) error {
+	if len(t.pending) > 0 {
+		if err := t.writer.WriteMessages(ctx, t.pending...); err != nil {
+			return err
+		}
+		t.sent += len(t.pending)
+		log.Printf("Отправлено: %d строк (всего %d)", len(t.pending), t.sent)
+		t.pending = t.pending[:0]
+	}
+	for _, f := range t.tracked() {
+		if f.next != f.offset {
+			f.offset = f.next
+			t.dirty = true
+		}
+	}
+	return nil
+}
+
+func (t *Tailer) tracked() []*tailedFile {
+	files := make([]*tailedFile, 0, len(t.files)+len(t.draining))
+	for _, path := range sortedKeys(t.files) {
+		files = append(files, t.files[path])
+	}
+	return append(files, t.draining...)
+}
+
+// Позиции всех открытых файлов. Пишется во временный файл и переименовывается, чтобы не повредить при сбое.
+func (t *Tailer) saveCheckpoint() error {
+	entries := []checkpointEntry{}
+	for _, f := range t.tracked() {
+		entries = append(entries, checkpointEntry{Path: f.path, fileID: f.id, Offset: f.offset})
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.config.Checkpoint + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.config.Checkpoint)
+}
+
+func loadCheckpoint(path string) ([]checkpointEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []checkpointEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("checkpoint %s поврежден: %w", path, err)
+	}
+	return entries, nil
+}
+
+// Дописывает незаписанное и сохраняет позиции перед остановкой
+func (t *Tailer) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := t.flush(ctx); err != nil {
+		log.Printf("Не отправлено перед остановкой: %d строк, будут прочитаны снова: %v", len(t.pending), err)
+	}
+	if err := t.saveCheckpoint(); err != nil {
+		log.Printf("Ошибка сохранения позиций: %v", err)
+	}
+	for _, f := range t.tracked() {
+		f.file.Close()
+	}
+	log.Printf("Агент остановлен, отправлено %d строк", t.sent)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
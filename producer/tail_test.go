@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"events"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func writeTailFile(t *testing.T, content string) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+// Пустые строки readFile пропускает
+func nonEmpty(lines []string) []string {
+	var result []string
+	for _, line := range lines {
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+func TestReadLinesKeepsPartialLine(t *testing.T) {
+	file := writeTailFile(t, "first\r\nsecond\npart")
+
+	// Недописанная строка остается до следующего чтения
+	lines, next, err := readLines(file, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(nonEmpty(lines), want) || next != 14 {
+		t.Fatalf("строки %q, позиция %d", lines, next)
+	}
+	if lines, again, _ := readLines(file, next, false); lines != nil || again != next {
+		t.Fatalf("недописанная строка прочитана: %q, позиция %d", lines, again)
+	}
+
+	// Файл дочитывается после ротации — хвост без перевода строки отдается
+	lines, next, err = readLines(file, next, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"part"}; !reflect.DeepEqual(nonEmpty(lines), want) || next != 18 {
+		t.Fatalf("строки %q, позиция %d", lines, next)
+	}
+	if lines, again, _ := readLines(file, next, true); lines != nil || again != next {
+		t.Fatalf("чтение в конце файла: %q, позиция %d", lines, again)
+	}
+}
+
+func TestReadLinesLongLine(t *testing.T) {
+	long := strings.Repeat("x", tailReadBytes+tailReadBytes/2)
+	file := writeTailFile(t, long+"\nshort\n")
+
+	// Строка длиннее лимита отдается частями, чтобы чтение не застряло
+	lines, next, err := readLines(file, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || len(lines[0]) != tailReadBytes || next != tailReadBytes {
+		t.Fatalf("строк %d, позиция %d", len(lines), next)
+	}
+
+	lines, next, err = readLines(file, next, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = nonEmpty(lines)
+	if len(lines) != 2 || len(lines[0]) != tailReadBytes/2 || lines[1] != "short" {
+		t.Fatalf("строк %d: %q", len(lines), lines[len(lines)-1])
+	}
+	if next != int64(len(long)+len("\nshort\n")) {
+		t.Errorf("позиция %d", next)
+	}
+}
+
+// Kafka в памяти: запоминает тексты записанных строк
+type fakeWriter struct {
+	mu    sync.Mutex
+	lines []string
+	err   error // ошибка всех записей, пока задана
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	for _, message := range messages {
+		var logMessage events.LogMessage
+		if err := events.Decode(message.Value, &logMessage); err != nil {
+			return err
+		}
+		w.lines = append(w.lines, logMessage.Message)
+	}
+	return nil
+}
+
+func (w *fakeWriter) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+}
+
+func (w *fakeWriter) written() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.lines...)
+}
+
+func newTestTailer(t *testing.T, dir string, writer messageWriter, patterns ...string) *Tailer {
+	t.Helper()
+	parser, err := NewLineParser("logfmt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailer, err := NewTailer(TailConfig{
+		Patterns:     patterns,
+		Parser:       parser,
+		DefaultLevel: "INFO",
+		Checkpoint:   filepath.Join(dir, "checkpoint.json"),
+		PollInterval: time.Second,
+	}, writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, f := range tailer.tracked() {
+			f.file.Close()
+		}
+	})
+	return tailer
+}
+
+func appendTailFile(t *testing.T, path, content string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pollTimes(t *testing.T, tailer *Tailer, times int) {
+	t.Helper()
+	for range times {
+		if err := tailer.poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTailerRenameRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writer := &fakeWriter{}
+	tailer := newTestTailer(t, dir, writer, path)
+
+	appendTailFile(t, path, "msg=one\nmsg=two\n")
+	pollTimes(t, tailer, 1)
+	if want := []string{"one", "two"}; !reflect.DeepEqual(writer.written(), want) {
+		t.Fatalf("отправлено %q, ожидали %q", writer.written(), want)
+	}
+
+	// Дописанное до ротации и хвост без перевода строки дочитываются из
+	// старого файла, новый файл под тем же именем читается с начала
+	appendTailFile(t, path, "msg=three\nmsg=tail")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendTailFile(t, path, "msg=four\n")
+	pollTimes(t, tailer, 5)
+	appendTailFile(t, path, "msg=five\n")
+	pollTimes(t, tailer, 1)
+
+	if want := []string{"one", "two", "four", "three", "tail", "five"}; !reflect.DeepEqual(writer.written(), want) {
+		t.Errorf("отправлено %q, ожидали %q", writer.written(), want)
+	}
+	if len(tailer.draining) != 0 {
+		t.Errorf("старый файл не закрыт: %d дочитываются", len(tailer.draining))
+	}
+}
+
+func TestTailerRenameWithinPatterns(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writer := &fakeWriter{}
+	tailer := newTestTailer(t, dir, writer, filepath.Join(dir, "app.log*"))
+
+	appendTailFile(t, path, "msg=one\n")
+	pollTimes(t, tailer, 1)
+
+	// Файл переехал под другое подходящее имя: позиция переезжает с ним,
+	// и прочитанные строки не отправляются второй раз
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendTailFile(t, path+".1", "msg=two\n")
+	appendTailFile(t, path, "msg=three\n")
+	pollTimes(t, tailer, 2)
+
+	if want := []string{"one", "three", "two"}; !reflect.DeepEqual(writer.written(), want) {
+		t.Errorf("отправлено %q, ожидали %q", writer.written(), want)
+	}
+}
+
+func TestTailerTruncateRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writer := &fakeWriter{}
+	tailer := newTestTailer(t, dir, writer, path)
+
+	appendTailFile(t, path, "msg=first\nmsg=second\n")
+	pollTimes(t, tailer, 1)
+
+	// copytruncate: файл стал меньше позиции — читаем с начала
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendTailFile(t, path, "msg=new\n")
+	pollTimes(t, tailer, 2)
+
+	if want := []string{"first", "second", "new"}; !reflect.DeepEqual(writer.written(), want) {
+		t.Errorf("отправлено %q, ожидали %q", writer.written(), want)
+	}
+}
+
+func TestTailerCheckpointRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writer := &fakeWriter{}
+	tailer := newTestTailer(t, dir, writer, path)
+
+	appendTailFile(t, path, "msg=one\nmsg=two\n")
+	pollTimes(t, tailer, 1)
+
+	// Kafka недоступна: строки не записаны, позиция не сохраняется
+	writer.fail(errors.New("kafka недоступна"))
+	appendTailFile(t, path, "msg=three\n")
+	if err := tailer.poll(context.Background()); err == nil {
+		t.Fatal("ошибка записи не возвращена")
+	}
+
+	// Падение без остановки: новый агент продолжает с сохраненной позиции
+	restarted := &fakeWriter{}
+	tailer = newTestTailer(t, dir, restarted, path)
+	appendTailFile(t, path, "msg=four\n")
+	pollTimes(t, tailer, 1)
+
+	if want := []string{"three", "four"}; !reflect.DeepEqual(restarted.written(), want) {
+		t.Errorf("после перезапуска отправлено %q, ожидали %q", restarted.written(), want)
+	}
+}
@@ -0,0 +1,110 @@
+// Package topics — создание и проверка служебных топиков Kafka.
+//
+// Все запросы идут через kafka.Client: Metadata у него не создает топик, в
+// отличие от Conn.ReadPartitions, поэтому проверка существования не дает
+// брокеру автоматически завести топик с настройками по умолчанию.
+package topics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Таймаут запросов к брокеру по умолчанию
+const Timeout = 30 * time.Second
+
+// NewClient возвращает клиент для административных запросов
+func NewClient(brokers []string) *kafka.Client {
+	return &kafka.Client{Addr: kafka.TCP(brokers...), Timeout: Timeout}
+}
+
+// Partitions возвращает партиции топика; пусто — топика нет
+func Partitions(ctx context.Context, client *kafka.Client, topic string) ([]kafka.Partition, error) {
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range metadata.Topics {
+		if t.Name != topic {
+			continue
+		}
+		if errors.Is(t.Error, kafka.UnknownTopicOrPartition) {
+			return nil, nil
+		}
+		if t.Error != nil {
+			return nil, fmt.Errorf("метаданные топика %s: %w", topic, t.Error)
+		}
+		return t.Partitions, nil
+	}
+	return nil, nil
+}
+
+// Create создает топик. Топик, который уже создал другой экземпляр
+// сервиса, ошибкой не считается.
+func Create(ctx context.Context, client *kafka.Client, config kafka.TopicConfig) error {
+	created, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: []kafka.TopicConfig{config}})
+	if err != nil {
+		return err
+	}
+	if err := created.Errors[config.Topic]; err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+		return err
+	}
+	return nil
+}
+
+// Compacted возвращает настройку для создания топика с компакцией
+func Compacted() []kafka.ConfigEntry {
+	return []kafka.ConfigEntry{{ConfigName: "cleanup.policy", ConfigValue: "compact"}}
+}
+
+// EnsureCompacted включает cleanup.policy=compact, если он не задан, и
+// возвращает, пришлось ли менять настройку. IncrementalAlterConfigs меняет
+// только этот параметр; AlterConfigs сбросил бы остальные настройки топика
+// к значениям по умолчанию.
+func EnsureCompacted(ctx context.Context, client *kafka.Client, topic string) (bool, error) {
+	described, err := client.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{
+		Resources: []kafka.DescribeConfigRequestResource{{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: topic,
+			ConfigNames:  []string{"cleanup.policy"},
+		}},
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, resource := range described.Resources {
+		if resource.Error != nil {
+			return false, resource.Error
+		}
+		for _, entry := range resource.ConfigEntries {
+			if entry.ConfigName == "cleanup.policy" && entry.ConfigValue == "compact" {
+				return false, nil
+			}
+		}
+	}
+
+	altered, err := client.IncrementalAlterConfigs(ctx, &kafka.IncrementalAlterConfigsRequest{
+		Resources: []kafka.IncrementalAlterConfigsRequestResource{{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: topic,
+			Configs: []kafka.IncrementalAlterConfigsRequestConfig{{
+				Name:            "cleanup.policy",
+				Value:           "compact",
+				ConfigOperation: kafka.ConfigOperationSet,
+			}},
+		}},
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, resource := range altered.Resources {
+		if resource.Error != nil {
+			return false, resource.Error
+		}
+	}
+	return true, nil
+}